@@ -0,0 +1,36 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"jinzmedia-atmt/database"
+	"jinzmedia-atmt/models"
+)
+
+// Logger writes entries to the audit trail collection
+type Logger struct {
+	collection *mongo.Collection
+}
+
+// NewLogger creates a new audit trail logger
+func NewLogger() *Logger {
+	return &Logger{
+		collection: database.GetCollection("audit_logs"),
+	}
+}
+
+// Record appends an entry to the audit trail
+func (l *Logger) Record(ctx context.Context, entry *models.AuditEntry) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	if _, err := l.collection.InsertOne(ctx, entry); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"jinzmedia-atmt/database"
+	"jinzmedia-atmt/i18n"
+	"jinzmedia-atmt/mailer"
+	"jinzmedia-atmt/models"
+)
+
+var (
+	ErrInvalidProfile           = errors.New("invalid profile data")
+	ErrEmailReserved            = errors.New("email address belongs to a recently deleted account")
+	ErrEmailUnchanged           = errors.New("new email is the same as the current one")
+	ErrEmailVerificationInvalid = errors.New("email verification token is invalid or has expired")
+	ErrDeleteConfirmation       = errors.New("account deletion was not confirmed")
+)
+
+// UpdateProfile applies the editable profile fields present in req
+func (s *AuthService) UpdateProfile(ctx context.Context, user *models.User, req *models.UpdateProfileRequest) (*models.User, error) {
+	set := bson.M{}
+
+	if req.FullName != nil {
+		fullName := strings.TrimSpace(*req.FullName)
+		if fullName == "" || len(fullName) > 100 {
+			return nil, fmt.Errorf("%w: full_name must be 1-100 characters", ErrInvalidProfile)
+		}
+		set["full_name"] = fullName
+	}
+
+	if req.DateOfBirth != nil {
+		dob := *req.DateOfBirth
+		if dob.After(time.Now()) || dob.Year() < 1900 {
+			return nil, fmt.Errorf("%w: date_of_birth is out of range", ErrInvalidProfile)
+		}
+		set["date_of_birth"] = dob
+	}
+
+	if req.Platform != nil {
+		if !models.IsKnownPlatform(string(*req.Platform)) {
+			return nil, fmt.Errorf("%w: platform must be windows, macos or linux", ErrInvalidProfile)
+		}
+		set["platform"] = *req.Platform
+	}
+
+	if req.Language != nil {
+		language := i18n.Normalize(*req.Language)
+		if language == "" {
+			return nil, fmt.Errorf("%w: language must be vi or en", ErrInvalidProfile)
+		}
+		set["language"] = language
+	}
+
+	if len(set) == 0 {
+		return nil, fmt.Errorf("%w: no editable fields given", ErrInvalidProfile)
+	}
+
+	set["updated_at"] = time.Now()
+	if _, err := s.userCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": set}); err != nil {
+		return nil, fmt.Errorf("failed to update profile: %w", err)
+	}
+
+	return s.GetUserByID(ctx, user.ID)
+}
+
+// RequestEmailChange stores the new address as pending and emails it a
+// verification link. The current email stays active until verified.
+func (s *AuthService) RequestEmailChange(ctx context.Context, user *models.User, newEmail, password string) error {
+	newEmail = strings.ToLower(strings.TrimSpace(newEmail))
+	if _, err := mail.ParseAddress(newEmail); err != nil {
+		return fmt.Errorf("%w: invalid email address", ErrInvalidProfile)
+	}
+	if strings.EqualFold(newEmail, user.Email) {
+		return ErrEmailUnchanged
+	}
+	if user.Password != "" && !s.verifyPassword(password, user.Password) {
+		return ErrInvalidCredentials
+	}
+	if err := s.checkEmailAvailable(ctx, newEmail); err != nil {
+		return err
+	}
+
+	token, err := randomURLToken(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate verification token: %w", err)
+	}
+
+	expiration := s.cfg.Account.EmailVerificationExpiration
+	if expiration <= 0 {
+		expiration = 24 * time.Hour
+	}
+	expiresAt := time.Now().Add(expiration)
+
+	_, err = s.userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{
+			"pending_email":                 newEmail,
+			"email_verification_hash":       hashToken(token),
+			"email_verification_expires_at": expiresAt,
+			"updated_at":                    time.Now(),
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save pending email: %w", err)
+	}
+
+	verifyURL := strings.ReplaceAll(s.cfg.Account.EmailVerificationURL, "{token}", token)
+	err = s.outbox.Enqueue(ctx, &user.ID, newEmail, mailer.TemplateEmailVerification, user.Language, map[string]interface{}{
+		"FullName":  user.FullName,
+		"NewEmail":  newEmail,
+		"VerifyURL": verifyURL,
+		"ExpiresIn": expiration.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to queue verification email: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyEmailChange applies a pending email change identified by its token
+func (s *AuthService) VerifyEmailChange(ctx context.Context, token string) (*models.User, error) {
+	var user models.User
+	err := s.userCollection.FindOne(ctx, bson.M{
+		"email_verification_hash":       hashToken(token),
+		"email_verification_expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrEmailVerificationInvalid
+		}
+		return nil, err
+	}
+
+	// The address may have been taken since the change was requested
+	if err := s.checkEmailAvailable(ctx, user.PendingEmail); err != nil {
+		return nil, err
+	}
+
+	oldEmail := user.Email
+	_, err = s.userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "email_verification_hash": user.EmailVerificationHash},
+		bson.M{
+			"$set": bson.M{"email": user.PendingEmail, "updated_at": time.Now()},
+			"$unset": bson.M{
+				"pending_email":                 "",
+				"email_verification_hash":       "",
+				"email_verification_expires_at": "",
+			},
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to change email: %w", err)
+	}
+
+	log.Printf("ACCOUNT: User %s changed email from %s to %s", user.ID.Hex(), oldEmail, user.PendingEmail)
+	return s.GetUserByID(ctx, user.ID)
+}
+
+// DeleteAccount anonymizes the user's personal data and disables the account.
+// The user document, payments and download records are kept for accounting;
+// the former email stays reserved for the configured period.
+func (s *AuthService) DeleteAccount(ctx context.Context, user *models.User, req *models.DeleteAccountRequest) error {
+	if user.Password != "" {
+		if !s.verifyPassword(req.Password, user.Password) {
+			return ErrInvalidCredentials
+		}
+	} else if !strings.EqualFold(strings.TrimSpace(req.ConfirmEmail), user.Email) {
+		return ErrDeleteConfirmation
+	}
+
+	if user.TwoFactorEnabled {
+		if err := s.verifySecondFactor(ctx, user, req.Code); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	reusableAt := now.Add(s.cfg.Account.DeletedEmailReuseAfter)
+
+	_, err := s.userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$set": bson.M{
+				"email":              "deleted-" + user.ID.Hex() + "@deleted.invalid",
+				"full_name":          "Deleted user",
+				"date_of_birth":      time.Time{},
+				"serial_number":      "",
+				"password":           "",
+				"is_active":          false,
+				"two_factor_enabled": false,
+				"deleted_at":         now,
+				"deleted_email_hash": hashEmail(user.Email),
+				"email_reusable_at":  reusableAt,
+				"updated_at":         now,
+			},
+			"$unset": bson.M{
+				"two_factor_secret":             "",
+				"two_factor_pending_secret":     "",
+				"two_factor_last_step":          "",
+				"recovery_codes":                "",
+				"identities":                    "",
+				"pending_email":                 "",
+				"email_verification_hash":       "",
+				"email_verification_expires_at": "",
+				"last_login":                    "",
+			},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to anonymize account: %w", err)
+	}
+
+	// Credentials tied to the account stop working immediately
+	if _, err := s.apiKeyCollection.UpdateMany(ctx,
+		bson.M{"user_id": user.ID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": now}},
+	); err != nil {
+		log.Printf("ACCOUNT ERROR: Failed to revoke API keys of deleted user %s: %v", user.ID.Hex(), err)
+	}
+	if _, err := database.GetCollection("oidc_states").DeleteMany(ctx, bson.M{"user_id": user.ID}); err != nil {
+		log.Printf("ACCOUNT ERROR: Failed to remove sign-in states of deleted user %s: %v", user.ID.Hex(), err)
+	}
+	if _, err := database.GetCollection("license_activations").UpdateMany(ctx,
+		bson.M{"user_id": user.ID},
+		bson.M{"$set": bson.M{
+			"status":              models.LicenseActivationDeactivated,
+			"deactivated_at":      now,
+			"deactivation_reason": models.DeactivationReasonAccountDeleted,
+			"serial_number":       "",
+			"device_name":         "",
+			"ip_address":          "",
+		}},
+	); err != nil {
+		log.Printf("ACCOUNT ERROR: Failed to release license devices of deleted user %s: %v", user.ID.Hex(), err)
+	}
+
+	log.Printf("ACCOUNT: User %s deleted their account", user.ID.Hex())
+	return nil
+}
+
+// checkEmailAvailable returns ErrUserExists if an account uses the email and
+// ErrEmailReserved if a recently deleted account used it
+func (s *AuthService) checkEmailAvailable(ctx context.Context, email string) error {
+	if _, err := s.GetUserByEmail(ctx, email); err == nil {
+		return ErrUserExists
+	} else if err != ErrUserNotFound {
+		return err
+	}
+
+	count, err := s.userCollection.CountDocuments(ctx, bson.M{
+		"deleted_email_hash": hashEmail(email),
+		"email_reusable_at":  bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check email reservation: %w", err)
+	}
+	if count > 0 {
+		return ErrEmailReserved
+	}
+
+	return nil
+}
+
+// hashEmail hashes a normalized email so deleted accounts keep no readable address
+func hashEmail(email string) string {
+	return hashToken(strings.ToLower(strings.TrimSpace(email)))
+}
+
+// hashToken returns the hex SHA-256 of a token for storage
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
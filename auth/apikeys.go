@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"jinzmedia-atmt/models"
+)
+
+// apiKeyPrefix marks ATMT keys so they are easy to spot in secret scanners
+const apiKeyPrefix = "atmt_"
+
+// apiKeyUsageInterval limits how often last-used tracking writes to the database
+const apiKeyUsageInterval = time.Minute
+
+var (
+	ErrInvalidAPIKey     = errors.New("invalid API key")
+	ErrAPIKeyNotFound    = errors.New("API key not found")
+	ErrAPIKeyLimit       = errors.New("API key limit reached")
+	ErrInvalidAPIKeyData = errors.New("invalid API key request")
+)
+
+// CreateAPIKey creates a named, scoped key for the user and returns the
+// secret, which is not stored and cannot be shown again
+func (s *AuthService) CreateAPIKey(ctx context.Context, user *models.User, req *models.CreateAPIKeyRequest) (*models.CreateAPIKeyResponse, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		return nil, fmt.Errorf("%w: name is required (max 100 characters)", ErrInvalidAPIKeyData)
+	}
+
+	scopes, err := normalizeScopes(req.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt, err := s.apiKeyExpiry(req.ExpiresInDays)
+	if err != nil {
+		return nil, err
+	}
+
+	if max := s.cfg.APIKeys.MaxPerUser; max > 0 {
+		active, err := s.apiKeyCollection.CountDocuments(ctx, activeAPIKeyFilter(bson.M{"user_id": user.ID}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to count API keys: %w", err)
+		}
+		if active >= int64(max) {
+			return nil, ErrAPIKeyLimit
+		}
+	}
+
+	secret, err := randomURLToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+	rawKey := apiKeyPrefix + secret
+
+	key := &models.APIKey{
+		UserID:    user.ID,
+		Name:      name,
+		Prefix:    rawKey[:len(apiKeyPrefix)+6],
+		KeyHash:   hashAPIKey(rawKey),
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	result, err := s.apiKeyCollection.InsertOne(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API key: %w", err)
+	}
+	key.ID = result.InsertedID.(primitive.ObjectID)
+
+	return &models.CreateAPIKeyResponse{APIKey: key, Key: rawKey}, nil
+}
+
+// ListAPIKeys returns all keys of a user, newest first, including revoked ones
+func (s *AuthService) ListAPIKeys(ctx context.Context, userID primitive.ObjectID) ([]models.APIKey, error) {
+	cursor, err := s.apiKeyCollection.Find(ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	keys := []models.APIKey{}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return nil, fmt.Errorf("failed to decode API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes one of the user's keys. Revoking twice is a no-op.
+func (s *AuthService) RevokeAPIKey(ctx context.Context, userID, keyID primitive.ObjectID) error {
+	result, err := s.apiKeyCollection.UpdateOne(ctx,
+		bson.M{"_id": keyID, "user_id": userID},
+		bson.M{"$min": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// ValidateAPIKey resolves a raw key to its owner, rejecting revoked and
+// expired keys, and records when and from where it was last used
+func (s *AuthService) ValidateAPIKey(ctx context.Context, rawKey, clientIP string) (*models.User, *models.APIKey, error) {
+	if !strings.HasPrefix(rawKey, apiKeyPrefix) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	var key models.APIKey
+	err := s.apiKeyCollection.FindOne(ctx, activeAPIKeyFilter(bson.M{"key_hash": hashAPIKey(rawKey)})).Decode(&key)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+
+	user, err := s.GetUserByID(ctx, key.UserID)
+	if err != nil {
+		return nil, nil, ErrUserNotFound
+	}
+	if !user.IsActive {
+		return nil, nil, ErrAccountDisabled
+	}
+
+	s.touchAPIKey(ctx, &key, clientIP)
+	return user, &key, nil
+}
+
+// touchAPIKey updates last-used tracking at most once per apiKeyUsageInterval
+func (s *AuthService) touchAPIKey(ctx context.Context, key *models.APIKey, clientIP string) {
+	now := time.Now()
+	_, err := s.apiKeyCollection.UpdateOne(ctx,
+		bson.M{
+			"_id": key.ID,
+			"$or": []bson.M{
+				{"last_used_at": bson.M{"$lt": now.Add(-apiKeyUsageInterval)}},
+				{"last_used_at": bson.M{"$exists": false}},
+				{"last_used_ip": bson.M{"$ne": clientIP}},
+			},
+		},
+		bson.M{"$set": bson.M{"last_used_at": now, "last_used_ip": clientIP}},
+	)
+	if err != nil {
+		// Log error but don't fail the request
+		log.Printf("API KEY ERROR: Failed to record usage of key %s: %v", key.ID.Hex(), err)
+		return
+	}
+
+	key.LastUsedAt = &now
+	key.LastUsedIP = clientIP
+}
+
+// apiKeyExpiry computes the expiry time for a new key from the requested
+// lifetime and the configured default and maximum
+func (s *AuthService) apiKeyExpiry(expiresInDays int) (*time.Time, error) {
+	if expiresInDays < 0 {
+		return nil, fmt.Errorf("%w: expires_in_days must not be negative", ErrInvalidAPIKeyData)
+	}
+
+	lifetime := s.cfg.APIKeys.DefaultLifetime
+	if expiresInDays > 0 {
+		lifetime = time.Duration(expiresInDays) * 24 * time.Hour
+	}
+
+	if max := s.cfg.APIKeys.MaxLifetime; max > 0 && (lifetime <= 0 || lifetime > max) {
+		if expiresInDays > 0 {
+			return nil, fmt.Errorf("%w: expires_in_days must be at most %d", ErrInvalidAPIKeyData, int(max.Hours()/24))
+		}
+		lifetime = max
+	}
+
+	if lifetime <= 0 {
+		return nil, nil
+	}
+
+	expiresAt := time.Now().Add(lifetime)
+	return &expiresAt, nil
+}
+
+// normalizeScopes validates and de-duplicates requested scopes
+func normalizeScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidAPIKeyData)
+	}
+
+	seen := make(map[string]bool)
+	var scopes []string
+	for _, scope := range requested {
+		scope = strings.TrimSpace(scope)
+		known := false
+		for _, allowed := range models.APIKeyScopes {
+			if scope == allowed {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("%w: unknown scope %q", ErrInvalidAPIKeyData, scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// activeAPIKeyFilter adds "not revoked and not expired" to a filter
+func activeAPIKeyFilter(filter bson.M) bson.M {
+	filter["revoked_at"] = bson.M{"$exists": false}
+	filter["$or"] = []bson.M{
+		{"expires_at": bson.M{"$exists": false}},
+		{"expires_at": bson.M{"$gt": time.Now()}},
+	}
+	return filter
+}
+
+// hashAPIKey hashes a key for storage. Keys carry 256 bits of randomness, so
+// a fast hash is sufficient and allows direct lookup.
+func hashAPIKey(key string) string {
+	return hashToken(key)
+}
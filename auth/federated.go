@@ -0,0 +1,318 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"jinzmedia-atmt/database"
+	"jinzmedia-atmt/models"
+)
+
+var (
+	ErrOIDCProviderNotFound = errors.New("sign-in provider is not available")
+	ErrOIDCStateInvalid     = errors.New("sign-in request is invalid or has expired")
+	ErrOIDCEmailNotVerified = errors.New("provider email address is not verified")
+	ErrIdentityLinked       = errors.New("provider account is already linked to another user")
+	ErrIdentityNotLinked    = errors.New("provider is not linked to this account")
+	ErrLastSignInMethod     = errors.New("cannot remove the only sign-in method")
+)
+
+// OIDCCallbackResult is the outcome of a completed provider callback
+type OIDCCallbackResult struct {
+	Intent models.OIDCIntent
+	Login  *models.LoginResponse // Set for login intent
+	User   *models.User          // Set for link intent
+}
+
+// BeginOIDC starts an authorization code flow and returns the provider URL
+// and a browser key. The caller hands the key to the browser in a cookie;
+// the callback is only accepted from a browser presenting it, so a state
+// cannot be completed in someone else's browser.
+// For link intent, userID is the signed-in account the identity is attached to.
+func (s *AuthService) BeginOIDC(ctx context.Context, providerName string, intent models.OIDCIntent, userID *primitive.ObjectID) (string, string, error) {
+	provider, err := getOIDCProvider(providerName)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomURLToken(32)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate state: %w", err)
+	}
+	nonce, err := randomURLToken(32)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	verifier, err := randomURLToken(48)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate code verifier: %w", err)
+	}
+	browserKey, err := randomURLToken(32)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate browser key: %w", err)
+	}
+
+	authURL, err := provider.AuthorizationURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	now := time.Now()
+	stateCollection := database.GetCollection("oidc_states")
+
+	// Drop abandoned attempts
+	if _, err := stateCollection.DeleteMany(ctx, bson.M{"expires_at": bson.M{"$lt": now}}); err != nil {
+		log.Printf("OIDC ERROR: Failed to clean up expired states: %v", err)
+	}
+
+	_, err = stateCollection.InsertOne(ctx, &models.OIDCState{
+		State:        state,
+		Provider:     providerName,
+		Intent:       intent,
+		UserID:       userID,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		BrowserHash:  hashToken(browserKey),
+		ExpiresAt:    now.Add(s.oidcStateExpiration()),
+		CreatedAt:    now,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to save state: %w", err)
+	}
+
+	return authURL, browserKey, nil
+}
+
+// CompleteOIDC handles the provider callback: it consumes the state, exchanges
+// the code, validates the ID token and then signs in or links the account.
+// browserKey is the key BeginOIDC issued to the browser that started the flow.
+func (s *AuthService) CompleteOIDC(ctx context.Context, providerName, code, state, browserKey string) (*OIDCCallbackResult, error) {
+	provider, err := getOIDCProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	if browserKey == "" {
+		return nil, ErrOIDCStateInvalid
+	}
+
+	// Each state is single-use
+	var pending models.OIDCState
+	err = database.GetCollection("oidc_states").FindOneAndDelete(ctx, bson.M{
+		"state":        state,
+		"provider":     providerName,
+		"browser_hash": hashToken(browserKey),
+		"expires_at":   bson.M{"$gt": time.Now()},
+	}).Decode(&pending)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrOIDCStateInvalid
+		}
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+
+	idToken, err := provider.Exchange(ctx, code, pending.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := provider.VerifyIDToken(ctx, idToken, pending.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	identity := models.FederatedIdentity{
+		Provider: providerName,
+		Subject:  claims.Subject,
+		Email:    strings.ToLower(strings.TrimSpace(claims.Email)),
+		LinkedAt: time.Now(),
+	}
+
+	if pending.Intent == models.OIDCIntentLink {
+		if pending.UserID == nil {
+			return nil, ErrOIDCStateInvalid
+		}
+		user, err := s.linkIdentity(ctx, *pending.UserID, identity)
+		if err != nil {
+			return nil, err
+		}
+		return &OIDCCallbackResult{Intent: models.OIDCIntentLink, User: user}, nil
+	}
+
+	user, err := s.findOrCreateFederatedUser(ctx, identity, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.IsActive {
+		return nil, ErrAccountDisabled
+	}
+
+	// The provider replaces the password, not the second factor
+	var login *models.LoginResponse
+	if user.TwoFactorEnabled {
+		login, err = s.issueMFAChallenge(user)
+	} else {
+		login, err = s.completeLogin(ctx, user)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDCCallbackResult{Intent: models.OIDCIntentLogin, Login: login}, nil
+}
+
+// UnlinkIdentity removes a provider from the user's account. The last sign-in
+// method of an account without a password cannot be removed.
+func (s *AuthService) UnlinkIdentity(ctx context.Context, user *models.User, providerName string) error {
+	linked := false
+	for _, identity := range user.Identities {
+		if identity.Provider == providerName {
+			linked = true
+			break
+		}
+	}
+	if !linked {
+		return ErrIdentityNotLinked
+	}
+
+	if user.Password == "" && len(user.Identities) <= 1 {
+		return ErrLastSignInMethod
+	}
+
+	_, err := s.userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$pull": bson.M{"identities": bson.M{"provider": providerName}},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to unlink provider: %w", err)
+	}
+
+	return nil
+}
+
+// findOrCreateFederatedUser resolves the user for a provider login: an already
+// linked account, else an account with the same verified email (which gets
+// linked), else a new account
+func (s *AuthService) findOrCreateFederatedUser(ctx context.Context, identity models.FederatedIdentity, claims *oidcClaims) (*models.User, error) {
+	user, err := s.getUserByIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if err != ErrUserNotFound {
+		return nil, err
+	}
+
+	// Matching or creating by email is only safe when the provider verified it
+	if identity.Email == "" || !claims.emailVerified() {
+		return nil, ErrOIDCEmailNotVerified
+	}
+
+	user, err = s.GetUserByEmail(ctx, identity.Email)
+	if err == nil {
+		log.Printf("OIDC: Linking %s account to existing user %s by verified email", identity.Provider, user.Email)
+		return s.linkIdentity(ctx, user.ID, identity)
+	}
+	if err != ErrUserNotFound {
+		return nil, err
+	}
+
+	if err := s.checkEmailAvailable(ctx, identity.Email); err != nil {
+		return nil, err
+	}
+
+	fullName := claims.Name
+	if fullName == "" {
+		fullName = identity.Email
+	}
+
+	user = &models.User{
+		Email:      identity.Email,
+		FullName:   fullName,
+		Role:       string(models.RoleUser),
+		IsActive:   true,
+		Owned:      false,
+		IsBanned:   false,
+		Identities: []models.FederatedIdentity{identity},
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+
+	result, err := s.userCollection.InsertOne(ctx, user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	user.ID = result.InsertedID.(primitive.ObjectID)
+	log.Printf("OIDC: Created user %s from %s sign-in", user.Email, identity.Provider)
+	return user, nil
+}
+
+// linkIdentity attaches a provider identity to a user, replacing any earlier
+// identity from the same provider
+func (s *AuthService) linkIdentity(ctx context.Context, userID primitive.ObjectID, identity models.FederatedIdentity) (*models.User, error) {
+	owner, err := s.getUserByIdentity(ctx, identity.Provider, identity.Subject)
+	if err == nil {
+		if owner.ID != userID {
+			return nil, ErrIdentityLinked
+		}
+		return owner, nil
+	}
+	if err != ErrUserNotFound {
+		return nil, err
+	}
+
+	_, err = s.userCollection.UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{
+			"$pull": bson.M{"identities": bson.M{"provider": identity.Provider}},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to link provider: %w", err)
+	}
+
+	_, err = s.userCollection.UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$push": bson.M{"identities": identity}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to link provider: %w", err)
+	}
+
+	return s.GetUserByID(ctx, userID)
+}
+
+// getUserByIdentity retrieves the user linked to a provider subject
+func (s *AuthService) getUserByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	var user models.User
+	err := s.userCollection.FindOne(ctx, bson.M{
+		"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}},
+	}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *AuthService) oidcStateExpiration() time.Duration {
+	if s.cfg.OIDC.StateExpiration > 0 {
+		return s.cfg.OIDC.StateExpiration
+	}
+	return 10 * time.Minute
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"jinzmedia-atmt/models"
+)
+
+var (
+	ErrImpersonationReason    = errors.New("a reason is required to impersonate a user")
+	ErrCannotImpersonateAdmin = errors.New("admin accounts cannot be impersonated")
+)
+
+// ImpersonateUser issues a short-lived token that acts as the target user.
+// The token records the real admin in its "act" claim and the start is
+// written to the audit trail.
+func (s *AuthService) ImpersonateUser(ctx context.Context, admin *models.User, targetID primitive.ObjectID, reason, clientIP, requestID string) (*models.ImpersonateResponse, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrImpersonationReason
+	}
+
+	target, err := s.GetUserByID(ctx, targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Impersonating an admin would be a privilege escalation path
+	if isAdminRole(target.Role) {
+		return nil, ErrCannotImpersonateAdmin
+	}
+	if !target.IsActive {
+		return nil, ErrAccountDisabled
+	}
+
+	expiration := s.cfg.Impersonate.TokenExpiration
+	if expiration <= 0 {
+		expiration = 15 * time.Minute
+	}
+
+	impersonationID := primitive.NewObjectID().Hex()
+	now := time.Now()
+
+	claims := jwt.MapClaims{
+		"user_id": target.ID.Hex(),
+		"email":   target.Email,
+		"role":    target.Role,
+		"type":    TokenTypeImpersonation,
+		"jti":     impersonationID,
+		"act": map[string]interface{}{
+			"sub":   admin.ID.Hex(),
+			"email": admin.Email,
+		},
+		"exp": now.Add(expiration).Unix(),
+		"iat": now.Unix(),
+	}
+	if s.cfg.JWT.Issuer != "" {
+		claims["iss"] = s.cfg.JWT.Issuer
+	}
+
+	token, err := s.keys.Sign(claims)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate impersonation token: %w", err)
+	}
+
+	err = s.auditLog.Record(ctx, &models.AuditEntry{
+		Action:          models.AuditImpersonationStart,
+		ActorID:         admin.ID,
+		ActorEmail:      admin.Email,
+		SubjectUserID:   &target.ID,
+		SubjectEmail:    target.Email,
+		ImpersonationID: impersonationID,
+		IP:              clientIP,
+		RequestID:       requestID,
+		Details: map[string]interface{}{
+			"reason":     reason,
+			"expires_at": now.Add(expiration),
+		},
+	})
+	if err != nil {
+		// No audit record, no impersonation
+		return nil, err
+	}
+
+	log.Printf("IMPERSONATION: %s started impersonating %s (%s): %s", admin.Email, target.Email, impersonationID, reason)
+
+	return &models.ImpersonateResponse{
+		Token:           token,
+		ExpiresAt:       now.Add(expiration).Unix(),
+		ImpersonationID: impersonationID,
+		User:            target,
+	}, nil
+}
+
+// recordImpersonation writes an impersonated request to the audit trail. It
+// outlives the request context so cancelled requests are still recorded.
+func (s *AuthService) recordImpersonation(r *http.Request, user *models.User, action string, statusCode int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := s.auditLog.Record(ctx, &models.AuditEntry{
+		Action:          action,
+		ActorID:         user.ImpersonatedBy.AdminID,
+		ActorEmail:      user.ImpersonatedBy.AdminEmail,
+		SubjectUserID:   &user.ID,
+		SubjectEmail:    user.Email,
+		ImpersonationID: user.ImpersonatedBy.ImpersonationID,
+		Method:          r.Method,
+		Path:            r.URL.Path,
+		StatusCode:      statusCode,
+		IP:              requestIP(r),
+		RequestID:       middleware.GetReqID(r.Context()),
+	})
+	if err != nil {
+		log.Printf("AUDIT ERROR: Failed to record %s for %s: %v", action, user.ImpersonatedBy.ImpersonationID, err)
+	}
+}
+
+// impersonatorFromClaims reads the real admin from an impersonation token
+func impersonatorFromClaims(claims jwt.MapClaims) (*models.Impersonator, error) {
+	act, ok := claims["act"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("missing act claim")
+	}
+
+	adminIDHex, _ := act["sub"].(string)
+	adminID, err := primitive.ObjectIDFromHex(adminIDHex)
+	if err != nil {
+		return nil, errors.New("invalid act claim")
+	}
+
+	adminEmail, _ := act["email"].(string)
+	impersonationID, _ := claims["jti"].(string)
+
+	return &models.Impersonator{
+		AdminID:         adminID,
+		AdminEmail:      adminEmail,
+		ImpersonationID: impersonationID,
+	}, nil
+}
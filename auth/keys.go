@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"jinzmedia-atmt/config"
+)
+
+// defaultJWTSecret is the placeholder committed in config.yaml
+const defaultJWTSecret = "your-super-secret-jwt-key"
+
+// signingKey is an asymmetric key identified by its kid header
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer // nil for verification-only (rotated out) keys
+	public  crypto.PublicKey
+}
+
+// KeySet holds the key used to sign new tokens and all keys accepted when
+// verifying, so keys can be rotated without invalidating issued tokens
+type KeySet struct {
+	method     jwt.SigningMethod
+	active     *signingKey // nil when signing with HS256
+	keys       map[string]*signingKey
+	hmacSecret []byte
+	acceptHMAC bool
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+var (
+	keySet     *KeySet
+	keySetErr  error
+	keySetOnce sync.Once
+)
+
+// LoadKeys loads the JWT keys from config. Safe to call more than once.
+func LoadKeys() error {
+	keySetOnce.Do(func() {
+		cfg := config.Get()
+		keySet, keySetErr = NewKeySet(&cfg.JWT, cfg.IsProduction())
+	})
+	return keySetErr
+}
+
+// Keys returns the loaded key set
+func Keys() *KeySet {
+	if err := LoadKeys(); err != nil {
+		panic("JWT keys not loaded: " + err.Error())
+	}
+	return keySet
+}
+
+// NewKeySet builds a key set from JWT configuration
+func NewKeySet(jwtCfg *config.JWTConfig, production bool) (*KeySet, error) {
+	alg := jwtCfg.Algorithm
+	if alg == "" {
+		alg = jwt.SigningMethodHS256.Alg()
+	}
+
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported JWT algorithm: %s", alg)
+	}
+
+	ks := &KeySet{
+		method:     method,
+		keys:       make(map[string]*signingKey),
+		hmacSecret: []byte(jwtCfg.Secret),
+		acceptHMAC: jwtCfg.AcceptHS256,
+	}
+
+	for _, keyCfg := range jwtCfg.Keys {
+		key, err := loadSigningKey(keyCfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load JWT key %q: %w", keyCfg.ID, err)
+		}
+		if _, exists := ks.keys[key.id]; exists {
+			return nil, fmt.Errorf("duplicate JWT key id: %s", key.id)
+		}
+		ks.keys[key.id] = key
+	}
+
+	if _, ok := method.(*jwt.SigningMethodHMAC); ok {
+		// Legacy shared-secret signing
+		ks.acceptHMAC = true
+	} else {
+		active, ok := ks.keys[jwtCfg.ActiveKeyID]
+		if !ok {
+			return nil, fmt.Errorf("active JWT key %q not found in jwt.keys", jwtCfg.ActiveKeyID)
+		}
+		if active.private == nil {
+			return nil, fmt.Errorf("active JWT key %q has no private key", active.id)
+		}
+		if active.method.Alg() != method.Alg() {
+			return nil, fmt.Errorf("active JWT key %q uses %s, expected %s", active.id, active.method.Alg(), method.Alg())
+		}
+		ks.active = active
+	}
+
+	if ks.acceptHMAC {
+		if jwtCfg.Secret == "" {
+			return nil, errors.New("jwt.secret is required while HS256 is enabled")
+		}
+		if production && jwtCfg.Secret == defaultJWTSecret {
+			return nil, errors.New("refusing to use the default jwt.secret in production")
+		}
+	}
+
+	return ks, nil
+}
+
+// Sign signs claims with the active key, adding its kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.method, claims)
+
+	if ks.active == nil {
+		return token.SignedString(ks.hmacSecret)
+	}
+
+	token.Header["kid"] = ks.active.id
+	return token.SignedString(ks.active.private)
+}
+
+// Parse verifies a token against the key named by its kid header, or the
+// shared secret for legacy HS256 tokens
+func (ks *KeySet) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, ks.keyFunc, jwt.WithValidMethods(ks.validMethods()))
+}
+
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok && ks.acceptHMAC {
+			return ks.hmacSecret, nil
+		}
+		return nil, errors.New("token has no kid header")
+	}
+
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id: %s", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %s", token.Method.Alg(), kid)
+	}
+	return key.public, nil
+}
+
+func (ks *KeySet) validMethods() []string {
+	seen := make(map[string]bool)
+	var methods []string
+	if ks.acceptHMAC {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+		seen[jwt.SigningMethodHS256.Alg()] = true
+	}
+	for _, key := range ks.keys {
+		if !seen[key.method.Alg()] {
+			methods = append(methods, key.method.Alg())
+			seen[key.method.Alg()] = true
+		}
+	}
+	return methods
+}
+
+// JWKS returns all public verification keys
+func (ks *KeySet) JWKS() *JWKS {
+	doc := &JWKS{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		jwk := JWK{Kid: key.id, Use: "sig", Alg: key.method.Alg()}
+
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+
+		doc.Keys = append(doc.Keys, jwk)
+	}
+
+	sort.Slice(doc.Keys, func(i, j int) bool { return doc.Keys[i].Kid < doc.Keys[j].Kid })
+	return doc
+}
+
+// loadSigningKey reads a key pair (or public key only) from PEM files
+func loadSigningKey(keyCfg config.JWTKeyConfig) (*signingKey, error) {
+	if keyCfg.ID == "" {
+		return nil, errors.New("key id is required")
+	}
+
+	method := jwt.GetSigningMethod(keyCfg.Algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported algorithm: %s", keyCfg.Algorithm)
+	}
+
+	key := &signingKey{id: keyCfg.ID, method: method}
+
+	if keyCfg.PrivateKeyFile != "" {
+		data, err := os.ReadFile(keyCfg.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		switch method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			priv, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.private = priv
+			key.public = &priv.PublicKey
+		case *jwt.SigningMethodEd25519:
+			priv, err := jwt.ParseEdPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			signer, ok := priv.(ed25519.PrivateKey)
+			if !ok {
+				return nil, errors.New("not an Ed25519 private key")
+			}
+			key.private = signer
+			key.public = signer.Public()
+		default:
+			return nil, fmt.Errorf("algorithm %s is not asymmetric", keyCfg.Algorithm)
+		}
+	}
+
+	if keyCfg.PublicKeyFile != "" && key.public == nil {
+		data, err := os.ReadFile(keyCfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		switch method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			pub, err := jwt.ParseRSAPublicKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.public = pub
+		case *jwt.SigningMethodEd25519:
+			pub, err := jwt.ParseEdPublicKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.public = pub
+		default:
+			return nil, fmt.Errorf("algorithm %s is not asymmetric", keyCfg.Algorithm)
+		}
+	}
+
+	if key.public == nil {
+		return nil, errors.New("private_key_file or public_key_file is required")
+	}
+
+	return key, nil
+}
@@ -2,28 +2,66 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
 	"log"
+	"net"
 	"net/http"
 	"strings"
 
+	"github.com/go-chi/chi/v5/middleware"
+
+	"jinzmedia-atmt/config"
+	"jinzmedia-atmt/i18n"
 	"jinzmedia-atmt/models"
 )
 
 type contextKey string
 
+// RequestIDHeader carries the request ID to clients so they can quote it
+const RequestIDHeader = "X-Request-ID"
+
 const (
-	UserContextKey contextKey = "user"
+	UserContextKey   contextKey = "user"
+	APIKeyContextKey contextKey = "api_key"
 )
 
-// AuthMiddleware is a middleware that validates JWT tokens
+// AuthMiddleware is a middleware that validates JWT tokens or personal API
+// keys (X-API-Key or "Authorization: ApiKey <key>")
 func AuthMiddleware(authService *AuthService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Get token from Authorization header
 			authHeader := r.Header.Get("Authorization")
+
+			// API key authentication
+			apiKey := r.Header.Get("X-API-Key")
+			if apiKey == "" && strings.HasPrefix(authHeader, "ApiKey ") {
+				apiKey = strings.TrimSpace(strings.TrimPrefix(authHeader, "ApiKey "))
+			}
+			if apiKey != "" {
+				user, key, err := authService.ValidateAPIKey(r.Context(), apiKey, requestIP(r))
+				if err != nil {
+					log.Printf("AUTH ERROR: API key validation failed for %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+					if err == ErrAccountDisabled {
+						writeErrorResponse(w, http.StatusUnauthorized, models.ErrCodeAccountDisabled, "Account is disabled")
+						return
+					}
+					writeErrorResponse(w, http.StatusUnauthorized, models.ErrCodeAPIKeyInvalid, "Invalid, expired or revoked API key")
+					return
+				}
+
+				log.Printf("AUTH SUCCESS: User %s authenticated with API key %s for %s %s", user.Email, key.Prefix, r.Method, r.URL.Path)
+				i18n.SetLanguage(w, user.Language)
+
+				ctx := context.WithValue(r.Context(), UserContextKey, user)
+				ctx = context.WithValue(ctx, APIKeyContextKey, key)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
 			if authHeader == "" {
 				log.Printf("AUTH ERROR: Missing Authorization header for %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
-				writeErrorResponse(w, http.StatusUnauthorized, "Authorization header required")
+				writeErrorResponse(w, http.StatusUnauthorized, models.ErrCodeAuthRequired, "Authorization header required")
 				return
 			}
 
@@ -31,7 +69,7 @@ func AuthMiddleware(authService *AuthService) func(http.Handler) http.Handler {
 			tokenParts := strings.Split(authHeader, " ")
 			if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
 				log.Printf("AUTH ERROR: Invalid auth header format for %s %s from %s: %s", r.Method, r.URL.Path, r.RemoteAddr, authHeader)
-				writeErrorResponse(w, http.StatusUnauthorized, "Invalid authorization header format")
+				writeErrorResponse(w, http.StatusUnauthorized, models.ErrCodeTokenInvalid, "Invalid authorization header format")
 				return
 			}
 
@@ -39,17 +77,33 @@ func AuthMiddleware(authService *AuthService) func(http.Handler) http.Handler {
 			log.Printf("AUTH DEBUG: Validating token for %s %s from %s (token: %s...)", r.Method, r.URL.Path, r.RemoteAddr, token[:10])
 
 			// Validate token
-			user, err := authService.ValidateToken(token)
+			user, err := authService.ValidateRequestToken(token)
 			if err != nil {
 				log.Printf("AUTH ERROR: Token validation failed for %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
-				writeErrorResponse(w, http.StatusUnauthorized, "Invalid or expired token")
+				if err == ErrAccountDisabled {
+					writeErrorResponse(w, http.StatusUnauthorized, models.ErrCodeAccountDisabled, "Account is disabled")
+					return
+				}
+				writeErrorResponse(w, http.StatusUnauthorized, models.ErrCodeTokenInvalid, "Invalid or expired token")
 				return
 			}
 
-			log.Printf("AUTH SUCCESS: User %s authenticated for %s %s", user.Email, r.Method, r.URL.Path)
+			// Respond in the user's preferred language from here on
+			i18n.SetLanguage(w, user.Language)
 
 			// Add user to request context
 			ctx := context.WithValue(r.Context(), UserContextKey, user)
+
+			// Every impersonated request goes to the audit trail
+			if user.IsImpersonated() {
+				log.Printf("AUTH SUCCESS: %s impersonating user %s for %s %s", user.ImpersonatedBy.AdminEmail, user.Email, r.Method, r.URL.Path)
+				ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+				next.ServeHTTP(ww, r.WithContext(ctx))
+				authService.recordImpersonation(r, user, models.AuditImpersonationRequest, ww.Status())
+				return
+			}
+
+			log.Printf("AUTH SUCCESS: User %s authenticated for %s %s", user.Email, r.Method, r.URL.Path)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -61,7 +115,7 @@ func RequireRole(roles ...models.UserRole) func(http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			user := GetUserFromContext(r.Context())
 			if user == nil {
-				writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+				writeErrorResponse(w, http.StatusUnauthorized, models.ErrCodeAuthRequired, "User not authenticated")
 				return
 			}
 
@@ -75,7 +129,53 @@ func RequireRole(roles ...models.UserRole) func(http.Handler) http.Handler {
 			}
 
 			if !hasRole {
-				writeErrorResponse(w, http.StatusForbidden, "Insufficient permissions")
+				writeErrorResponse(w, http.StatusForbidden, models.ErrCodeInsufficientPermissions, "Insufficient permissions")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScope is a middleware that requires API-key requests to carry the
+// given scope. Requests authenticated with a JWT are not restricted.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := GetAPIKeyFromContext(r.Context()); key != nil && !key.HasScope(scope) {
+				writeErrorResponse(w, http.StatusForbidden, models.ErrCodeAPIKeyScope, "API key is missing the required scope: %s", scope)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession is a middleware that rejects API-key requests, for account
+// security and admin endpoints that need an interactive login
+func RequireSession() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if GetAPIKeyFromContext(r.Context()) != nil {
+				writeErrorResponse(w, http.StatusForbidden, models.ErrCodeAPIKeyNotAllowed, "This endpoint cannot be used with an API key")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// DenyImpersonation is a middleware that blocks actions an admin must not
+// take on a user's behalf, such as payments and password changes
+func DenyImpersonation(authService *AuthService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user := GetUserFromContext(r.Context()); user != nil && user.IsImpersonated() {
+				authService.recordImpersonation(r, user, models.AuditImpersonationBlocked, http.StatusForbidden)
+				writeErrorResponse(w, http.StatusForbidden, models.ErrCodeImpersonationNotAllowed, "This action is not allowed while impersonating a user")
 				return
 			}
 
@@ -86,15 +186,45 @@ func RequireRole(roles ...models.UserRole) func(http.Handler) http.Handler {
 
 // RequireAdmin is a middleware that requires admin role
 func RequireAdmin() func(http.Handler) http.Handler {
-	return RequireRole(models.RoleAdmin, models.RoleSuper)
+	return requireRoleWithTwoFactor(models.RoleAdmin, models.RoleSuper)
 }
 
 // RequireSuper is a middleware that requires super admin role
 func RequireSuper() func(http.Handler) http.Handler {
-	return RequireRole(models.RoleSuper)
+	return requireRoleWithTwoFactor(models.RoleSuper)
+}
+
+// requireRoleWithTwoFactor requires one of the roles and, when the MFA policy
+// is enabled, that the account has two-factor authentication turned on. API
+// keys and impersonation tokens never grant admin access.
+func requireRoleWithTwoFactor(roles ...models.UserRole) func(http.Handler) http.Handler {
+	requireRole := RequireRole(roles...)
+	return func(next http.Handler) http.Handler {
+		return requireRole(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if GetAPIKeyFromContext(r.Context()) != nil {
+				writeErrorResponse(w, http.StatusForbidden, models.ErrCodeAPIKeyNotAllowed, "Admin endpoints cannot be used with an API key")
+				return
+			}
+
+			user := GetUserFromContext(r.Context())
+			if user.IsImpersonated() {
+				writeErrorResponse(w, http.StatusForbidden, models.ErrCodeImpersonationNotAllowed, "Admin endpoints cannot be used while impersonating")
+				return
+			}
+
+			if config.Get().MFA.RequireForAdmins && !user.TwoFactorEnabled {
+				writeErrorResponse(w, http.StatusForbidden, models.ErrCodeMFARequired, "Two-factor authentication must be enabled for this account")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}))
+	}
 }
 
-// GetUserFromContext extracts the user from the request context
+// GetUserFromContext extracts the user from the request context. When an admin
+// is impersonating, this is the impersonated user and user.ImpersonatedBy
+// identifies the admin.
 func GetUserFromContext(ctx context.Context) *models.User {
 	user, ok := ctx.Value(UserContextKey).(*models.User)
 	if !ok {
@@ -103,9 +233,46 @@ func GetUserFromContext(ctx context.Context) *models.User {
 	return user
 }
 
-// writeErrorResponse writes an error response
-func writeErrorResponse(w http.ResponseWriter, statusCode int, message string) {
+// GetAPIKeyFromContext returns the API key used to authenticate the request,
+// or nil for JWT-authenticated requests
+func GetAPIKeyFromContext(ctx context.Context) *models.APIKey {
+	key, ok := ctx.Value(APIKeyContextKey).(*models.APIKey)
+	if !ok {
+		return nil
+	}
+	return key
+}
+
+// requestIP returns the client address without port
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// writeErrorResponse writes an error response in the shared API envelope,
+// formatting the message in the response language
+func writeErrorResponse(w http.ResponseWriter, statusCode int, code models.ErrorCode, message string, args ...interface{}) {
+	response := models.ErrorResponse{
+		Error:     i18n.Sprintf(i18n.FromHeader(w.Header()), message, args...),
+		Code:      code,
+		Status:    statusCode,
+		RequestID: w.Header().Get(RequestIDHeader),
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	w.Write([]byte(`{"error": "` + message + `"}`))
+	json.NewEncoder(w).Encode(response)
+}
+
+// ExposeRequestID copies the request ID assigned by chi's RequestID middleware
+// to the X-Request-ID response header, where error responses pick it up
+func ExposeRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := middleware.GetReqID(r.Context()); id != "" {
+			w.Header().Set(RequestIDHeader, id)
+		}
+		next.ServeHTTP(w, r)
+	})
 }
@@ -0,0 +1,375 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"jinzmedia-atmt/config"
+)
+
+// jwksRefreshInterval limits how often an unknown kid triggers a JWKS refetch
+const jwksRefreshInterval = time.Minute
+
+// oidcProvider talks to one OpenID Connect provider. Discovered endpoints and
+// signing keys are cached for the life of the process.
+type oidcProvider struct {
+	name   string
+	cfg    config.OIDCProviderConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	discovered    bool
+	keys          map[string]crypto.PublicKey
+	keysFetchedAt time.Time
+}
+
+// oidcDiscovery is the subset of the discovery document we use
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcClaims are the ID token claims we rely on
+type oidcClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string      `json:"nonce"`
+	AuthorizedBy  string      `json:"azp"`
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"` // bool, or "true"/"false" from some providers
+	Name          string      `json:"name"`
+}
+
+// emailVerified reports whether the provider vouches for the email claim
+func (c *oidcClaims) emailVerified() bool {
+	switch v := c.EmailVerified.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+var (
+	oidcProviders   = make(map[string]*oidcProvider)
+	oidcProvidersMu sync.Mutex
+)
+
+// getOIDCProvider returns the shared client for an enabled provider
+func getOIDCProvider(name string) (*oidcProvider, error) {
+	cfg := config.Get()
+
+	providerCfg, ok := cfg.OIDC.Providers[name]
+	if !ok || !providerCfg.Enabled {
+		return nil, ErrOIDCProviderNotFound
+	}
+
+	oidcProvidersMu.Lock()
+	defer oidcProvidersMu.Unlock()
+
+	if p, ok := oidcProviders[name]; ok {
+		return p, nil
+	}
+
+	timeout := cfg.ExternalAPI.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	p := &oidcProvider{
+		name:   name,
+		cfg:    providerCfg,
+		client: &http.Client{Timeout: timeout},
+	}
+	oidcProviders[name] = p
+	return p, nil
+}
+
+// AuthorizationURL builds the provider login URL for an authorization code
+// request protected by state, nonce and a PKCE S256 challenge
+func (p *oidcProvider) AuthorizationURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+
+	scopes := p.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.cfg.ClientID)
+	query.Set("redirect_uri", p.cfg.RedirectURL)
+	query.Set("scope", strings.Join(scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.cfg.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.cfg.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades an authorization code for the provider's ID token
+func (p *oidcProvider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("client_id", p.cfg.ClientID)
+	form.Set("client_secret", p.cfg.ClientSecret)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.cfg.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	var tokenResp struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", fmt.Errorf("invalid token response (status %d): %w", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %d: %s %s", resp.StatusCode, tokenResp.Error, tokenResp.ErrorDescription)
+	}
+	if tokenResp.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+
+	return tokenResp.IDToken, nil
+}
+
+// VerifyIDToken checks the ID token signature against the provider JWKS and
+// validates issuer, audience, expiry and nonce
+func (p *oidcProvider) VerifyIDToken(ctx context.Context, rawToken, nonce string) (*oidcClaims, error) {
+	claims := &oidcClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			return p.signingKey(ctx, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384"}),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	if !p.validIssuer(claims.Issuer) {
+		return nil, fmt.Errorf("unexpected id token issuer: %s", claims.Issuer)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedBy != p.cfg.ClientID {
+		return nil, errors.New("id token was not issued to this client")
+	}
+	if claims.Nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+
+	return claims, nil
+}
+
+// validIssuer accepts the configured issuer; Google also issues tokens with
+// the scheme-less form "accounts.google.com"
+func (p *oidcProvider) validIssuer(iss string) bool {
+	expected := strings.TrimSuffix(p.cfg.Issuer, "/")
+	iss = strings.TrimSuffix(iss, "/")
+	return iss == expected || "https://"+iss == expected
+}
+
+// discover fills in endpoints missing from config from the issuer's
+// /.well-known/openid-configuration document
+func (p *oidcProvider) discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovered || (p.cfg.AuthorizationEndpoint != "" && p.cfg.TokenEndpoint != "" && p.cfg.JWKSURI != "") {
+		p.discovered = true
+		return nil
+	}
+
+	if p.cfg.Issuer == "" {
+		return fmt.Errorf("oidc provider %s: issuer or endpoints must be configured", p.name)
+	}
+
+	var doc oidcDiscovery
+	discoveryURL := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, discoveryURL, &doc); err != nil {
+		return fmt.Errorf("oidc discovery failed: %w", err)
+	}
+
+	if p.cfg.AuthorizationEndpoint == "" {
+		p.cfg.AuthorizationEndpoint = doc.AuthorizationEndpoint
+	}
+	if p.cfg.TokenEndpoint == "" {
+		p.cfg.TokenEndpoint = doc.TokenEndpoint
+	}
+	if p.cfg.JWKSURI == "" {
+		p.cfg.JWKSURI = doc.JWKSURI
+	}
+	if p.cfg.AuthorizationEndpoint == "" || p.cfg.TokenEndpoint == "" || p.cfg.JWKSURI == "" {
+		return errors.New("oidc discovery document is missing endpoints")
+	}
+
+	p.discovered = true
+	return nil
+}
+
+// signingKey returns the provider key for kid, refetching the JWKS when the
+// key is unknown (providers rotate keys regularly)
+func (p *oidcProvider) signingKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	if time.Since(p.keysFetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+
+	var doc struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.cfg.JWKSURI, &doc); err != nil {
+		return nil, fmt.Errorf("failed to fetch provider keys: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+	return key, nil
+}
+
+func (p *oidcProvider) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", endpoint, resp.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// randomURLToken returns n random bytes encoded for use in URLs
+func randomURLToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP returns a middleware that replaces RemoteAddr with the client
+// address reported by a trusted reverse proxy. Forwarding headers are only
+// read when the connection itself comes from one of the trusted addresses
+// or CIDR ranges; otherwise the socket address is kept, so clients cannot
+// choose the IP that rate limits, lockouts and download quotas key on.
+func RealIP(trustedProxies []string) (func(http.Handler) http.Handler, error) {
+	var trusted []*net.IPNet
+	for _, entry := range trustedProxies {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		trusted = append(trusted, network)
+	}
+
+	isTrusted := func(ip net.IP) bool {
+		for _, network := range trusted {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(trusted) > 0 {
+				if peer := net.ParseIP(requestIP(r)); peer != nil && isTrusted(peer) {
+					if ip := forwardedIP(r, isTrusted); ip != "" {
+						r.RemoteAddr = ip
+					}
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// forwardedIP returns the first untrusted address in X-Forwarded-For,
+// reading from the proxy nearest to us backwards, or X-Real-IP when there
+// is no X-Forwarded-For. Entries further left were written by the client
+// and cannot be relied on.
+func forwardedIP(r *http.Request, isTrusted func(net.IP) bool) string {
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	if len(hops) == 0 {
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+		return ""
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// A malformed hop ends the chain we can vouch for
+			return ""
+		}
+		if !isTrusted(ip) || i == 0 {
+			return ip.String()
+		}
+	}
+	return ""
+}
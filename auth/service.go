@@ -12,9 +12,12 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 
+	"jinzmedia-atmt/audit"
 	"jinzmedia-atmt/config"
 	"jinzmedia-atmt/database"
+	"jinzmedia-atmt/mailer"
 	"jinzmedia-atmt/models"
+	"jinzmedia-atmt/ratelimit"
 )
 
 var (
@@ -23,27 +26,45 @@ var (
 	ErrUserExists         = errors.New("user already exists")
 	ErrInvalidToken       = errors.New("invalid token")
 	ErrTokenExpired       = errors.New("token expired")
+	ErrAccountDisabled    = errors.New("account is disabled")
+)
+
+// Token types carried in the "type" claim
+const (
+	TokenTypeAccess        = "access"
+	TokenTypeRefresh       = "refresh"
+	TokenTypeMFA           = "mfa"
+	TokenTypeImpersonation = "impersonation"
 )
 
 type AuthService struct {
-	userCollection *mongo.Collection
-	cfg            *config.Config
+	userCollection   *mongo.Collection
+	apiKeyCollection *mongo.Collection
+	cfg              *config.Config
+	keys             *KeySet
+	loginGuard       *ratelimit.LoginGuard
+	auditLog         *audit.Logger
+	outbox           *mailer.Outbox
 }
 
 // NewAuthService creates a new authentication service
 func NewAuthService() *AuthService {
 	return &AuthService{
-		userCollection: database.GetCollection("users"),
-		cfg:            config.Get(),
+		userCollection:   database.GetCollection("users"),
+		apiKeyCollection: database.GetCollection("api_keys"),
+		cfg:              config.Get(),
+		keys:             Keys(),
+		loginGuard:       ratelimit.NewLoginGuard(),
+		auditLog:         audit.NewLogger(),
+		outbox:           mailer.NewOutbox(),
 	}
 }
 
 // Register creates a new user account
 func (s *AuthService) Register(ctx context.Context, req *models.RegisterRequest) (*models.User, error) {
-	// Check if user already exists
-	existingUser, err := s.GetUserByEmail(ctx, req.Email)
-	if err == nil && existingUser != nil {
-		return nil, ErrUserExists
+	// Check if user already exists (or the email is reserved after deletion)
+	if err := s.checkEmailAvailable(ctx, req.Email); err != nil {
+		return nil, err
 	}
 
 	// Hash password
@@ -61,6 +82,7 @@ func (s *AuthService) Register(ctx context.Context, req *models.RegisterRequest)
 		Platform:     req.Platform,
 		SerialNumber: req.SerialNumber,
 		Role:         string(models.RoleUser),
+		Language:     req.Language,
 		IsActive:     true,
 		Owned:        false,
 		IsBanned:     false,
@@ -78,27 +100,46 @@ func (s *AuthService) Register(ctx context.Context, req *models.RegisterRequest)
 	return user, nil
 }
 
-// Login authenticates a user and returns tokens
-func (s *AuthService) Login(ctx context.Context, req *models.LoginRequest) (*models.LoginResponse, error) {
+// Login authenticates a user and returns tokens. Repeated failures for the
+// email or client IP are throttled and return a *ratelimit.LockedError.
+func (s *AuthService) Login(ctx context.Context, req *models.LoginRequest, clientIP string) (*models.LoginResponse, error) {
+	if err := s.loginGuard.Check(ctx, req.Email, clientIP); err != nil {
+		return nil, err
+	}
+
 	// Find user by email
 	user, err := s.GetUserByEmail(ctx, req.Email)
 	if err != nil {
+		s.loginGuard.RecordFailure(ctx, req.Email, clientIP)
 		return nil, ErrInvalidCredentials
 	}
 
 	if !user.IsActive {
-		return nil, errors.New("account is disabled")
+		return nil, ErrAccountDisabled
 	}
 
 	// Verify password
 	if !s.verifyPassword(req.Password, user.Password) {
+		s.loginGuard.RecordFailure(ctx, req.Email, clientIP)
 		return nil, ErrInvalidCredentials
 	}
 
+	// Accounts with two-factor enabled get a short-lived challenge instead of
+	// tokens; failure history is only cleared once the second step succeeds
+	if user.TwoFactorEnabled {
+		return s.issueMFAChallenge(user)
+	}
+
+	s.loginGuard.RecordSuccess(ctx, req.Email, clientIP)
+	return s.completeLogin(ctx, user)
+}
+
+// completeLogin records the login and issues access and refresh tokens
+func (s *AuthService) completeLogin(ctx context.Context, user *models.User) (*models.LoginResponse, error) {
 	// Update last login
 	now := time.Now()
 	user.LastLogin = &now
-	_, err = s.userCollection.UpdateOne(ctx,
+	_, err := s.userCollection.UpdateOne(ctx,
 		bson.M{"_id": user.ID},
 		bson.M{"$set": bson.M{"last_login": now}},
 	)
@@ -107,13 +148,17 @@ func (s *AuthService) Login(ctx context.Context, req *models.LoginRequest) (*mod
 		fmt.Printf("Failed to update last login: %v\n", err)
 	}
 
-	// Generate tokens
-	accessToken, err := s.generateToken(user, "access", s.cfg.JWT.Expiration)
+	return s.issueTokens(user)
+}
+
+// issueTokens generates a new access/refresh token pair for the user
+func (s *AuthService) issueTokens(user *models.User) (*models.LoginResponse, error) {
+	accessToken, err := s.generateToken(user, TokenTypeAccess, s.cfg.JWT.Expiration)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate access token: %w", err)
 	}
 
-	refreshToken, err := s.generateToken(user, "refresh", s.cfg.JWT.RefreshExpiration)
+	refreshToken, err := s.generateToken(user, TokenTypeRefresh, s.cfg.JWT.RefreshExpiration)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
 	}
@@ -128,12 +173,18 @@ func (s *AuthService) Login(ctx context.Context, req *models.LoginRequest) (*mod
 
 // ValidateToken validates a JWT token and returns the user
 func (s *AuthService) ValidateToken(tokenString string) (*models.User, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &jwt.MapClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(s.cfg.JWT.Secret), nil
-	})
+	return s.validateToken(tokenString, TokenTypeAccess, TokenTypeRefresh)
+}
+
+// ValidateRequestToken validates a token presented to AuthMiddleware. Besides
+// session tokens it accepts impersonation tokens, which set user.ImpersonatedBy.
+func (s *AuthService) ValidateRequestToken(tokenString string) (*models.User, error) {
+	return s.validateToken(tokenString, TokenTypeAccess, TokenTypeRefresh, TokenTypeImpersonation)
+}
+
+// validateToken validates a JWT token whose "type" claim is one of allowedTypes
+func (s *AuthService) validateToken(tokenString string, allowedTypes ...string) (*models.User, error) {
+	token, err := s.keys.Parse(tokenString, &jwt.MapClaims{})
 
 	if err != nil {
 		return nil, ErrInvalidToken
@@ -148,6 +199,18 @@ func (s *AuthService) ValidateToken(tokenString string) (*models.User, error) {
 		return nil, ErrInvalidToken
 	}
 
+	tokenType, _ := (*claims)["type"].(string)
+	typeAllowed := false
+	for _, allowed := range allowedTypes {
+		if tokenType == allowed {
+			typeAllowed = true
+			break
+		}
+	}
+	if !typeAllowed {
+		return nil, ErrInvalidToken
+	}
+
 	userID, ok := (*claims)["user_id"].(string)
 	if !ok {
 		return nil, ErrInvalidToken
@@ -165,7 +228,21 @@ func (s *AuthService) ValidateToken(tokenString string) (*models.User, error) {
 	}
 
 	if !user.IsActive {
-		return nil, errors.New("account is disabled")
+		return nil, ErrAccountDisabled
+	}
+
+	if tokenType == TokenTypeImpersonation {
+		impersonator, err := impersonatorFromClaims(*claims)
+		if err != nil {
+			return nil, ErrInvalidToken
+		}
+
+		// The admin must still hold super rights for the token to stay usable
+		admin, err := s.GetUserByID(context.Background(), impersonator.AdminID)
+		if err != nil || !admin.IsActive || admin.Role != string(models.RoleSuper) {
+			return nil, ErrInvalidToken
+		}
+		user.ImpersonatedBy = impersonator
 	}
 
 	return user, nil
@@ -180,22 +257,7 @@ func (s *AuthService) RefreshToken(ctx context.Context, refreshTokenString strin
 	}
 
 	// Generate new tokens
-	accessToken, err := s.generateToken(user, "access", s.cfg.JWT.Expiration)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate access token: %w", err)
-	}
-
-	refreshToken, err := s.generateToken(user, "refresh", s.cfg.JWT.RefreshExpiration)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
-	}
-
-	return &models.LoginResponse{
-		User:         user,
-		Token:        accessToken,
-		RefreshToken: refreshToken,
-		ExpiresAt:    time.Now().Add(s.cfg.JWT.Expiration).Unix(),
-	}, nil
+	return s.issueTokens(user)
 }
 
 // GetUserByEmail retrieves a user by email
@@ -224,6 +286,29 @@ func (s *AuthService) GetUserByID(ctx context.Context, id primitive.ObjectID) (*
 	return &user, nil
 }
 
+// ChangePassword sets a new password after checking the current one. Accounts
+// created through an external provider may set a first password without one.
+func (s *AuthService) ChangePassword(ctx context.Context, user *models.User, currentPassword, newPassword string) error {
+	if user.Password != "" && !s.verifyPassword(currentPassword, user.Password) {
+		return ErrInvalidCredentials
+	}
+
+	hashedPassword, err := s.hashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	_, err = s.userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"password": hashedPassword, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	return nil
+}
+
 // hashPassword hashes a password using bcrypt
 func (s *AuthService) hashPassword(password string) (string, error) {
 	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -250,6 +335,9 @@ func (s *AuthService) generateToken(user *models.User, tokenType string, expirat
 		"iat":     time.Now().Unix(),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(s.cfg.JWT.Secret))
+	if s.cfg.JWT.Issuer != "" {
+		claims["iss"] = s.cfg.JWT.Issuer
+	}
+
+	return s.keys.Sign(claims)
 }
@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"jinzmedia-atmt/models"
+)
+
+var (
+	ErrInvalidMFACode          = errors.New("invalid two-factor code")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorSetupRequired  = errors.New("two-factor setup has not been started")
+	ErrTwoFactorRequired       = errors.New("two-factor authentication is required for this account")
+)
+
+// IsTwoFactorRequired reports whether policy forces two-factor for the user's role
+func (s *AuthService) IsTwoFactorRequired(user *models.User) bool {
+	return s.cfg.MFA.RequireForAdmins && isAdminRole(user.Role)
+}
+
+// SetupTwoFactor generates a pending TOTP secret for the user to enroll
+func (s *AuthService) SetupTwoFactor(ctx context.Context, user *models.User) (*models.TwoFactorSetupResponse, error) {
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+
+	_, err = s.userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"two_factor_pending_secret": secret, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save pending secret: %w", err)
+	}
+
+	return &models.TwoFactorSetupResponse{
+		Secret:          secret,
+		ProvisioningURI: TOTPProvisioningURI(s.mfaIssuer(), user.Email, secret),
+	}, nil
+}
+
+// EnableTwoFactor confirms enrollment with a code from the pending secret and
+// returns the initial recovery codes
+func (s *AuthService) EnableTwoFactor(ctx context.Context, user *models.User, code string) ([]string, error) {
+	if user.TwoFactorEnabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if user.TwoFactorPendingSecret == "" {
+		return nil, ErrTwoFactorSetupRequired
+	}
+
+	step, ok := ValidateTOTP(user.TwoFactorPendingSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes(s.recoveryCodeCount())
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+
+	_, err = s.userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$set": bson.M{
+				"two_factor_enabled":   true,
+				"two_factor_secret":    user.TwoFactorPendingSecret,
+				"two_factor_last_step": step,
+				"recovery_codes":       hashes,
+				"updated_at":           time.Now(),
+			},
+			"$unset": bson.M{"two_factor_pending_secret": ""},
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor: %w", err)
+	}
+
+	return codes, nil
+}
+
+// DisableTwoFactor turns off two-factor after re-checking password and code
+func (s *AuthService) DisableTwoFactor(ctx context.Context, user *models.User, password, code string) error {
+	if !user.TwoFactorEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+	if s.IsTwoFactorRequired(user) {
+		return ErrTwoFactorRequired
+	}
+	if !s.verifyPassword(password, user.Password) {
+		return ErrInvalidCredentials
+	}
+	if err := s.verifySecondFactor(ctx, user, code); err != nil {
+		return err
+	}
+
+	_, err := s.userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$set": bson.M{"two_factor_enabled": false, "updated_at": time.Now()},
+			"$unset": bson.M{
+				"two_factor_secret":         "",
+				"two_factor_pending_secret": "",
+				"two_factor_last_step":      "",
+				"recovery_codes":            "",
+			},
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to disable two-factor: %w", err)
+	}
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after verifying a code
+func (s *AuthService) RegenerateRecoveryCodes(ctx context.Context, user *models.User, code string) ([]string, error) {
+	if !user.TwoFactorEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	if err := s.verifySecondFactor(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes(s.recoveryCodeCount())
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+
+	_, err = s.userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID},
+		bson.M{"$set": bson.M{"recovery_codes": hashes, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save recovery codes: %w", err)
+	}
+
+	return codes, nil
+}
+
+// VerifyMFAChallenge completes a two-step login and issues real tokens. Wrong
+// codes count as failed logins for the account and client IP.
+func (s *AuthService) VerifyMFAChallenge(ctx context.Context, mfaToken, code, clientIP string) (*models.LoginResponse, error) {
+	user, err := s.validateToken(mfaToken, TokenTypeMFA)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.TwoFactorEnabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+
+	if err := s.loginGuard.Check(ctx, user.Email, clientIP); err != nil {
+		return nil, err
+	}
+
+	if err := s.verifySecondFactor(ctx, user, code); err != nil {
+		if err == ErrInvalidMFACode {
+			s.loginGuard.RecordFailure(ctx, user.Email, clientIP)
+		}
+		return nil, err
+	}
+
+	s.loginGuard.RecordSuccess(ctx, user.Email, clientIP)
+	return s.completeLogin(ctx, user)
+}
+
+// issueMFAChallenge returns a login response carrying only a challenge token
+func (s *AuthService) issueMFAChallenge(user *models.User) (*models.LoginResponse, error) {
+	expiration := s.cfg.MFA.ChallengeExpiration
+	if expiration <= 0 {
+		expiration = 5 * time.Minute
+	}
+
+	mfaToken, err := s.generateToken(user, TokenTypeMFA, expiration)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate mfa token: %w", err)
+	}
+
+	return &models.LoginResponse{
+		User:        user,
+		MFARequired: true,
+		MFAToken:    mfaToken,
+		ExpiresAt:   time.Now().Add(expiration).Unix(),
+	}, nil
+}
+
+// verifySecondFactor accepts a TOTP code (each time step only once) or consumes
+// an unused recovery code
+func (s *AuthService) verifySecondFactor(ctx context.Context, user *models.User, code string) error {
+	if step, ok := ValidateTOTP(user.TwoFactorSecret, code, time.Now()); ok {
+		// Only advance if this step is newer than the last accepted one
+		result, err := s.userCollection.UpdateOne(ctx,
+			bson.M{
+				"_id": user.ID,
+				"$or": []bson.M{
+					{"two_factor_last_step": bson.M{"$lt": step}},
+					{"two_factor_last_step": bson.M{"$exists": false}},
+				},
+			},
+			bson.M{"$set": bson.M{"two_factor_last_step": step}},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record two-factor step: %w", err)
+		}
+		if result.MatchedCount == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	hash := hashRecoveryCode(code)
+	result, err := s.userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "recovery_codes": hash},
+		bson.M{"$pull": bson.M{"recovery_codes": hash}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to consume recovery code: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+func (s *AuthService) mfaIssuer() string {
+	if s.cfg.MFA.Issuer != "" {
+		return s.cfg.MFA.Issuer
+	}
+	return s.cfg.App.Name
+}
+
+func (s *AuthService) recoveryCodeCount() int {
+	if s.cfg.MFA.RecoveryCodeCount > 0 {
+		return s.cfg.MFA.RecoveryCodeCount
+	}
+	return 10
+}
+
+// isAdminRole reports whether a role has access to admin features
+func isAdminRole(role string) bool {
+	return role == string(models.RoleAdmin) || role == string(models.RoleSuper)
+}
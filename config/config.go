@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -22,6 +23,20 @@ type Config struct {
 	FileUpload  FileUploadConfig  `yaml:"file_upload"`
 	HealthCheck HealthCheckConfig `yaml:"health_check"`
 	Metrics     MetricsConfig     `yaml:"metrics"`
+	Email       EmailConfig       `yaml:"email"`
+	MFA         MFAConfig         `yaml:"mfa"`
+	LoginGuard  LoginGuardConfig  `yaml:"login_protection"`
+	OIDC        OIDCConfig        `yaml:"oidc"`
+	APIKeys     APIKeysConfig     `yaml:"api_keys"`
+	Impersonate ImpersonateConfig `yaml:"impersonation"`
+	Account     AccountConfig     `yaml:"account"`
+	DataExport  DataExportConfig  `yaml:"data_export"`
+	I18n        I18nConfig        `yaml:"i18n"`
+	License     LicenseConfig     `yaml:"license"`
+	Downloads   DownloadsConfig   `yaml:"downloads"`
+	Updates     UpdatesConfig     `yaml:"updates"`
+	Storage     StorageConfig     `yaml:"storage"`
+	GeoIP       GeoIPConfig       `yaml:"geoip"`
 }
 
 type AppConfig struct {
@@ -38,6 +53,8 @@ type ServerConfig struct {
 	WriteTimeout            time.Duration `yaml:"write_timeout"`
 	IdleTimeout             time.Duration `yaml:"idle_timeout"`
 	GracefulShutdownTimeout time.Duration `yaml:"graceful_shutdown_timeout"`
+	MaxBodySize             int64         `yaml:"max_body_size"`   // Bytes accepted in JSON request bodies
+	TrustedProxies          []string      `yaml:"trusted_proxies"` // Proxy IPs or CIDRs whose X-Forwarded-For / X-Real-IP headers are honoured
 }
 
 type DatabaseConfig struct {
@@ -55,6 +72,7 @@ type DatabaseConfig struct {
 }
 
 type RedisConfig struct {
+	Enabled            bool   `yaml:"enabled"`
 	Host               string `yaml:"host"`
 	Port               int    `yaml:"port"`
 	Password           string `yaml:"password"`
@@ -83,9 +101,21 @@ type CORSConfig struct {
 }
 
 type JWTConfig struct {
-	Secret            string        `yaml:"secret"`
-	Expiration        time.Duration `yaml:"expiration"`
-	RefreshExpiration time.Duration `yaml:"refresh_expiration"`
+	Secret            string         `yaml:"secret"`
+	Expiration        time.Duration  `yaml:"expiration"`
+	RefreshExpiration time.Duration  `yaml:"refresh_expiration"`
+	Algorithm         string         `yaml:"algorithm"`     // HS256, RS256, EdDSA
+	ActiveKeyID       string         `yaml:"active_key_id"` // kid used to sign new tokens (RS256/EdDSA)
+	AcceptHS256       bool           `yaml:"accept_hs256"`  // keep verifying legacy HS256 tokens during migration
+	Issuer            string         `yaml:"issuer"`
+	Keys              []JWTKeyConfig `yaml:"keys"`
+}
+
+type JWTKeyConfig struct {
+	ID             string `yaml:"id"`
+	Algorithm      string `yaml:"algorithm"`
+	PrivateKeyFile string `yaml:"private_key_file"` // omit for verification-only keys
+	PublicKeyFile  string `yaml:"public_key_file"`  // derived from the private key when omitted
 }
 
 type RateLimitConfig struct {
@@ -100,9 +130,11 @@ type ExternalAPIConfig struct {
 }
 
 type FileUploadConfig struct {
-	MaxSize      int64    `yaml:"max_size"`
-	AllowedTypes []string `yaml:"allowed_types"`
-	UploadPath   string   `yaml:"upload_path"`
+	MaxSize          int64         `yaml:"max_size"` // also the largest chunk of a resumable build upload
+	AllowedTypes     []string      `yaml:"allowed_types"`
+	UploadPath       string        `yaml:"upload_path"`
+	BuildMaxSize     int64         `yaml:"build_max_size"`    // bytes per product build, uploaded in one multipart request or in chunks
+	UploadExpiration time.Duration `yaml:"upload_expiration"` // unfinished resumable uploads are discarded after this
 }
 
 type HealthCheckConfig struct {
@@ -116,6 +148,177 @@ type MetricsConfig struct {
 	Namespace string `yaml:"namespace"`
 }
 
+type EmailConfig struct {
+	Driver          string            `yaml:"driver"` // smtp, log
+	FromAddress     string            `yaml:"from_address"`
+	FromName        string            `yaml:"from_name"`
+	DefaultLanguage string            `yaml:"default_language"` // vi, en
+	LogDir          string            `yaml:"log_dir"`
+	SMTP            SMTPConfig        `yaml:"smtp"`
+	Outbox          EmailOutboxConfig `yaml:"outbox"`
+}
+
+type SMTPConfig struct {
+	Host     string        `yaml:"host"`
+	Port     int           `yaml:"port"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	TLSMode  string        `yaml:"tls_mode"` // none, starttls, tls
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+type EmailOutboxConfig struct {
+	PollInterval   time.Duration `yaml:"poll_interval"`
+	BatchSize      int           `yaml:"batch_size"`
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	LockTimeout    time.Duration `yaml:"lock_timeout"`
+}
+
+type MFAConfig struct {
+	Issuer              string        `yaml:"issuer"`
+	ChallengeExpiration time.Duration `yaml:"challenge_expiration"`
+	RequireForAdmins    bool          `yaml:"require_for_admins"`
+	RecoveryCodeCount   int           `yaml:"recovery_code_count"`
+}
+
+type LoginGuardConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	Window           time.Duration `yaml:"window"`
+	FreeAttempts     int           `yaml:"free_attempts"`
+	BaseDelay        time.Duration `yaml:"base_delay"`
+	MaxDelay         time.Duration `yaml:"max_delay"`
+	EmailMaxAttempts int           `yaml:"email_max_attempts"`
+	IPMaxAttempts    int           `yaml:"ip_max_attempts"`
+	LockoutDuration  time.Duration `yaml:"lockout_duration"`
+	SprayThreshold   int           `yaml:"spray_threshold"`
+	AlertEmail       string        `yaml:"alert_email"`
+}
+
+type OIDCConfig struct {
+	StateExpiration time.Duration                 `yaml:"state_expiration"` // how long a login/link attempt may take at the provider
+	Providers       map[string]OIDCProviderConfig `yaml:"providers"`
+}
+
+type OIDCProviderConfig struct {
+	Enabled               bool     `yaml:"enabled"`
+	ClientID              string   `yaml:"client_id"`
+	ClientSecret          string   `yaml:"client_secret"`
+	Issuer                string   `yaml:"issuer"`
+	AuthorizationEndpoint string   `yaml:"authorization_endpoint"` // endpoints are discovered from the issuer when empty
+	TokenEndpoint         string   `yaml:"token_endpoint"`
+	JWKSURI               string   `yaml:"jwks_uri"`
+	RedirectURL           string   `yaml:"redirect_url"`
+	Scopes                []string `yaml:"scopes"`
+}
+
+type APIKeysConfig struct {
+	MaxPerUser      int           `yaml:"max_per_user"`
+	DefaultLifetime time.Duration `yaml:"default_lifetime"` // used when the request gives no expiry
+	MaxLifetime     time.Duration `yaml:"max_lifetime"`     // 0 allows keys that never expire
+}
+
+type ImpersonateConfig struct {
+	TokenExpiration time.Duration `yaml:"token_expiration"`
+}
+
+type AccountConfig struct {
+	EmailVerificationURL        string        `yaml:"email_verification_url"` // {token} is replaced with the verification token
+	EmailVerificationExpiration time.Duration `yaml:"email_verification_expiration"`
+	DeletedEmailReuseAfter      time.Duration `yaml:"deleted_email_reuse_after"` // how long a deleted account's email stays reserved
+}
+
+type DataExportConfig struct {
+	Dir            string        `yaml:"dir"`      // where generated ZIP files are kept until they expire
+	BaseURL        string        `yaml:"base_url"` // public API URL used in the emailed download link
+	LinkExpiration time.Duration `yaml:"link_expiration"`
+	MinInterval    time.Duration `yaml:"min_interval"` // minimum time between two exports of the same user
+	PollInterval   time.Duration `yaml:"poll_interval"`
+	MaxAttempts    int           `yaml:"max_attempts"`
+}
+
+type I18nConfig struct {
+	DefaultLanguage string `yaml:"default_language"` // vi, en; used when neither the user nor Accept-Language selects one
+}
+
+type LicenseConfig struct {
+	MaxDevices        int                `yaml:"max_devices"`   // devices that can be activated at the same time per license
+	MaxTransfers      int                `yaml:"max_transfers"` // deactivations and transfers allowed per transfer_window
+	TransferWindow    time.Duration      `yaml:"transfer_window"`
+	HeartbeatInterval time.Duration      `yaml:"heartbeat_interval"` // how often apps are told to report in
+	HeartbeatLimit    int                `yaml:"heartbeat_limit"`    // heartbeats per hour per client IP and per activation; -1 for no limit
+	AnomalyIPLimit    int                `yaml:"anomaly_ip_limit"`   // distinct IPs per activation within anomaly_window before it is flagged
+	AnomalyWindow     time.Duration      `yaml:"anomaly_window"`
+	FileValidity      time.Duration      `yaml:"file_validity"`      // how long a signed license file works offline
+	RevocationRefresh time.Duration      `yaml:"revocation_refresh"` // how often the signed revocation list is rebuilt
+	ActiveKeyID       string             `yaml:"active_key_id"`      // id of the key in signing_keys that signs new files
+	SigningKeys       []LicenseKeyConfig `yaml:"signing_keys"`
+}
+
+type LicenseKeyConfig struct {
+	ID             string `yaml:"id"`
+	PrivateKeyFile string `yaml:"private_key_file"` // Ed25519 PKCS #8 PEM; omit for verification-only keys
+	PublicKeyFile  string `yaml:"public_key_file"`  // derived from the private key when omitted
+}
+
+type DownloadsConfig struct {
+	LinkSecret     string          `yaml:"link_secret"` // HMAC key for signed download links; links are disabled when empty
+	LinkExpiration time.Duration   `yaml:"link_expiration"`
+	BaseURL        string          `yaml:"base_url"`        // public URL used in signed links, e.g. a CDN in front of the API
+	CountWindow    time.Duration   `yaml:"count_window"`    // repeated downloads of the same file by a user from one IP within this window count once
+	DailyLimit     int             `yaml:"daily_limit"`     // downloads per product per user within 24 hours; -1 for no limit
+	WeeklyIPLimit  int             `yaml:"weekly_ip_limit"` // distinct IP addresses per user within 7 days; -1 for no limit
+	CountryHeader  string          `yaml:"country_header"`  // request header with the client's country code set by a CDN, e.g. CF-IPCountry
+	Abuse          AbuseConfig     `yaml:"abuse"`
+	Watermark      WatermarkConfig `yaml:"watermark"`
+}
+
+// AbuseConfig sets when the admin abuse report lists an account
+type AbuseConfig struct {
+	IPThreshold        int      `yaml:"ip_threshold"`         // distinct IP addresses within the report period
+	CountryThreshold   int      `yaml:"country_threshold"`    // distinct countries within the report period
+	DownloadThreshold  int      `yaml:"download_threshold"`   // downloads within the report period
+	ScriptedUserAgents []string `yaml:"scripted_user_agents"` // case-insensitive substrings of user agents used by scripts
+}
+
+// WatermarkConfig enables per-user marks in product builds
+type WatermarkConfig struct {
+	Products []string `yaml:"products"` // products whose builds are watermarked for each user
+	Secret   string   `yaml:"secret"`   // key that seals the marks; required when products are set
+}
+
+type UpdatesConfig struct {
+	AppcastItems     int    `yaml:"appcast_items"`      // releases listed in Sparkle appcasts
+	AppcastNewBuilds int    `yaml:"appcast_new_builds"` // releases per appcast request whose watermarked copy or signature may be made
+	SparkleKeyFile   string `yaml:"sparkle_key_file"`   // Ed25519 PKCS #8 PEM for sparkle:edSignature; appcasts are unsigned when empty
+	SparkleMaxSize   int64  `yaml:"sparkle_max_size"`   // largest macOS build accepted while Sparkle signing is on; signing holds the file in memory
+}
+
+// GeoIPConfig names local MaxMind DB files used to locate download clients
+type GeoIPConfig struct {
+	CountryDatabase string `yaml:"country_database"` // e.g. GeoLite2-Country.mmdb or GeoLite2-City.mmdb; countries are not recorded when empty
+	ASNDatabase     string `yaml:"asn_database"`     // e.g. GeoLite2-ASN.mmdb; autonomous systems are not recorded when empty
+}
+
+type StorageConfig struct {
+	Driver       string          `yaml:"driver"`         // local, s3
+	LocalPath    string          `yaml:"local_path"`     // root of product files for the local driver
+	StatCacheTTL time.Duration   `yaml:"stat_cache_ttl"` // how long file sizes and existence are cached
+	S3           S3StorageConfig `yaml:"s3"`
+}
+
+type S3StorageConfig struct {
+	Endpoint         string `yaml:"endpoint"` // e.g. https://s3.ap-southeast-1.amazonaws.com or http://localhost:9000 for MinIO
+	Region           string `yaml:"region"`
+	Bucket           string `yaml:"bucket"`
+	Prefix           string `yaml:"prefix"` // prepended to every key
+	AccessKeyID      string `yaml:"access_key_id"`
+	SecretAccessKey  string `yaml:"secret_access_key"`
+	UsePathStyle     bool   `yaml:"use_path_style"`    // endpoint/bucket/key instead of bucket.endpoint/key; required by MinIO
+	PresignDownloads bool   `yaml:"presign_downloads"` // redirect downloads to presigned URLs instead of proxying them
+}
+
 // Global config instance
 var cfg *Config
 
@@ -153,6 +356,9 @@ func overrideWithEnv() {
 	if env := os.Getenv("SERVER_PORT"); env != "" {
 		fmt.Sscanf(env, "%d", &cfg.Server.Port)
 	}
+	if env := os.Getenv("TRUSTED_PROXIES"); env != "" {
+		cfg.Server.TrustedProxies = strings.Split(env, ",")
+	}
 	if env := os.Getenv("DB_HOST"); env != "" {
 		cfg.Database.Host = env
 	}
@@ -168,6 +374,9 @@ func overrideWithEnv() {
 	if env := os.Getenv("DB_PASSWORD"); env != "" {
 		cfg.Database.Password = env
 	}
+	if env := os.Getenv("REDIS_ENABLED"); env != "" {
+		cfg.Redis.Enabled = env == "true" || env == "1"
+	}
 	if env := os.Getenv("REDIS_HOST"); env != "" {
 		cfg.Redis.Host = env
 	}
@@ -180,6 +389,60 @@ func overrideWithEnv() {
 	if env := os.Getenv("JWT_SECRET"); env != "" {
 		cfg.JWT.Secret = env
 	}
+	if env := os.Getenv("JWT_ALGORITHM"); env != "" {
+		cfg.JWT.Algorithm = env
+	}
+	if env := os.Getenv("JWT_ACTIVE_KEY_ID"); env != "" {
+		cfg.JWT.ActiveKeyID = env
+	}
+	if env := os.Getenv("DOWNLOAD_LINK_SECRET"); env != "" {
+		cfg.Downloads.LinkSecret = env
+	}
+	if env := os.Getenv("WATERMARK_SECRET"); env != "" {
+		cfg.Downloads.Watermark.Secret = env
+	}
+	if env := os.Getenv("GEOIP_COUNTRY_DATABASE"); env != "" {
+		cfg.GeoIP.CountryDatabase = env
+	}
+	if env := os.Getenv("GEOIP_ASN_DATABASE"); env != "" {
+		cfg.GeoIP.ASNDatabase = env
+	}
+	if env := os.Getenv("STORAGE_DRIVER"); env != "" {
+		cfg.Storage.Driver = env
+	}
+	if env := os.Getenv("S3_ACCESS_KEY_ID"); env != "" {
+		cfg.Storage.S3.AccessKeyID = env
+	}
+	if env := os.Getenv("S3_SECRET_ACCESS_KEY"); env != "" {
+		cfg.Storage.S3.SecretAccessKey = env
+	}
+	if env := os.Getenv("EMAIL_DRIVER"); env != "" {
+		cfg.Email.Driver = env
+	}
+	if env := os.Getenv("SMTP_HOST"); env != "" {
+		cfg.Email.SMTP.Host = env
+	}
+	if env := os.Getenv("SMTP_PORT"); env != "" {
+		fmt.Sscanf(env, "%d", &cfg.Email.SMTP.Port)
+	}
+	if env := os.Getenv("SMTP_USERNAME"); env != "" {
+		cfg.Email.SMTP.Username = env
+	}
+	if env := os.Getenv("SMTP_PASSWORD"); env != "" {
+		cfg.Email.SMTP.Password = env
+	}
+	if env := os.Getenv("SMTP_TLS_MODE"); env != "" {
+		cfg.Email.SMTP.TLSMode = env
+	}
+	if google, ok := cfg.OIDC.Providers["google"]; ok {
+		if env := os.Getenv("GOOGLE_CLIENT_ID"); env != "" {
+			google.ClientID = env
+		}
+		if env := os.Getenv("GOOGLE_CLIENT_SECRET"); env != "" {
+			google.ClientSecret = env
+		}
+		cfg.OIDC.Providers["google"] = google
+	}
 }
 
 // GetDatabaseDSN returns the database connection string
@@ -217,6 +480,11 @@ func (c *Config) GetRedisAddress() string {
 	return fmt.Sprintf("%s:%d", c.Redis.Host, c.Redis.Port)
 }
 
+// GetSMTPAddress returns the SMTP server address
+func (c *Config) GetSMTPAddress() string {
+	return fmt.Sprintf("%s:%d", c.Email.SMTP.Host, c.Email.SMTP.Port)
+}
+
 // IsProduction returns true if the environment is production
 func (c *Config) IsProduction() bool {
 	return c.App.Environment == "production"
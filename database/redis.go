@@ -0,0 +1,61 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"jinzmedia-atmt/config"
+)
+
+var redisClient *redis.Client
+
+// ConnectRedis establishes a connection to Redis when it is enabled in config
+func ConnectRedis() error {
+	cfg := config.Get()
+	if !cfg.Redis.Enabled {
+		return nil
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:         cfg.GetRedisAddress(),
+		Password:     cfg.Redis.Password,
+		DB:           cfg.Redis.Database,
+		PoolSize:     cfg.Redis.PoolSize,
+		MinIdleConns: cfg.Redis.MinIdleConnections,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return fmt.Errorf("failed to ping Redis: %w", err)
+	}
+
+	redisClient = client
+	log.Printf("Connected to Redis: %s", cfg.GetRedisAddress())
+	return nil
+}
+
+// GetRedis returns the Redis client, or nil when Redis is not configured
+func GetRedis() *redis.Client {
+	return redisClient
+}
+
+// DisconnectRedis closes the Redis connection
+func DisconnectRedis() error {
+	if redisClient == nil {
+		return nil
+	}
+
+	if err := redisClient.Close(); err != nil {
+		return fmt.Errorf("failed to disconnect from Redis: %w", err)
+	}
+
+	log.Println("Disconnected from Redis")
+	return nil
+}
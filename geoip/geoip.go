@@ -0,0 +1,80 @@
+// Package geoip resolves client IP addresses to a country and autonomous
+// system using local MaxMind DB files, such as GeoLite2 Country (or City)
+// and GeoLite2 ASN. No network requests are made.
+package geoip
+
+import (
+	"log"
+	"net"
+	"strings"
+
+	"jinzmedia-atmt/config"
+)
+
+// Location describes where an address is registered. Fields are empty when
+// the databases are not configured or have no record.
+type Location struct {
+	Country        string // ISO 3166-1 alpha-2 code, e.g. VN
+	ASN            uint32
+	ASOrganization string
+}
+
+var (
+	countryDB *Reader
+	asnDB     *Reader
+)
+
+// Init opens the databases named in geoip.* of the config. GeoIP is
+// optional: a database that is not configured or cannot be opened is
+// skipped with a warning, and lookups return empty fields for it.
+func Init() {
+	cfg := config.Get().GeoIP
+
+	if cfg.CountryDatabase != "" {
+		if r, err := Open(cfg.CountryDatabase); err != nil {
+			log.Printf("GEOIP ERROR: Failed to open country database %s, country lookups disabled: %v", cfg.CountryDatabase, err)
+		} else {
+			countryDB = r
+			log.Printf("GeoIP country database loaded: %s", r.DatabaseType)
+		}
+	}
+	if cfg.ASNDatabase != "" {
+		if r, err := Open(cfg.ASNDatabase); err != nil {
+			log.Printf("GEOIP ERROR: Failed to open ASN database %s, ASN lookups disabled: %v", cfg.ASNDatabase, err)
+		} else {
+			asnDB = r
+			log.Printf("GeoIP ASN database loaded: %s", r.DatabaseType)
+		}
+	}
+}
+
+// Lookup returns the location of an IP address
+func Lookup(address string) Location {
+	var location Location
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return location
+	}
+
+	if countryDB != nil {
+		if record, err := countryDB.Lookup(ip); err == nil && record != nil {
+			location.Country = isoCode(record, "country")
+			if location.Country == "" {
+				location.Country = isoCode(record, "registered_country")
+			}
+		}
+	}
+	if asnDB != nil {
+		if record, err := asnDB.Lookup(ip); err == nil && record != nil {
+			location.ASN = uint32(toUint(record["autonomous_system_number"]))
+			location.ASOrganization, _ = record["autonomous_system_organization"].(string)
+		}
+	}
+	return location
+}
+
+func isoCode(record map[string]interface{}, field string) string {
+	country, _ := record[field].(map[string]interface{})
+	code, _ := country["iso_code"].(string)
+	return strings.ToUpper(code)
+}
@@ -0,0 +1,311 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// metadataMarker precedes the metadata map at the end of a MaxMind DB file
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// Data section field types
+const (
+	typeExtended  = 0
+	typePointer   = 1
+	typeString    = 2
+	typeDouble    = 3
+	typeBytes     = 4
+	typeUint16    = 5
+	typeUint32    = 6
+	typeMap       = 7
+	typeInt32     = 8
+	typeUint64    = 9
+	typeUint128   = 10
+	typeArray     = 11
+	typeContainer = 12
+	typeEnd       = 13
+	typeBool      = 14
+	typeFloat     = 15
+)
+
+// maxDepth bounds nested maps and arrays in a corrupt file
+const maxDepth = 32
+
+var ErrInvalidDatabase = errors.New("geoip: invalid MaxMind DB file")
+
+// Reader looks up records in a MaxMind DB (.mmdb) file, such as the
+// GeoLite2 Country, City and ASN databases. The file is read into memory.
+type Reader struct {
+	buf          []byte
+	dataStart    int
+	nodeCount    uint32
+	recordSize   int
+	ipVersion    int
+	ipv4Start    uint32
+	DatabaseType string
+	BuildEpoch   uint64
+}
+
+// Open reads a MaxMind DB file
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: failed to read database: %w", err)
+	}
+	return FromBytes(buf)
+}
+
+// FromBytes parses a MaxMind DB held in memory
+func FromBytes(buf []byte) (*Reader, error) {
+	i := bytes.LastIndex(buf, metadataMarker)
+	if i < 0 {
+		return nil, ErrInvalidDatabase
+	}
+	metaStart := i + len(metadataMarker)
+	meta := decoder{buf: buf[metaStart:]}
+	value, _, err := meta.decode(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	metadata, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, ErrInvalidDatabase
+	}
+
+	r := &Reader{buf: buf}
+	r.nodeCount = uint32(toUint(metadata["node_count"]))
+	r.recordSize = int(toUint(metadata["record_size"]))
+	r.ipVersion = int(toUint(metadata["ip_version"]))
+	r.DatabaseType, _ = metadata["database_type"].(string)
+	r.BuildEpoch = toUint(metadata["build_epoch"])
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("%w: unsupported record size %d", ErrInvalidDatabase, r.recordSize)
+	}
+
+	treeSize := int(r.nodeCount) * r.recordSize / 4
+	r.dataStart = treeSize + 16
+	if r.dataStart > i {
+		return nil, ErrInvalidDatabase
+	}
+
+	// IPv4 addresses live below ::/96 in IPv6 databases
+	if r.ipVersion == 6 {
+		for bit := 0; bit < 96 && r.ipv4Start < r.nodeCount; bit++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// Lookup returns the record of the network containing ip, or nil when the
+// database has none
+func (r *Reader) Lookup(ip net.IP) (map[string]interface{}, error) {
+	node := uint32(0)
+	bits := 128
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits, node = v4, 32, r.ipv4Start
+	} else if ip = ip.To16(); ip == nil || r.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < bits && node < r.nodeCount; i++ {
+		bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+		node = r.record(node, int(bit))
+	}
+	if node <= r.nodeCount {
+		// Equal to the node count means no data for the network
+		return nil, nil
+	}
+
+	offset := int(node-r.nodeCount) - 16
+	data := decoder{buf: r.buf[r.dataStart:]}
+	value, _, err := data.decode(offset, 0)
+	if err != nil {
+		return nil, err
+	}
+	record, _ := value.(map[string]interface{})
+	return record, nil
+}
+
+// record returns the left (0) or right (1) record of a search tree node
+func (r *Reader) record(node uint32, side int) uint32 {
+	switch r.recordSize {
+	case 24:
+		b := r.buf[int(node)*6+side*3:]
+		return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	case 28:
+		b := r.buf[int(node)*7:]
+		if side == 0 {
+			return uint32(b[3]&0xf0)<<20 | uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		}
+		return uint32(b[3]&0x0f)<<24 | uint32(b[4])<<16 | uint32(b[5])<<8 | uint32(b[6])
+	default:
+		return binary.BigEndian.Uint32(r.buf[int(node)*8+side*4:])
+	}
+}
+
+// decoder reads values of a data section; pointers are relative to buf
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) decode(offset, depth int) (interface{}, int, error) {
+	if depth > maxDepth {
+		return nil, 0, ErrInvalidDatabase
+	}
+	if offset < 0 || offset >= len(d.buf) {
+		return nil, 0, ErrInvalidDatabase
+	}
+
+	ctrl := d.buf[offset]
+	offset++
+	typ := int(ctrl >> 5)
+
+	if typ == typePointer {
+		pointer, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer, depth+1)
+		return value, next, err
+	}
+
+	if typ == typeExtended {
+		if offset >= len(d.buf) {
+			return nil, 0, ErrInvalidDatabase
+		}
+		typ = 7 + int(d.buf[offset])
+		offset++
+	}
+
+	size := int(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > len(d.buf) {
+			return nil, 0, ErrInvalidDatabase
+		}
+		extra := 0
+		for _, b := range d.buf[offset : offset+n] {
+			extra = extra<<8 | int(b)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			key, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, ErrInvalidDatabase
+			}
+			value, next, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[name] = value
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			value, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > len(d.buf) {
+		return nil, 0, ErrInvalidDatabase
+	}
+	data := d.buf[offset : offset+size]
+	offset += size
+
+	switch typ {
+	case typeString:
+		return string(data), offset, nil
+	case typeBytes, typeUint128:
+		return append([]byte(nil), data...), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(data)), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		var n uint64
+		for _, b := range data {
+			n = n<<8 | uint64(b)
+		}
+		return n, offset, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, ErrInvalidDatabase
+		}
+		var n uint32
+		for _, b := range data {
+			n = n<<8 | uint32(b)
+		}
+		if size == 4 {
+			return int64(int32(n)), offset, nil
+		}
+		return int64(n), offset, nil
+	}
+	return nil, 0, fmt.Errorf("%w: unexpected type %d", ErrInvalidDatabase, typ)
+}
+
+// pointer returns the offset a pointer refers to and the offset after it
+func (d *decoder) pointer(ctrl byte, offset int) (int, int, error) {
+	n := int((ctrl>>3)&3) + 1
+	if offset+n > len(d.buf) {
+		return 0, 0, ErrInvalidDatabase
+	}
+	b := d.buf[offset : offset+n]
+	v := int(ctrl & 7)
+	switch n {
+	case 1:
+		return v<<8 | int(b[0]), offset + n, nil
+	case 2:
+		return (v<<16 | int(b[0])<<8 | int(b[1])) + 2048, offset + n, nil
+	case 3:
+		return (v<<24 | int(b[0])<<16 | int(b[1])<<8 | int(b[2])) + 526336, offset + n, nil
+	default:
+		return int(binary.BigEndian.Uint32(b)), offset + n, nil
+	}
+}
+
+func toUint(value interface{}) uint64 {
+	n, _ := value.(uint64)
+	return n
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"jinzmedia-atmt/auth"
+	"jinzmedia-atmt/models"
+)
+
+// UpdateProfile updates the editable fields of the current user's profile
+func (h *AuthHandlers) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req models.UpdateProfileRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	updated, err := h.authService.UpdateProfile(r.Context(), user, &req)
+	if err != nil {
+		if !errors.Is(err, auth.ErrInvalidProfile) {
+			log.Printf("ACCOUNT ERROR: Failed to update profile for %s: %v", user.Email, err)
+		}
+		writeError(w, err)
+		return
+	}
+
+	writeSuccessResponse(w, http.StatusOK, "Profile updated", updated)
+}
+
+// RequestEmailChange sends a verification link to the new email address
+func (h *AuthHandlers) RequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req models.ChangeEmailRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.authService.RequestEmailChange(r.Context(), user, req.NewEmail, req.Password); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidProfile):
+			writeErrorMessage(w, err, "Invalid email address")
+		case err == auth.ErrInvalidCredentials:
+			writeErrorMessage(w, err, "Invalid password")
+		case err == auth.ErrUserExists:
+			writeErrorMessage(w, err, "Email address is not available")
+		default:
+			log.Printf("ACCOUNT ERROR: Failed to start email change for %s: %v", user.Email, err)
+			writeError(w, err)
+		}
+		return
+	}
+
+	writeSuccessResponse(w, http.StatusAccepted, "Verification email sent. Your email changes once the new address is confirmed.", nil)
+}
+
+// VerifyEmailChange confirms a pending email change from the emailed link
+func (h *AuthHandlers) VerifyEmailChange(w http.ResponseWriter, r *http.Request) {
+	var req models.VerifyEmailRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	user, err := h.authService.VerifyEmailChange(r.Context(), req.Token)
+	if err != nil {
+		switch err {
+		case auth.ErrEmailVerificationInvalid:
+			writeError(w, err)
+		case auth.ErrUserExists, auth.ErrEmailReserved:
+			writeErrorMessage(w, err, "Email address is no longer available")
+		default:
+			log.Printf("ACCOUNT ERROR: Failed to verify email change: %v", err)
+			writeError(w, err)
+		}
+		return
+	}
+
+	writeSuccessResponse(w, http.StatusOK, "Email address changed", user)
+}
+
+// DeleteAccount anonymizes and disables the current user's account
+func (h *AuthHandlers) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req models.DeleteAccountRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.authService.DeleteAccount(r.Context(), user, &req); err != nil {
+		switch err {
+		case auth.ErrInvalidCredentials:
+			writeErrorMessage(w, err, "Invalid password")
+		case auth.ErrDeleteConfirmation, auth.ErrInvalidMFACode:
+			writeError(w, err)
+		default:
+			log.Printf("ACCOUNT ERROR: Failed to delete account %s: %v", user.Email, err)
+			writeError(w, err)
+		}
+		return
+	}
+
+	writeSuccessResponse(w, http.StatusOK, "Account deleted", nil)
+}
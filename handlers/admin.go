@@ -1,51 +1,58 @@
 package handlers
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"jinzmedia-atmt/auth"
 	"jinzmedia-atmt/models"
+	"jinzmedia-atmt/ratelimit"
 	"jinzmedia-atmt/services"
 )
 
 type AdminHandlers struct {
-	adminService *services.AdminService
+	adminService    *services.AdminService
+	licenseService  *services.LicenseService
+	downloadService *services.DownloadService
+	loginGuard      *ratelimit.LoginGuard
 }
 
-func NewAdminHandlers() *AdminHandlers {
+func NewAdminHandlers(licenseService *services.LicenseService, downloadService *services.DownloadService) *AdminHandlers {
 	return &AdminHandlers{
-		adminService: services.NewAdminService(),
+		adminService:    services.NewAdminService(),
+		licenseService:  licenseService,
+		downloadService: downloadService,
+		loginGuard:      ratelimit.NewLoginGuard(),
 	}
 }
 
 // Login handles admin authentication
 func (h *AdminHandlers) Login(w http.ResponseWriter, r *http.Request) {
 	var req models.LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeErrorResponse(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	// Basic validation
-	if req.Email == "" || req.Password == "" {
-		writeErrorResponse(w, http.StatusBadRequest, "Email and password are required")
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
 	authService := auth.NewAuthService()
-	response, err := authService.Login(r.Context(), &req)
+	response, err := authService.Login(r.Context(), &req, clientIP(r))
 	if err != nil {
+		if writeLockedResponse(w, err) {
+			return
+		}
 		if err == auth.ErrInvalidCredentials {
-			writeErrorResponse(w, http.StatusUnauthorized, "Invalid email or password")
+			writeCodedError(w, http.StatusUnauthorized, models.ErrCodeInvalidCredentials, "Invalid email or password")
 			return
 		}
-		writeErrorResponse(w, http.StatusInternalServerError, "Login failed")
+		writeError(w, err)
 		return
 	}
 
@@ -56,6 +63,26 @@ func (h *AdminHandlers) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Admin accounts must enroll in two-factor when the policy requires it
+	if authService.IsTwoFactorRequired(user) && !user.TwoFactorEnabled {
+		log.Printf("ADMIN LOGIN: User %s rejected - two-factor not enabled", user.Email)
+		writeErrorResponse(w, http.StatusForbidden, "Two-factor authentication must be enabled for admin accounts. Enable it via /api/v1/auth/2fa/setup")
+		return
+	}
+
+	if response.MFARequired {
+		log.Printf("ADMIN LOGIN: User %s passed password step, awaiting two-factor code", user.Email)
+		writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"data": map[string]interface{}{
+				"mfa_required": true,
+				"mfa_token":    response.MFAToken,
+				"expires_at":   response.ExpiresAt,
+			},
+		})
+		return
+	}
+
 	log.Printf("ADMIN LOGIN: User %s logged in successfully", user.Email)
 	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
 		"success": true,
@@ -65,6 +92,39 @@ func (h *AdminHandlers) Login(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// VerifyLogin completes admin login with a two-factor code
+func (h *AdminHandlers) VerifyLogin(w http.ResponseWriter, r *http.Request) {
+	var req models.MFAVerifyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	authService := auth.NewAuthService()
+	response, err := authService.VerifyMFAChallenge(r.Context(), req.MFAToken, req.Code, clientIP(r))
+	if err != nil {
+		if writeLockedResponse(w, err) {
+			return
+		}
+		log.Printf("ADMIN LOGIN: Two-factor verification failed: %v", err)
+		writeErrorResponse(w, http.StatusUnauthorized, "Invalid two-factor code or expired challenge")
+		return
+	}
+
+	user := response.User
+	if user.Role != "admin" && user.Role != "super" {
+		writeErrorResponse(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	log.Printf("ADMIN LOGIN: User %s logged in successfully with two-factor", user.Email)
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"token": response.Token,
+		},
+	})
+}
+
 // GetDashboardStats returns aggregated dashboard statistics
 func (h *AdminHandlers) GetDashboardStats(w http.ResponseWriter, r *http.Request) {
 	log.Printf("ADMIN DASHBOARD: Requesting dashboard stats")
@@ -154,6 +214,62 @@ func (h *AdminHandlers) GetCostStats(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// GetDownloadStats returns download analytics
+func (h *AdminHandlers) GetDownloadStats(w http.ResponseWriter, r *http.Request) {
+	params := extractAnalyticsParams(r)
+	log.Printf("ADMIN ANALYTICS: Requesting download stats with params %+v", params)
+
+	stats, err := h.downloadService.GetDownloadStats(r.Context(), params)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidDateRange) {
+			writeError(w, err)
+			return
+		}
+		log.Printf("ADMIN ERROR: Failed to get download stats: %v", err)
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to get download stats")
+		return
+	}
+
+	// Check if CSV export is requested
+	if r.URL.Query().Get("export") == "csv" {
+		h.exportDownloadStatsCSV(w, stats)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    stats,
+	})
+}
+
+// GetConversionStats returns the registration to payment to download funnel
+func (h *AdminHandlers) GetConversionStats(w http.ResponseWriter, r *http.Request) {
+	params := extractAnalyticsParams(r)
+	log.Printf("ADMIN ANALYTICS: Requesting conversion stats with params %+v", params)
+
+	stats, err := h.downloadService.GetConversionStats(r.Context(), params)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidDateRange) {
+			writeError(w, err)
+			return
+		}
+		log.Printf("ADMIN ERROR: Failed to get conversion stats: %v", err)
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to get conversion stats")
+		return
+	}
+
+	// Check if CSV export is requested
+	if r.URL.Query().Get("export") == "csv" {
+		h.exportConversionStatsCSV(w, stats)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    stats,
+	})
+}
+
 // GetJobs returns paginated jobs list
 func (h *AdminHandlers) GetJobs(w http.ResponseWriter, r *http.Request) {
 	params := extractJobsParams(r)
@@ -191,8 +307,8 @@ func (h *AdminHandlers) GetJob(w http.ResponseWriter, r *http.Request) {
 	job, err := h.adminService.GetJobByID(jobID)
 	if err != nil {
 		log.Printf("ADMIN ERROR: Failed to get job %s: %v", jobID, err)
-		if err.Error() == "job not found" {
-			writeErrorResponse(w, http.StatusNotFound, "Job not found")
+		if err == services.ErrJobNotFound {
+			writeError(w, err)
 			return
 		}
 		writeErrorResponse(w, http.StatusInternalServerError, "Failed to get job")
@@ -225,8 +341,7 @@ func (h *AdminHandlers) GetWorkflows(w http.ResponseWriter, r *http.Request) {
 // CreateWorkflow creates a new workflow
 func (h *AdminHandlers) CreateWorkflow(w http.ResponseWriter, r *http.Request) {
 	var req models.CreateWorkflowRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
@@ -254,8 +369,7 @@ func (h *AdminHandlers) UpdateWorkflow(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req models.UpdateWorkflowRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
@@ -264,8 +378,8 @@ func (h *AdminHandlers) UpdateWorkflow(w http.ResponseWriter, r *http.Request) {
 	workflow, err := h.adminService.UpdateWorkflow(workflowID, &req)
 	if err != nil {
 		log.Printf("ADMIN ERROR: Failed to update workflow %s: %v", workflowID, err)
-		if err.Error() == "workflow not found" {
-			writeErrorResponse(w, http.StatusNotFound, "Workflow not found")
+		if err == services.ErrWorkflowNotFound {
+			writeError(w, err)
 			return
 		}
 		writeErrorResponse(w, http.StatusInternalServerError, "Failed to update workflow")
@@ -278,6 +392,269 @@ func (h *AdminHandlers) UpdateWorkflow(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// UnlockUser clears the login lockout and failed-attempt history for a user
+func (h *AdminHandlers) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	authService := auth.NewAuthService()
+	user, err := authService.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if err == auth.ErrUserNotFound {
+			writeCodedError(w, http.StatusNotFound, models.ErrCodeUserNotFound, "User not found")
+			return
+		}
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+
+	if err := h.loginGuard.UnlockEmail(r.Context(), user.Email); err != nil {
+		log.Printf("ADMIN ERROR: Failed to unlock user %s: %v", user.Email, err)
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to unlock user")
+		return
+	}
+
+	admin := auth.GetUserFromContext(r.Context())
+	log.Printf("ADMIN SECURITY: %s unlocked login for user %s", admin.Email, user.Email)
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"email":    user.Email,
+			"unlocked": true,
+		},
+	})
+}
+
+// UnlockIP clears the login lockout and failed-attempt history for a client IP
+func (h *AdminHandlers) UnlockIP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IP string `json:"ip"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if net.ParseIP(req.IP) == nil {
+		writeErrorResponse(w, http.StatusBadRequest, "A valid IP address is required")
+		return
+	}
+
+	if err := h.loginGuard.UnlockIP(r.Context(), req.IP); err != nil {
+		log.Printf("ADMIN ERROR: Failed to unlock IP %s: %v", req.IP, err)
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to unlock IP")
+		return
+	}
+
+	admin := auth.GetUserFromContext(r.Context())
+	log.Printf("ADMIN SECURITY: %s unlocked login for IP %s", admin.Email, req.IP)
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"ip":       req.IP,
+			"unlocked": true,
+		},
+	})
+}
+
+// ImpersonateUser issues a short-lived token to act as a user (super admin only)
+func (h *AdminHandlers) ImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req models.ImpersonateRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	admin := auth.GetUserFromContext(r.Context())
+	authService := auth.NewAuthService()
+	response, err := authService.ImpersonateUser(r.Context(), admin, userID, req.Reason, clientIP(r), middleware.GetReqID(r.Context()))
+	if err != nil {
+		switch err {
+		case auth.ErrImpersonationReason:
+			writeCodedError(w, http.StatusBadRequest, models.ErrCodeValidationFailed, "A reason is required")
+		case auth.ErrUserNotFound:
+			writeCodedError(w, http.StatusNotFound, models.ErrCodeUserNotFound, "User not found")
+		case auth.ErrCannotImpersonateAdmin:
+			writeCodedError(w, http.StatusForbidden, models.ErrCodeCannotImpersonateAdmin, "Admin accounts cannot be impersonated")
+		default:
+			log.Printf("ADMIN ERROR: %s failed to impersonate user %s: %v", admin.Email, userID.Hex(), err)
+			writeErrorResponse(w, http.StatusInternalServerError, "Failed to start impersonation")
+		}
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    response,
+	})
+}
+
+// ListUserAPIKeys lists a user's API keys, including revoked and expired ones
+func (h *AdminHandlers) ListUserAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	authService := auth.NewAuthService()
+	keys, err := authService.ListAPIKeys(r.Context(), userID)
+	if err != nil {
+		log.Printf("ADMIN ERROR: Failed to list API keys for user %s: %v", userID.Hex(), err)
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to list API keys")
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    keys,
+	})
+}
+
+// RevokeUserAPIKey revokes one of a user's API keys
+func (h *AdminHandlers) RevokeUserAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	keyID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "keyId"))
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	authService := auth.NewAuthService()
+	if err := authService.RevokeAPIKey(r.Context(), userID, keyID); err != nil {
+		if err == auth.ErrAPIKeyNotFound {
+			writeCodedError(w, http.StatusNotFound, models.ErrCodeAPIKeyNotFound, "API key not found")
+			return
+		}
+		log.Printf("ADMIN ERROR: Failed to revoke API key %s: %v", keyID.Hex(), err)
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+
+	admin := auth.GetUserFromContext(r.Context())
+	log.Printf("ADMIN SECURITY: %s revoked API key %s of user %s", admin.Email, keyID.Hex(), userID.Hex())
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"id":      keyID.Hex(),
+			"revoked": true,
+		},
+	})
+}
+
+// ListUserDevices lists the devices a user's license is or was activated on
+func (h *AdminHandlers) ListUserDevices(w http.ResponseWriter, r *http.Request) {
+	userID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	devices, err := h.licenseService.ListDevices(r.Context(), userID)
+	if err != nil {
+		log.Printf("ADMIN ERROR: Failed to list devices for user %s: %v", userID.Hex(), err)
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to list devices")
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    devices,
+	})
+}
+
+// RevokeUserDevice deactivates one of a user's devices and revokes its license files
+func (h *AdminHandlers) RevokeUserDevice(w http.ResponseWriter, r *http.Request) {
+	userID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	deviceID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "deviceId"))
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid activation ID")
+		return
+	}
+
+	if err := h.licenseService.RevokeDevice(r.Context(), userID, deviceID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	admin := auth.GetUserFromContext(r.Context())
+	log.Printf("ADMIN SECURITY: %s revoked device %s of user %s", admin.Email, deviceID.Hex(), userID.Hex())
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"id":      deviceID.Hex(),
+			"revoked": true,
+		},
+	})
+}
+
+// ListFlaggedDevices lists active devices with recent anomalies such as
+// heartbeats from many IP addresses
+func (h *AdminHandlers) ListFlaggedDevices(w http.ResponseWriter, r *http.Request) {
+	days := 7
+	if d, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && d > 0 && d <= 90 {
+		days = d
+	}
+	limit := int64(100)
+	if l, err := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+
+	devices, err := h.licenseService.ListFlaggedDevices(r.Context(), time.Now().AddDate(0, 0, -days), limit)
+	if err != nil {
+		log.Printf("ADMIN ERROR: Failed to list flagged devices: %v", err)
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to list devices")
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    devices,
+	})
+}
+
+// DownloadAbuseReport lists accounts with suspicious download activity
+// over the last days (default 7)
+func (h *AdminHandlers) DownloadAbuseReport(w http.ResponseWriter, r *http.Request) {
+	days := 7
+	if d, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && d > 0 && d <= 90 {
+		days = d
+	}
+	limit := int64(100)
+	if l, err := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64); err == nil && l > 0 && l <= 500 {
+		limit = l
+	}
+
+	entries, err := h.downloadService.DownloadAbuseReport(r.Context(), time.Now().AddDate(0, 0, -days), limit)
+	if err != nil {
+		log.Printf("ADMIN ERROR: Failed to build download abuse report: %v", err)
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to build abuse report")
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    entries,
+	})
+}
+
 // Helper functions for parameter extraction
 func extractAnalyticsParams(r *http.Request) *models.AnalyticsParams {
 	query := r.URL.Query()
@@ -361,6 +738,28 @@ func (h *AdminHandlers) exportCostStatsCSV(w http.ResponseWriter, stats *models.
 	w.Write([]byte(csvData))
 }
 
+func (h *AdminHandlers) exportDownloadStatsCSV(w http.ResponseWriter, stats *models.DownloadAnalytics) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"download_stats.csv\"")
+
+	csvData := "date,downloads,unique_downloaders\n"
+	for _, daily := range stats.DailyDownloads {
+		csvData += fmt.Sprintf("%s,%d,%d\n", daily.ID, daily.Count, daily.Unique)
+	}
+	w.Write([]byte(csvData))
+}
+
+func (h *AdminHandlers) exportConversionStatsCSV(w http.ResponseWriter, stats *models.ConversionAnalytics) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"conversion_stats.csv\"")
+
+	csvData := "date,registered,paid,downloaded\n"
+	for _, daily := range stats.DailyConversion {
+		csvData += fmt.Sprintf("%s,%d,%d,%d\n", daily.ID, daily.Registered, daily.Paid, daily.Downloaded)
+	}
+	w.Write([]byte(csvData))
+}
+
 func (h *AdminHandlers) exportJobsCSV(w http.ResponseWriter, jobs []models.Job) {
 	w.Header().Set("Content-Type", "text/csv")
 	w.Header().Set("Content-Disposition", "attachment; filename=\"jobs.csv\"")
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"jinzmedia-atmt/auth"
+	"jinzmedia-atmt/models"
+)
+
+// CreateAPIKey creates a personal API key for the current user
+func (h *AuthHandlers) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req models.CreateAPIKeyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	response, err := h.authService.CreateAPIKey(r.Context(), user, &req)
+	if err != nil {
+		if !errors.Is(err, auth.ErrInvalidAPIKeyData) && !errors.Is(err, auth.ErrAPIKeyLimit) {
+			log.Printf("API KEY ERROR: Failed to create key for %s: %v", user.Email, err)
+		}
+		writeError(w, err)
+		return
+	}
+
+	log.Printf("API KEY: User %s created key %s (%s)", user.Email, response.APIKey.Prefix, response.APIKey.Name)
+	writeSuccessResponse(w, http.StatusCreated, "API key created. Copy it now; it will not be shown again.", response)
+}
+
+// ListAPIKeys lists the current user's API keys
+func (h *AuthHandlers) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	keys, err := h.authService.ListAPIKeys(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("API KEY ERROR: Failed to list keys for %s: %v", user.Email, err)
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to list API keys")
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, keys)
+}
+
+// RevokeAPIKey revokes one of the current user's API keys
+func (h *AuthHandlers) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	keyID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	if err := h.authService.RevokeAPIKey(r.Context(), user.ID, keyID); err != nil {
+		if err == auth.ErrAPIKeyNotFound {
+			writeCodedError(w, http.StatusNotFound, models.ErrCodeAPIKeyNotFound, "API key not found")
+			return
+		}
+		log.Printf("API KEY ERROR: Failed to revoke key %s for %s: %v", keyID.Hex(), user.Email, err)
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+
+	log.Printf("API KEY: User %s revoked key %s", user.Email, keyID.Hex())
+	writeSuccessResponse(w, http.StatusOK, "API key revoked", nil)
+}
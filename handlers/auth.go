@@ -2,10 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
 
 	"jinzmedia-atmt/auth"
+	"jinzmedia-atmt/i18n"
 	"jinzmedia-atmt/models"
+	"jinzmedia-atmt/ratelimit"
 )
 
 type AuthHandlers struct {
@@ -22,26 +28,19 @@ func NewAuthHandlers() *AuthHandlers {
 // Register handles user registration
 func (h *AuthHandlers) Register(w http.ResponseWriter, r *http.Request) {
 	var req models.RegisterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
-	// Basic validation
-	if req.Email == "" || req.Password == "" || req.FullName == "" || req.SerialNumber == "" {
-		writeErrorResponse(w, http.StatusBadRequest, "All fields are required")
-		return
-	}
-
-	if len(req.Password) < 6 {
-		writeErrorResponse(w, http.StatusBadRequest, "Password must be at least 6 characters")
-		return
+	// Without an explicit choice, keep the language the client signed up in
+	if req.Language == "" {
+		req.Language = i18n.FromHeader(w.Header())
 	}
 
 	user, err := h.authService.Register(r.Context(), &req)
 	if err != nil {
 		if err == auth.ErrUserExists {
-			writeErrorResponse(w, http.StatusConflict, "User already exists")
+			writeCodedError(w, http.StatusConflict, models.ErrCodeUserExists, "User already exists")
 			return
 		}
 		writeErrorResponse(w, http.StatusInternalServerError, "Failed to create user")
@@ -54,24 +53,30 @@ func (h *AuthHandlers) Register(w http.ResponseWriter, r *http.Request) {
 // Login handles user authentication
 func (h *AuthHandlers) Login(w http.ResponseWriter, r *http.Request) {
 	var req models.LoginRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeErrorResponse(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	// Basic validation
-	if req.Email == "" || req.Password == "" {
-		writeErrorResponse(w, http.StatusBadRequest, "Email and password are required")
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
-	response, err := h.authService.Login(r.Context(), &req)
+	response, err := h.authService.Login(r.Context(), &req, clientIP(r))
 	if err != nil {
+		if writeLockedResponse(w, err) {
+			return
+		}
 		if err == auth.ErrInvalidCredentials {
-			writeErrorResponse(w, http.StatusUnauthorized, "Invalid email or password")
+			writeCodedError(w, http.StatusUnauthorized, models.ErrCodeInvalidCredentials, "Invalid email or password")
 			return
 		}
-		writeErrorResponse(w, http.StatusInternalServerError, "Login failed")
+		writeError(w, err)
+		return
+	}
+
+	// Second factor required: return only the challenge token
+	if response.MFARequired {
+		writeJSONResponse(w, http.StatusOK, models.LoginResponse{
+			MFARequired: true,
+			MFAToken:    response.MFAToken,
+			ExpiresAt:   response.ExpiresAt,
+		})
 		return
 	}
 
@@ -81,16 +86,10 @@ func (h *AuthHandlers) Login(w http.ResponseWriter, r *http.Request) {
 // RefreshToken handles token refresh
 func (h *AuthHandlers) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		RefreshToken string `json:"refresh_token"`
+		RefreshToken string `json:"refresh_token" validate:"required"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeErrorResponse(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if req.RefreshToken == "" {
-		writeErrorResponse(w, http.StatusBadRequest, "Refresh token is required")
+	if !decodeJSON(w, r, &req) {
 		return
 	}
 
@@ -114,11 +113,82 @@ func (h *AuthHandlers) GetProfile(w http.ResponseWriter, r *http.Request) {
 	writeJSONResponse(w, http.StatusOK, user)
 }
 
+// ChangePassword changes the current user's password
+func (h *AuthHandlers) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req models.ChangePasswordRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.authService.ChangePassword(r.Context(), user, req.CurrentPassword, req.NewPassword); err != nil {
+		if err == auth.ErrInvalidCredentials {
+			writeCodedError(w, http.StatusUnauthorized, models.ErrCodeInvalidCredentials, "Current password is incorrect")
+			return
+		}
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+
+	writeSuccessResponse(w, http.StatusOK, "Password changed successfully", nil)
+}
+
+// JWKS publishes the public keys used to verify access tokens
+func (h *AuthHandlers) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSONResponse(w, http.StatusOK, auth.Keys().JWKS())
+}
+
 // Logout handles user logout (client-side token removal)
 func (h *AuthHandlers) Logout(w http.ResponseWriter, r *http.Request) {
 	writeSuccessResponse(w, http.StatusOK, "Logged out successfully", nil)
 }
 
+// clientIP returns the client address without port. The RealIP middleware
+// only applies X-Forwarded-For / X-Real-IP to RemoteAddr for requests from
+// server.trusted_proxies, so login lockouts cannot be dodged by sending
+// a different header on each attempt.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// writeLockedResponse writes a 429 with Retry-After if err is a login lockout
+func writeLockedResponse(w http.ResponseWriter, err error) bool {
+	var locked *ratelimit.LockedError
+	if !errors.As(err, &locked) {
+		return false
+	}
+
+	seconds := int(math.Ceil(locked.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", seconds))
+	writeCodedError(w, http.StatusTooManyRequests, models.ErrCodeLoginLocked,
+		localize(w, "Too many failed login attempts. Try again in %d seconds.", seconds))
+	return true
+}
+
+// writeLimitedResponse writes a 429 with Retry-After if err is a rate limit
+func writeLimitedResponse(w http.ResponseWriter, err error) bool {
+	var limited *ratelimit.LimitedError
+	if !errors.As(err, &limited) {
+		return false
+	}
+
+	seconds := int(math.Ceil(limited.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", seconds))
+	writeCodedError(w, http.StatusTooManyRequests, models.ErrCodeRateLimited,
+		localize(w, "Too many requests. Try again in %d seconds.", seconds))
+	return true
+}
+
 // writeJSONResponse writes a JSON response
 func writeJSONResponse(w http.ResponseWriter, statusCode int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -126,19 +196,16 @@ func writeJSONResponse(w http.ResponseWriter, statusCode int, data interface{})
 	json.NewEncoder(w).Encode(data)
 }
 
-// writeErrorResponse writes an error response
+// writeErrorResponse writes an error response with the generic code for the status
 func writeErrorResponse(w http.ResponseWriter, statusCode int, message string) {
-	response := models.ErrorResponse{
-		Error: message,
-		Code:  statusCode,
-	}
-	writeJSONResponse(w, statusCode, response)
+	writeCodedError(w, statusCode, models.ErrorCodeForStatus(statusCode), message)
 }
 
-// writeSuccessResponse writes a success response
+// writeSuccessResponse writes a success response, translating the message
+// into the response language
 func writeSuccessResponse(w http.ResponseWriter, statusCode int, message string, data interface{}) {
 	response := models.SuccessResponse{
-		Message: message,
+		Message: i18n.T(i18n.FromHeader(w.Header()), message),
 		Data:    data,
 	}
 	writeJSONResponse(w, statusCode, response)
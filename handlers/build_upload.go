@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"jinzmedia-atmt/auth"
+	"jinzmedia-atmt/models"
+	"jinzmedia-atmt/validation"
+)
+
+// UploadOffsetHeader carries the byte offset of a resumable upload chunk
+const UploadOffsetHeader = "Upload-Offset"
+
+// maxFormFieldSize bounds the text fields of a multipart build upload
+const maxFormFieldSize = 64 << 10
+
+// AdminUploadBuild receives a build as multipart/form-data and registers it
+// as a draft release. The metadata fields must precede the "file" part so
+// they can be checked before the build is stored.
+func (h *ReleaseHandlers) AdminUploadBuild(w http.ResponseWriter, r *http.Request) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "Request must be multipart/form-data")
+		return
+	}
+
+	var meta models.BuildMetadata
+	fields := map[string]*string{
+		"product_name":   &meta.ProductName,
+		"platform":       &meta.Platform,
+		"arch":           (*string)(&meta.Arch),
+		"version":        &meta.Version,
+		"channel":        (*string)(&meta.Channel),
+		"filename":       &meta.Filename,
+		"sha256":         &meta.ExpectedSHA256,
+		"release_notes":  &meta.ReleaseNotes,
+		"min_os_version": &meta.MinOSVersion,
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			writeValidationErrors(w, validation.Errors{{Field: "file", Rule: "required", Message: "is required"}})
+			return
+		}
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if part.FormName() == "file" {
+			if meta.Filename == "" {
+				meta.Filename = part.FileName()
+			}
+			if errs := validation.Struct(&meta); errs != nil {
+				writeValidationErrors(w, errs)
+				return
+			}
+			h.finishBuildUpload(w, r, &meta, part)
+			return
+		}
+
+		target, ok := fields[part.FormName()]
+		if !ok {
+			writeValidationErrors(w, validation.Errors{{Field: part.FormName(), Rule: "unknown", Message: "is not a recognized field"}})
+			return
+		}
+		value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize))
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		*target = string(value)
+	}
+}
+
+func (h *ReleaseHandlers) finishBuildUpload(w http.ResponseWriter, r *http.Request, meta *models.BuildMetadata, file io.Reader) {
+	release, err := h.uploadService.UploadBuild(r.Context(), meta, file)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	admin := auth.GetUserFromContext(r.Context())
+	log.Printf("ADMIN RELEASE: %s uploaded %s %s/%s (%d bytes, sha256 %s)", admin.Email, release.Version, release.ProductName, release.Platform, release.Size, release.SHA256)
+	writeJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"data":    release,
+	})
+}
+
+// AdminStartUpload begins a resumable build upload
+func (h *ReleaseHandlers) AdminStartUpload(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateBuildUploadRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	admin := auth.GetUserFromContext(r.Context())
+	upload, err := h.uploadService.StartUpload(r.Context(), admin.ID, &req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set(UploadOffsetHeader, "0")
+	writeJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"data":    upload,
+	})
+}
+
+// AdminGetUpload returns the progress of an upload, so an interrupted upload
+// can resume at its offset
+func (h *ReleaseHandlers) AdminGetUpload(w http.ResponseWriter, r *http.Request) {
+	uploadID, ok := parseUploadID(w, r)
+	if !ok {
+		return
+	}
+
+	upload, err := h.uploadService.GetUpload(r.Context(), uploadID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set(UploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    upload,
+	})
+}
+
+// AdminUploadChunk appends the request body to an upload at the offset given
+// in the Upload-Offset header
+func (h *ReleaseHandlers) AdminUploadChunk(w http.ResponseWriter, r *http.Request) {
+	uploadID, ok := parseUploadID(w, r)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get(UploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		writeErrorResponse(w, http.StatusBadRequest, "Upload-Offset header is required")
+		return
+	}
+
+	upload, err := h.uploadService.AppendChunk(r.Context(), uploadID, offset, r.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set(UploadOffsetHeader, strconv.FormatInt(upload.Offset, 10))
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    upload,
+	})
+}
+
+// AdminCompleteUpload verifies a fully received upload and registers it as
+// a draft release
+func (h *ReleaseHandlers) AdminCompleteUpload(w http.ResponseWriter, r *http.Request) {
+	uploadID, ok := parseUploadID(w, r)
+	if !ok {
+		return
+	}
+
+	release, err := h.uploadService.CompleteUpload(r.Context(), uploadID)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	admin := auth.GetUserFromContext(r.Context())
+	log.Printf("ADMIN RELEASE: %s uploaded %s %s/%s (%d bytes, sha256 %s)", admin.Email, release.Version, release.ProductName, release.Platform, release.Size, release.SHA256)
+	writeJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"data":    release,
+	})
+}
+
+// AdminCancelUpload discards an unfinished upload
+func (h *ReleaseHandlers) AdminCancelUpload(w http.ResponseWriter, r *http.Request) {
+	uploadID, ok := parseUploadID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.uploadService.CancelUpload(r.Context(), uploadID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data": map[string]interface{}{
+			"id":        uploadID.Hex(),
+			"cancelled": true,
+		},
+	})
+}
+
+func parseUploadID(w http.ResponseWriter, r *http.Request) (primitive.ObjectID, bool) {
+	uploadID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid upload ID")
+		return primitive.NilObjectID, false
+	}
+	return uploadID, true
+}
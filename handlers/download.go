@@ -1,11 +1,12 @@
 package handlers
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
 
 	"github.com/go-chi/chi/v5"
 
@@ -18,9 +19,9 @@ type DownloadHandlers struct {
 	downloadService *services.DownloadService
 }
 
-func NewDownloadHandlers() *DownloadHandlers {
+func NewDownloadHandlers(downloadService *services.DownloadService) *DownloadHandlers {
 	return &DownloadHandlers{
-		downloadService: services.NewDownloadService(),
+		downloadService: downloadService,
 	}
 }
 
@@ -40,10 +41,17 @@ func (dh *DownloadHandlers) ListProducts(w http.ResponseWriter, r *http.Request)
 	response, err := dh.downloadService.GetProductsAndUserInfo(user.ID)
 	if err != nil {
 		log.Printf("DOWNLOAD ERROR: Failed to get products for user %s: %v", user.Email, err)
-		writeErrorResponse(w, http.StatusInternalServerError, "Failed to get products: "+err.Error())
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to get products")
 		return
 	}
 
+	// Suggest the client's platform; browsers send the architecture hints
+	// on later requests once asked with Accept-CH
+	if platform, arch := services.DetectPlatform(r); platform != "" {
+		response.Detected = &models.DetectedPlatform{Platform: platform, Arch: arch}
+	}
+	w.Header().Set("Accept-CH", services.ClientHintsHeader)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
@@ -62,6 +70,7 @@ func (dh *DownloadHandlers) DownloadProduct(w http.ResponseWriter, r *http.Reque
 	productName := chi.URLParam(r, "product_name")
 	platform := chi.URLParam(r, "platform")
 	serial := r.URL.Query().Get("serial")
+	version := r.URL.Query().Get("version")
 
 	log.Printf("DOWNLOAD DEBUG: User %s requesting %s/%s with serial %s", user.Email, productName, platform, serial)
 
@@ -82,16 +91,15 @@ func (dh *DownloadHandlers) DownloadProduct(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	// Validate product name
-	if !models.IsValidProduct(productName) {
-		writeErrorResponse(w, http.StatusBadRequest, "Invalid product name")
+	// Validate product name and platform, detecting "auto"
+	platform, arch, ok := resolveDownloadTarget(w, r, productName, platform)
+	if !ok {
+		log.Printf("DOWNLOAD ERROR: Invalid target %s/%s for user %s", productName, chi.URLParam(r, "platform"), user.Email)
 		return
 	}
 
-	// Validate platform
-	if platform != "windows" && platform != "macos" {
-		log.Printf("DOWNLOAD ERROR: Invalid platform %s for user %s", platform, user.Email)
-		writeErrorResponse(w, http.StatusBadRequest, "Invalid platform. Must be 'windows' or 'macos'")
+	channel, ok := parseReleaseChannel(w, r.URL.Query().Get("channel"))
+	if !ok {
 		return
 	}
 
@@ -99,35 +107,56 @@ func (dh *DownloadHandlers) DownloadProduct(w http.ResponseWriter, r *http.Reque
 		user.Email, user.ID.Hex(), user.Owned, user.SerialNumber)
 
 	// Process download request
-	downloadInfo, err := dh.downloadService.ProcessDownloadRequest(user.ID, productName, platform, serial, r)
+	downloadInfo, err := dh.downloadService.ProcessDownloadRequest(user.ID, productName, platform, arch, serial, version, channel, r)
 	if err != nil {
 		log.Printf("DOWNLOAD ERROR: Failed to process download for user %s: %v", user.Email, err)
-		// Check specific error types for appropriate HTTP status codes
-		switch err.Error() {
-		case "user not found":
-			writeErrorResponse(w, http.StatusNotFound, "User not found")
-		case "user is banned":
-			writeErrorResponse(w, http.StatusForbidden, "User account is banned")
-		case "you do not own this product":
-			writeErrorResponse(w, http.StatusForbidden, "You do not own this product. Please purchase it first.")
-		case "serial number does not match":
-			writeErrorResponse(w, http.StatusForbidden, "Serial number does not match your account")
-		case "file not found":
-			writeErrorResponse(w, http.StatusNotFound, "Product file not found")
-		default:
-			writeErrorResponse(w, http.StatusInternalServerError, "Failed to process download: "+err.Error())
-		}
+		writeError(w, err)
 		return
 	}
 
 	log.Printf("DOWNLOAD SUCCESS: Serving file %s to user %s", downloadInfo.Filename, user.Email)
 
 	// Serve the file
+	dh.serveFile(w, r, downloadInfo)
+}
+
+// serveFile sends a product file from storage, or redirects to a presigned
+// storage URL when downloads are offloaded to the bucket. Range and If-Range
+// requests resume interrupted downloads; the checksum headers let clients
+// verify the assembled file.
+func (dh *DownloadHandlers) serveFile(w http.ResponseWriter, r *http.Request, downloadInfo *models.DownloadInfo) {
+	if downloadInfo.SHA256 != "" {
+		w.Header().Set("X-Checksum-SHA256", downloadInfo.SHA256)
+		if digest, err := hex.DecodeString(downloadInfo.SHA256); err == nil {
+			w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(digest)+":")
+		}
+	}
+
+	url, err := dh.downloadService.PresignFile(r.Context(), downloadInfo)
+	if err != nil {
+		log.Printf("DOWNLOAD ERROR: Failed to presign %s: %v", downloadInfo.Key, err)
+		writeError(w, err)
+		return
+	}
+	if url != "" {
+		// The presigned URL expires soon; caches must not keep the redirect
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", downloadInfo.Filename))
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Length", strconv.FormatInt(downloadInfo.Size, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if etag := downloadInfo.ETag(); etag != "" {
+		// http.ServeContent evaluates If-Range, If-Match and If-None-Match
+		// against it
+		w.Header().Set("ETag", etag)
+	}
 
-	http.ServeFile(w, r, downloadInfo.FilePath)
+	file := dh.downloadService.OpenFile(r.Context(), downloadInfo)
+	defer file.Close()
+	http.ServeContent(w, r, downloadInfo.Filename, downloadInfo.ModTime, file)
 }
 
 // GetDownloadHistory returns user's download history
@@ -146,7 +175,7 @@ func (dh *DownloadHandlers) GetDownloadHistory(w http.ResponseWriter, r *http.Re
 	downloads, err := dh.downloadService.GetUserDownloadHistory(user.ID)
 	if err != nil {
 		log.Printf("DOWNLOAD ERROR: Failed to get download history for user %s: %v", user.Email, err)
-		writeErrorResponse(w, http.StatusInternalServerError, "Failed to get download history: "+err.Error())
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to get download history")
 		return
 	}
 
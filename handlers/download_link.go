@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"jinzmedia-atmt/auth"
+	"jinzmedia-atmt/models"
+	"jinzmedia-atmt/services"
+)
+
+// CreateDownloadLink returns a short-lived signed URL for a product file, for
+// browsers and download managers that cannot send an Authorization header
+func (dh *DownloadHandlers) CreateDownloadLink(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	productName := chi.URLParam(r, "product_name")
+	platform, arch, ok := resolveDownloadTarget(w, r, productName, chi.URLParam(r, "platform"))
+	if !ok {
+		return
+	}
+
+	var req models.DownloadLinkRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	link, err := dh.downloadService.CreateDownloadLink(r.Context(), user.ID, productName, platform, arch, &req)
+	if err != nil {
+		log.Printf("DOWNLOAD ERROR: Failed to create link to %s/%s for user %s: %v", productName, platform, user.Email, err)
+		writeError(w, err)
+		return
+	}
+
+	writeSuccessResponse(w, http.StatusOK, "Download link created", link)
+}
+
+// DownloadSignedFile serves a product file to the holder of a signed link
+func (dh *DownloadHandlers) DownloadSignedFile(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	userID, err := primitive.ObjectIDFromHex(query.Get("uid"))
+	if err != nil {
+		writeError(w, services.ErrDownloadLinkInvalid)
+		return
+	}
+	expires, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil {
+		writeError(w, services.ErrDownloadLinkInvalid)
+		return
+	}
+
+	link := &services.DownloadLink{
+		UserID:    userID,
+		Product:   chi.URLParam(r, "product_name"),
+		Platform:  chi.URLParam(r, "platform"),
+		Release:   query.Get("release"),
+		Arch:      models.Arch(query.Get("arch")),
+		Version:   query.Get("v"),
+		ExpiresAt: time.Unix(expires, 0),
+		Signature: query.Get("sig"),
+	}
+
+	downloadInfo, err := dh.downloadService.OpenDownloadLink(r.Context(), link, r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	log.Printf("DOWNLOAD SUCCESS: Serving file %s to user %s via signed link", downloadInfo.Filename, userID.Hex())
+
+	// Each download must reach the API, which re-checks the account and
+	// release and records it; a shared cache would replay the file to anyone
+	// holding the URL
+	w.Header().Set("Cache-Control", "private, no-store")
+	dh.serveFile(w, r, downloadInfo)
+}
+
+// resolveDownloadTarget checks the product and platform of a download route
+// and returns the platform and client architecture (?arch=) to serve. The
+// platform "auto" is detected from the request, and so is the architecture
+// when it is "auto" or omitted with a detected platform.
+func resolveDownloadTarget(w http.ResponseWriter, r *http.Request, productName, platform string) (string, models.Arch, bool) {
+	if !models.IsValidProduct(productName) {
+		writeCodedError(w, http.StatusBadRequest, models.ErrCodeInvalidProduct, "Invalid product name")
+		return "", "", false
+	}
+
+	arch := r.URL.Query().Get("arch")
+	if platform == models.PlatformAuto || arch == models.ArchAuto {
+		detectedPlatform, detectedArch := services.DetectPlatform(r)
+		if platform == models.PlatformAuto {
+			if detectedPlatform == "" {
+				writeCodedError(w, http.StatusBadRequest, models.ErrCodeInvalidPlatform, "Could not detect the platform. Choose 'windows', 'macos' or 'linux'")
+				return "", "", false
+			}
+			platform = string(detectedPlatform)
+			if arch == "" {
+				arch = models.ArchAuto
+			}
+		}
+		if arch == models.ArchAuto {
+			arch = ""
+			if string(detectedPlatform) == platform {
+				arch = string(detectedArch)
+			}
+		}
+	}
+
+	info, ok := models.GetPlatform(platform)
+	if !ok || !models.IsValidPlatform(productName, platform) {
+		writeCodedError(w, http.StatusBadRequest, models.ErrCodeInvalidPlatform, "Invalid platform. Must be 'windows', 'macos', 'linux' or 'auto'")
+		return "", "", false
+	}
+	if arch != "" && !info.HasArch(models.Arch(arch)) {
+		writeCodedError(w, http.StatusBadRequest, models.ErrCodeInvalidArch, "Invalid architecture for the platform")
+		return "", "", false
+	}
+	return platform, models.Arch(arch), true
+}
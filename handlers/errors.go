@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"jinzmedia-atmt/auth"
+	"jinzmedia-atmt/i18n"
+	"jinzmedia-atmt/models"
+	"jinzmedia-atmt/services"
+)
+
+// apiError is how a service error is presented to clients
+type apiError struct {
+	status  int
+	code    models.ErrorCode
+	message string
+	detail  bool // Use err.Error() as the message; only for errors that carry user-facing detail
+}
+
+// errorTable maps service and auth sentinel errors to HTTP responses. Errors
+// are matched with errors.Is, so wrapped errors resolve to their sentinel.
+var errorTable = []struct {
+	err error
+	apiError
+}{
+	// Authentication and account
+	{auth.ErrInvalidCredentials, apiError{http.StatusUnauthorized, models.ErrCodeInvalidCredentials, "Invalid email or password", false}},
+	{auth.ErrAccountDisabled, apiError{http.StatusForbidden, models.ErrCodeAccountDisabled, "Account is disabled", false}},
+	{auth.ErrInvalidToken, apiError{http.StatusUnauthorized, models.ErrCodeTokenInvalid, "Invalid or expired token", false}},
+	{auth.ErrTokenExpired, apiError{http.StatusUnauthorized, models.ErrCodeTokenInvalid, "Invalid or expired token", false}},
+	{auth.ErrUserNotFound, apiError{http.StatusNotFound, models.ErrCodeUserNotFound, "User not found", false}},
+	{auth.ErrUserExists, apiError{http.StatusConflict, models.ErrCodeUserExists, "User already exists", false}},
+	{auth.ErrEmailReserved, apiError{http.StatusConflict, models.ErrCodeEmailReserved, "Email address is not available", false}},
+	{auth.ErrEmailUnchanged, apiError{http.StatusBadRequest, models.ErrCodeEmailUnchanged, "New email is the same as the current one", false}},
+	{auth.ErrEmailVerificationInvalid, apiError{http.StatusBadRequest, models.ErrCodeEmailVerificationInvalid, "Verification link is invalid or has expired", false}},
+	{auth.ErrInvalidProfile, apiError{http.StatusBadRequest, models.ErrCodeValidationFailed, "", true}},
+	{auth.ErrDeleteConfirmation, apiError{http.StatusBadRequest, models.ErrCodeDeleteNotConfirmed, "Confirm deletion by entering your email address", false}},
+
+	// Two-factor authentication
+	{auth.ErrInvalidMFACode, apiError{http.StatusBadRequest, models.ErrCodeMFACodeInvalid, "Invalid two-factor code", false}},
+	{auth.ErrTwoFactorNotEnabled, apiError{http.StatusBadRequest, models.ErrCodeMFANotEnabled, "Two-factor authentication is not enabled", false}},
+	{auth.ErrTwoFactorAlreadyEnabled, apiError{http.StatusConflict, models.ErrCodeMFAAlreadyEnabled, "Two-factor authentication is already enabled", false}},
+	{auth.ErrTwoFactorSetupRequired, apiError{http.StatusBadRequest, models.ErrCodeMFASetupRequired, "Start two-factor setup first", false}},
+	{auth.ErrTwoFactorRequired, apiError{http.StatusForbidden, models.ErrCodeMFARequired, "Two-factor authentication is required for this account", false}},
+
+	// API keys
+	{auth.ErrInvalidAPIKey, apiError{http.StatusUnauthorized, models.ErrCodeAPIKeyInvalid, "Invalid, expired or revoked API key", false}},
+	{auth.ErrAPIKeyNotFound, apiError{http.StatusNotFound, models.ErrCodeAPIKeyNotFound, "API key not found", false}},
+	{auth.ErrAPIKeyLimit, apiError{http.StatusConflict, models.ErrCodeAPIKeyLimit, "API key limit reached; revoke an unused key first", false}},
+	{auth.ErrInvalidAPIKeyData, apiError{http.StatusBadRequest, models.ErrCodeValidationFailed, "", true}},
+
+	// Impersonation
+	{auth.ErrImpersonationReason, apiError{http.StatusBadRequest, models.ErrCodeValidationFailed, "A reason is required", false}},
+	{auth.ErrCannotImpersonateAdmin, apiError{http.StatusForbidden, models.ErrCodeCannotImpersonateAdmin, "Admin accounts cannot be impersonated", false}},
+
+	// External sign-in providers
+	{auth.ErrOIDCProviderNotFound, apiError{http.StatusNotFound, models.ErrCodeOIDCProviderNotFound, "Sign-in provider is not available", false}},
+	{auth.ErrOIDCStateInvalid, apiError{http.StatusBadRequest, models.ErrCodeOIDCStateInvalid, "Sign-in request is invalid or has expired. Please try again.", false}},
+	{auth.ErrOIDCEmailNotVerified, apiError{http.StatusForbidden, models.ErrCodeOIDCEmailNotVerified, "The provider account has no verified email address", false}},
+	{auth.ErrIdentityLinked, apiError{http.StatusConflict, models.ErrCodeIdentityLinked, "This provider account is already linked to another user", false}},
+	{auth.ErrIdentityNotLinked, apiError{http.StatusNotFound, models.ErrCodeIdentityNotLinked, "Provider is not linked to this account", false}},
+	{auth.ErrLastSignInMethod, apiError{http.StatusConflict, models.ErrCodeLastSignInMethod, "Set a password before removing your only sign-in method", false}},
+
+	// Payments
+	{services.ErrUserNotFound, apiError{http.StatusNotFound, models.ErrCodeUserNotFound, "User not found", false}},
+	{services.ErrUserBanned, apiError{http.StatusForbidden, models.ErrCodeUserBanned, "User account is banned", false}},
+	{services.ErrAlreadyOwned, apiError{http.StatusConflict, models.ErrCodeAlreadyOwned, "User already owns the product", false}},
+	{services.ErrPaymentSessionNotFound, apiError{http.StatusNotFound, models.ErrCodePaymentSessionNotFound, "Payment session not found", false}},
+	{services.ErrPaymentSessionExpired, apiError{http.StatusGone, models.ErrCodePaymentSessionExpired, "Payment session has expired, please start a new payment", false}},
+	{services.ErrPaymentAmountMismatch, apiError{http.StatusBadRequest, models.ErrCodePaymentAmountMismatch, "Incorrect payment amount", false}},
+	{services.ErrPaymentCodeMissing, apiError{http.StatusBadRequest, models.ErrCodePaymentCodeMissing, "Payment code not found in transfer content", false}},
+	{services.ErrPaymentCodeUnknown, apiError{http.StatusNotFound, models.ErrCodePaymentCodeUnknown, "Unknown payment code", false}},
+
+	// Downloads
+	{services.ErrNotOwned, apiError{http.StatusForbidden, models.ErrCodeNotOwned, "You do not own this product. Please purchase it first.", false}},
+	{services.ErrSerialMismatch, apiError{http.StatusForbidden, models.ErrCodeSerialMismatch, "Serial number does not match your account", false}},
+	{services.ErrFileNotFound, apiError{http.StatusNotFound, models.ErrCodeFileNotFound, "Product file not found", false}},
+	{services.ErrFilePreparing, apiError{http.StatusServiceUnavailable, models.ErrCodeServiceUnavailable, "The file is being prepared. Please try again in a minute.", false}},
+	{services.ErrDownloadLinkInvalid, apiError{http.StatusGone, models.ErrCodeDownloadLinkInvalid, "Download link is invalid or has expired", false}},
+	{services.ErrDownloadLinksDisabled, apiError{http.StatusServiceUnavailable, models.ErrCodeServiceUnavailable, "Download links are not available", false}},
+	{services.ErrDownloadQuotaExceeded, apiError{http.StatusTooManyRequests, models.ErrCodeDownloadQuotaExceeded, "Daily download limit reached for this product. Please try again tomorrow.", false}},
+	{services.ErrDownloadIPLimit, apiError{http.StatusForbidden, models.ErrCodeDownloadIPLimit, "This account has been used to download from too many networks this week. Please contact support.", false}},
+	{services.ErrWatermarkDisabled, apiError{http.StatusServiceUnavailable, models.ErrCodeServiceUnavailable, "Watermarking is not available", false}},
+
+	// Admin analytics
+	{services.ErrInvalidDateRange, apiError{http.StatusBadRequest, models.ErrCodeValidationFailed, "", true}},
+
+	// Releases
+	{services.ErrReleaseNotFound, apiError{http.StatusNotFound, models.ErrCodeReleaseNotFound, "Release not found", false}},
+	{services.ErrReleaseExists, apiError{http.StatusConflict, models.ErrCodeReleaseExists, "A release with this version already exists", false}},
+	{services.ErrInvalidRelease, apiError{http.StatusBadRequest, models.ErrCodeValidationFailed, "", true}},
+
+	// Build uploads
+	{services.ErrUploadNotFound, apiError{http.StatusNotFound, models.ErrCodeUploadNotFound, "Upload not found or expired", false}},
+	{services.ErrUploadOffsetMismatch, apiError{http.StatusConflict, models.ErrCodeUploadOffsetMismatch, "Upload offset does not match the bytes received. Resume from the current offset.", false}},
+	{services.ErrUploadTooLarge, apiError{http.StatusRequestEntityTooLarge, models.ErrCodePayloadTooLarge, "Upload exceeds the allowed size", false}},
+	{services.ErrUploadIncomplete, apiError{http.StatusConflict, models.ErrCodeUploadIncomplete, "Upload is not complete", false}},
+	{services.ErrChecksumMismatch, apiError{http.StatusBadRequest, models.ErrCodeChecksumMismatch, "Uploaded file does not match the expected SHA-256 checksum", false}},
+	{services.ErrInvalidExecutable, apiError{http.StatusBadRequest, models.ErrCodeInvalidExecutable, "Uploaded file is not a valid executable for the platform", false}},
+
+	// Data export
+	{services.ErrExportTooSoon, apiError{http.StatusTooManyRequests, models.ErrCodeExportTooSoon, "A data export was requested recently. Please try again later.", false}},
+	{services.ErrExportNotFound, apiError{http.StatusNotFound, models.ErrCodeExportNotFound, "No data export requested", false}},
+	{services.ErrExportLinkInvalid, apiError{http.StatusGone, models.ErrCodeExportLinkInvalid, "Download link is invalid or has expired", false}},
+
+	// Licenses
+	{services.ErrDeviceLimit, apiError{http.StatusConflict, models.ErrCodeLicenseDeviceLimit, "This license is already active on the maximum number of devices. Deactivate or transfer a device first.", false}},
+	{services.ErrDeviceActivated, apiError{http.StatusConflict, models.ErrCodeLicenseDeviceActive, "This device is already activated", false}},
+	{services.ErrLicenseTransferLimit, apiError{http.StatusTooManyRequests, models.ErrCodeLicenseTransferLimit, "Too many devices were deactivated recently. Please try again later or contact support.", false}},
+	{services.ErrActivationNotFound, apiError{http.StatusNotFound, models.ErrCodeActivationNotFound, "Activation not found", false}},
+	{services.ErrLicenseSigningDisabled, apiError{http.StatusServiceUnavailable, models.ErrCodeServiceUnavailable, "License files are not available", false}},
+
+	// Admin
+	{services.ErrJobNotFound, apiError{http.StatusNotFound, models.ErrCodeJobNotFound, "Job not found", false}},
+	{services.ErrWorkflowNotFound, apiError{http.StatusNotFound, models.ErrCodeWorkflowNotFound, "Workflow not found", false}},
+}
+
+// lookupError returns the mapping for err, if it wraps a known sentinel
+func lookupError(err error) (apiError, bool) {
+	for _, entry := range errorTable {
+		if errors.Is(err, entry.err) {
+			mapped := entry.apiError
+			if mapped.detail {
+				mapped.message = err.Error()
+			}
+			return mapped, true
+		}
+	}
+	return apiError{}, false
+}
+
+// writeError writes the response for a service error. Unknown errors are
+// logged and answered with a generic 500 so internals never reach clients.
+func writeError(w http.ResponseWriter, err error) {
+	if mapped, ok := lookupError(err); ok {
+		writeCodedError(w, mapped.status, mapped.code, mapped.message)
+		return
+	}
+
+	log.Printf("API ERROR: Unmapped error (request %s): %v", w.Header().Get(auth.RequestIDHeader), err)
+	writeCodedError(w, http.StatusInternalServerError, models.ErrCodeInternal, "Internal server error")
+}
+
+// writeErrorMessage writes the code and status for a known error with a
+// message suited to the endpoint, falling back to writeError otherwise
+func writeErrorMessage(w http.ResponseWriter, err error, message string) {
+	if mapped, ok := lookupError(err); ok {
+		writeCodedError(w, mapped.status, mapped.code, message)
+		return
+	}
+	writeError(w, err)
+}
+
+// writeCodedError writes an error response with an explicit code, translating
+// the message into the response language
+func writeCodedError(w http.ResponseWriter, statusCode int, code models.ErrorCode, message string) {
+	writeJSONResponse(w, statusCode, models.ErrorResponse{
+		Error:     i18n.T(i18n.FromHeader(w.Header()), message),
+		Code:      code,
+		Status:    statusCode,
+		RequestID: w.Header().Get(auth.RequestIDHeader),
+	})
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"path/filepath"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"jinzmedia-atmt/auth"
+	"jinzmedia-atmt/services"
+)
+
+type DataExportHandlers struct {
+	exportService *services.DataExportService
+}
+
+func NewDataExportHandlers(exportService *services.DataExportService) *DataExportHandlers {
+	return &DataExportHandlers{
+		exportService: exportService,
+	}
+}
+
+// RequestExport queues a ZIP export of the current user's personal data
+func (h *DataExportHandlers) RequestExport(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	export, err := h.exportService.RequestExport(r.Context(), user.ID)
+	if err != nil {
+		if err != services.ErrExportTooSoon {
+			log.Printf("EXPORT ERROR: Failed to queue export for %s: %v", user.Email, err)
+		}
+		writeError(w, err)
+		return
+	}
+
+	writeSuccessResponse(w, http.StatusAccepted, "Data export requested. We will email you a download link when it is ready.", export)
+}
+
+// GetExportStatus returns the status of the current user's latest export
+func (h *DataExportHandlers) GetExportStatus(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	export, err := h.exportService.GetLatestExport(r.Context(), user.ID)
+	if err != nil {
+		if err != services.ErrExportNotFound {
+			log.Printf("EXPORT ERROR: Failed to get export status for %s: %v", user.Email, err)
+		}
+		writeError(w, err)
+		return
+	}
+
+	writeSuccessResponse(w, http.StatusOK, "Data export retrieved", export)
+}
+
+// DownloadExport serves a ready export to the holder of the emailed link
+func (h *DataExportHandlers) DownloadExport(w http.ResponseWriter, r *http.Request) {
+	exportID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErrorResponse(w, http.StatusNotFound, "Data export not found")
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeErrorResponse(w, http.StatusBadRequest, "Token is required")
+		return
+	}
+
+	path, err := h.exportService.OpenExport(r.Context(), exportID, token)
+	if err != nil {
+		if err != services.ErrExportLinkInvalid {
+			log.Printf("EXPORT ERROR: Failed to open export %s: %v", exportID.Hex(), err)
+		}
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+filepath.Base(path)+"\"")
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeFile(w, r, path)
+}
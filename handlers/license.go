@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"jinzmedia-atmt/auth"
+	"jinzmedia-atmt/models"
+	"jinzmedia-atmt/services"
+)
+
+type LicenseHandlers struct {
+	licenseService *services.LicenseService
+}
+
+func NewLicenseHandlers(licenseService *services.LicenseService) *LicenseHandlers {
+	return &LicenseHandlers{
+		licenseService: licenseService,
+	}
+}
+
+// GetLicense returns the current user's license and activated devices
+func (h *LicenseHandlers) GetLicense(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	status, err := h.licenseService.GetStatus(r.Context(), user)
+	if err != nil {
+		log.Printf("LICENSE ERROR: Failed to get license of %s: %v", user.Email, err)
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to get license")
+		return
+	}
+
+	writeSuccessResponse(w, http.StatusOK, "License retrieved", status)
+}
+
+// Activate binds the license to the calling device
+func (h *LicenseHandlers) Activate(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req models.ActivateLicenseRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	activation, created, err := h.licenseService.Activate(r.Context(), user, &req, clientIP(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if !created {
+		writeSuccessResponse(w, http.StatusOK, "Device is already activated", activation)
+		return
+	}
+	writeSuccessResponse(w, http.StatusCreated, "Device activated", activation)
+}
+
+// Deactivate releases the seat held by the calling device
+func (h *LicenseHandlers) Deactivate(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req models.DeactivateLicenseRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.licenseService.Deactivate(r.Context(), user, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeSuccessResponse(w, http.StatusOK, "Device deactivated", nil)
+}
+
+// DeactivateDevice releases the seat of one of the user's devices by ID
+func (h *LicenseHandlers) DeactivateDevice(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	activationID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid activation ID")
+		return
+	}
+
+	if err := h.licenseService.DeactivateByID(r.Context(), user, activationID); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeSuccessResponse(w, http.StatusOK, "Device deactivated", nil)
+}
+
+// Transfer moves an activation to the calling device
+func (h *LicenseHandlers) Transfer(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req models.TransferLicenseRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	activationID, err := primitive.ObjectIDFromHex(req.ActivationID)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid activation ID")
+		return
+	}
+
+	activation, err := h.licenseService.Transfer(r.Context(), user, activationID, &req.Device, clientIP(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeSuccessResponse(w, http.StatusOK, "License transferred", activation)
+}
+
+// Heartbeat reports that an app is running and returns whether its license
+// is still valid. The device proves itself with the activation secret and
+// its fingerprint, so apps can report in without signing in.
+func (h *LicenseHandlers) Heartbeat(w http.ResponseWriter, r *http.Request) {
+	var req models.LicenseHeartbeatRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	activationID, err := primitive.ObjectIDFromHex(req.ActivationID)
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid activation ID")
+		return
+	}
+
+	response, err := h.licenseService.Heartbeat(r.Context(), activationID, &req, clientIP(r))
+	if err != nil {
+		if writeLimitedResponse(w, err) {
+			return
+		}
+		writeError(w, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+// IssueLicenseFile returns a signed license file for the calling device
+func (h *LicenseHandlers) IssueLicenseFile(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req models.LicenseFileRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	data, err := h.licenseService.IssueLicenseFile(r.Context(), user, &req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="atmt.lic"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+// GetRevocationList serves the signed list of revoked license files
+func (h *LicenseHandlers) GetRevocationList(w http.ResponseWriter, r *http.Request) {
+	data, nextUpdate, err := h.licenseService.RevocationList(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	maxAge := int(time.Until(nextUpdate).Seconds())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", max(maxAge, 0)))
+	w.Write(data)
+}
+
+// PublicKeys publishes the keys that verify license files
+func (h *LicenseHandlers) PublicKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.licenseService.PublicKeys()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSONResponse(w, http.StatusOK, keys)
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"jinzmedia-atmt/auth"
+	"jinzmedia-atmt/models"
+)
+
+// OIDCLogin redirects the browser to the provider to sign in
+func (h *AuthHandlers) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider := chi.URLParam(r, "provider")
+
+	authURL, browserKey, err := h.authService.BeginOIDC(r.Context(), provider, models.OIDCIntentLogin, nil)
+	if err != nil {
+		if err == auth.ErrOIDCProviderNotFound {
+			writeCodedError(w, http.StatusNotFound, models.ErrCodeOIDCProviderNotFound, "Sign-in provider not available")
+			return
+		}
+		log.Printf("OIDC ERROR: Failed to start %s login: %v", provider, err)
+		writeErrorResponse(w, http.StatusBadGateway, "Failed to start sign-in")
+		return
+	}
+
+	setOIDCBrowserCookie(w, r, browserKey)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes a provider sign-in or link started by this service
+func (h *AuthHandlers) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider := chi.URLParam(r, "provider")
+	query := r.URL.Query()
+
+	// The user cancelled or the provider refused the request
+	if providerErr := query.Get("error"); providerErr != "" {
+		writeErrorResponse(w, http.StatusBadRequest, localize(w, "Sign-in was not completed: %s", providerErr))
+		return
+	}
+
+	code, state := query.Get("code"), query.Get("state")
+	if code == "" || state == "" {
+		writeErrorResponse(w, http.StatusBadRequest, "Code and state are required")
+		return
+	}
+
+	var browserKey string
+	if cookie, err := r.Cookie(oidcBrowserCookie); err == nil {
+		browserKey = cookie.Value
+	}
+	clearOIDCBrowserCookie(w, r)
+
+	result, err := h.authService.CompleteOIDC(r.Context(), provider, code, state, browserKey)
+	if err != nil {
+		switch err {
+		case auth.ErrOIDCProviderNotFound:
+			writeCodedError(w, http.StatusNotFound, models.ErrCodeOIDCProviderNotFound, "Sign-in provider not available")
+		case auth.ErrOIDCStateInvalid:
+			writeCodedError(w, http.StatusBadRequest, models.ErrCodeOIDCStateInvalid, "Sign-in request is invalid or has expired")
+		case auth.ErrOIDCEmailNotVerified:
+			writeCodedError(w, http.StatusForbidden, models.ErrCodeOIDCEmailNotVerified, "Your email address is not verified with the provider")
+		case auth.ErrIdentityLinked:
+			writeCodedError(w, http.StatusConflict, models.ErrCodeIdentityLinked, "This account is already linked to another user")
+		default:
+			log.Printf("OIDC ERROR: %s callback failed: %v", provider, err)
+			if err == auth.ErrAccountDisabled {
+				writeError(w, err)
+				return
+			}
+			writeErrorResponse(w, http.StatusUnauthorized, "Sign-in failed")
+		}
+		return
+	}
+
+	if result.Intent == models.OIDCIntentLink {
+		log.Printf("OIDC: User %s linked %s", result.User.Email, provider)
+		writeSuccessResponse(w, http.StatusOK, "Provider linked", result.User)
+		return
+	}
+
+	// Second factor required: return only the challenge token
+	if result.Login.MFARequired {
+		writeJSONResponse(w, http.StatusOK, models.LoginResponse{
+			MFARequired: true,
+			MFAToken:    result.Login.MFAToken,
+			ExpiresAt:   result.Login.ExpiresAt,
+		})
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, result.Login)
+}
+
+// LinkProvider returns the provider URL for attaching it to the current account
+func (h *AuthHandlers) LinkProvider(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	provider := chi.URLParam(r, "provider")
+
+	authURL, browserKey, err := h.authService.BeginOIDC(r.Context(), provider, models.OIDCIntentLink, &user.ID)
+	if err != nil {
+		if err == auth.ErrOIDCProviderNotFound {
+			writeCodedError(w, http.StatusNotFound, models.ErrCodeOIDCProviderNotFound, "Sign-in provider not available")
+			return
+		}
+		log.Printf("OIDC ERROR: Failed to start %s link for %s: %v", provider, user.Email, err)
+		writeErrorResponse(w, http.StatusBadGateway, "Failed to start provider linking")
+		return
+	}
+
+	setOIDCBrowserCookie(w, r, browserKey)
+	writeJSONResponse(w, http.StatusOK, models.OIDCAuthorizationResponse{AuthorizationURL: authURL})
+}
+
+// UnlinkProvider removes a provider from the current account
+func (h *AuthHandlers) UnlinkProvider(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	provider := chi.URLParam(r, "provider")
+
+	if err := h.authService.UnlinkIdentity(r.Context(), user, provider); err != nil {
+		switch err {
+		case auth.ErrIdentityNotLinked:
+			writeCodedError(w, http.StatusNotFound, models.ErrCodeIdentityNotLinked, "Provider is not linked to this account")
+		case auth.ErrLastSignInMethod:
+			writeCodedError(w, http.StatusConflict, models.ErrCodeLastSignInMethod, "Set a password or link another provider before unlinking this one")
+		default:
+			log.Printf("OIDC ERROR: Failed to unlink %s for %s: %v", provider, user.Email, err)
+			writeErrorResponse(w, http.StatusInternalServerError, "Failed to unlink provider")
+		}
+		return
+	}
+
+	log.Printf("OIDC: User %s unlinked %s", user.Email, provider)
+	writeSuccessResponse(w, http.StatusOK, "Provider unlinked", nil)
+}
+
+// oidcBrowserCookie holds the key binding a sign-in attempt to the browser
+// that started it. It is a session cookie scoped to the callback routes and
+// sent on the top-level redirect back from the provider, which SameSite=Lax
+// allows; the state itself still expires on the server.
+const oidcBrowserCookie = "oidc_browser"
+
+const oidcCookiePath = "/api/v1/auth/oidc/"
+
+func setOIDCBrowserCookie(w http.ResponseWriter, r *http.Request, browserKey string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcBrowserCookie,
+		Value:    browserKey,
+		Path:     oidcCookiePath,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearOIDCBrowserCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcBrowserCookie,
+		Path:     oidcCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isSecureRequest(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// isSecureRequest reports whether the browser reached the API over HTTPS,
+// directly or through a TLS-terminating proxy
+func isSecureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
@@ -40,20 +40,7 @@ func (ph *PaymentHandler) InitiatePayment(w http.ResponseWriter, r *http.Request
 	paymentSession, err := ph.paymentService.InitiatePayment(user.ID)
 	if err != nil {
 		log.Printf("PAYMENT ERROR: Failed to initiate payment for user %s: %v", user.Email, err)
-		if err.Error() == "user not found" {
-			writeErrorResponse(w, http.StatusNotFound, "User not found")
-			return
-		}
-		if err.Error() == "user is banned and cannot make payments" {
-			writeErrorResponse(w, http.StatusForbidden, "User is banned")
-			return
-		}
-		if err.Error() == "user already owns the product" {
-			writeErrorResponse(w, http.StatusConflict, "User already owns the product")
-			return
-		}
-		
-		writeErrorResponse(w, http.StatusInternalServerError, "Failed to initiate payment: "+err.Error())
+		writeError(w, err)
 		return
 	}
 
@@ -65,7 +52,7 @@ func (ph *PaymentHandler) InitiatePayment(w http.ResponseWriter, r *http.Request
 		Amount:      paymentSession.Amount,
 		QRImageURL:  paymentSession.QRImageURL,
 		ExpiresAt:   paymentSession.ExpiresAt.Format(time.RFC3339),
-		Message:     "Please scan the QR code to complete payment. Payment code: " + paymentSession.PaymentCode,
+		Message:     localize(w, "Please scan the QR code to complete payment. Payment code: %s", paymentSession.PaymentCode),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -95,11 +82,7 @@ func (ph *PaymentHandler) GetPaymentStatus(w http.ResponseWriter, r *http.Reques
 	paymentSession, err := ph.paymentService.GetPaymentSession(sessionID)
 	if err != nil {
 		log.Printf("PAYMENT ERROR: Failed to get payment session %s: %v", sessionIDStr, err)
-		if err.Error() == "payment session not found" {
-			writeErrorResponse(w, http.StatusNotFound, "Payment session not found")
-			return
-		}
-		writeErrorResponse(w, http.StatusInternalServerError, "Failed to get payment session: "+err.Error())
+		writeError(w, err)
 		return
 	}
 
@@ -110,6 +93,13 @@ func (ph *PaymentHandler) GetPaymentStatus(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// A pending session past its deadline no longer shows a usable QR code
+	if paymentSession.Status == models.PaymentStatusExpired ||
+		(paymentSession.Status == models.PaymentStatusPending && time.Now().After(paymentSession.ExpiresAt)) {
+		writeError(w, services.ErrPaymentSessionExpired)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(paymentSession)
 }
@@ -130,7 +120,7 @@ func (ph *PaymentHandler) GetUserPaymentSessions(w http.ResponseWriter, r *http.
 	sessions, err := ph.paymentService.GetUserPaymentSessions(user.ID)
 	if err != nil {
 		log.Printf("PAYMENT ERROR: Failed to get payment sessions for user %s: %v", user.Email, err)
-		writeErrorResponse(w, http.StatusInternalServerError, "Failed to get payment sessions: "+err.Error())
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to get payment sessions")
 		return
 	}
 
@@ -168,11 +158,11 @@ func (ph *PaymentHandler) RefreshPayment(w http.ResponseWriter, r *http.Request)
 	}
 
 	if updatedUser.Owned {
-		response["message"] = "Payment confirmed! You now have access to all products."
+		response["message"] = localize(w, "Payment confirmed! You now have access to all products.")
 		response["redirect"] = "/dashboard"
 		log.Printf("PAYMENT SUCCESS: User %s payment confirmed - granting access", user.Email)
 	} else {
-		response["message"] = "Payment not yet confirmed. Please wait a moment and try again."
+		response["message"] = localize(w, "Payment not yet confirmed. Please wait a moment and try again.")
 		response["redirect"] = "/payment"
 		log.Printf("PAYMENT PENDING: User %s payment still pending", user.Email)
 	}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"jinzmedia-atmt/auth"
+	"jinzmedia-atmt/models"
+	"jinzmedia-atmt/services"
+)
+
+type ReleaseHandlers struct {
+	releaseService *services.ReleaseService
+	uploadService  *services.BuildUploadService
+}
+
+func NewReleaseHandlers(releaseService *services.ReleaseService, uploadService *services.BuildUploadService) *ReleaseHandlers {
+	return &ReleaseHandlers{
+		releaseService: releaseService,
+		uploadService:  uploadService,
+	}
+}
+
+// ListReleases lists the published releases of a product in a channel with
+// their release notes, newest first
+func (h *ReleaseHandlers) ListReleases(w http.ResponseWriter, r *http.Request) {
+	productName := chi.URLParam(r, "product_name")
+	platform, _, ok := resolveDownloadTarget(w, r, productName, chi.URLParam(r, "platform"))
+	if !ok {
+		return
+	}
+
+	channel, ok := parseReleaseChannel(w, r.URL.Query().Get("channel"))
+	if !ok {
+		return
+	}
+
+	releases, err := h.releaseService.ListPublished(r.Context(), productName, platform, channel)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeSuccessResponse(w, http.StatusOK, "Releases retrieved", releases)
+}
+
+// AdminListReleases lists all releases including unpublished ones, optionally
+// filtered by ?product= and ?platform=
+func (h *ReleaseHandlers) AdminListReleases(w http.ResponseWriter, r *http.Request) {
+	releases, err := h.releaseService.ListReleases(r.Context(), r.URL.Query().Get("product"), r.URL.Query().Get("platform"))
+	if err != nil {
+		log.Printf("ADMIN ERROR: Failed to list releases: %v", err)
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to list releases")
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    releases,
+	})
+}
+
+// AdminCreateRelease registers a build copied to dist/<product>/<platform>/
+func (h *ReleaseHandlers) AdminCreateRelease(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateReleaseRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	release, err := h.releaseService.CreateRelease(r.Context(), &req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	admin := auth.GetUserFromContext(r.Context())
+	log.Printf("ADMIN RELEASE: %s created %s %s/%s (%s)", admin.Email, release.Version, release.ProductName, release.Platform, release.Channel)
+	writeJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"data":    release,
+	})
+}
+
+// AdminUpdateRelease edits a release, or publishes or withdraws it. Withdrawing
+// the newest release rolls downloads back to the previous one.
+func (h *ReleaseHandlers) AdminUpdateRelease(w http.ResponseWriter, r *http.Request) {
+	releaseID, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid release ID")
+		return
+	}
+
+	var req models.UpdateReleaseRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	release, err := h.releaseService.UpdateRelease(r.Context(), releaseID, &req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	admin := auth.GetUserFromContext(r.Context())
+	log.Printf("ADMIN RELEASE: %s updated %s %s/%s (%s, published: %t)", admin.Email, release.Version, release.ProductName, release.Platform, release.Channel, release.Published)
+	writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    release,
+	})
+}
+
+// parseReleaseChannel reads the ?channel= of a download, defaulting to stable
+func parseReleaseChannel(w http.ResponseWriter, value string) (models.ReleaseChannel, bool) {
+	switch channel := models.ReleaseChannel(value); channel {
+	case "":
+		return models.ReleaseChannelStable, true
+	case models.ReleaseChannelStable, models.ReleaseChannelBeta:
+		return channel, true
+	}
+	writeErrorResponse(w, http.StatusBadRequest, "Invalid release channel. Must be 'stable' or 'beta'")
+	return "", false
+}
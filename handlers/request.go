@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"jinzmedia-atmt/auth"
+	"jinzmedia-atmt/config"
+	"jinzmedia-atmt/i18n"
+	"jinzmedia-atmt/models"
+	"jinzmedia-atmt/validation"
+)
+
+// defaultMaxBodySize applies when server.max_body_size is not configured
+const defaultMaxBodySize = 1 << 20
+
+// decodeJSON decodes the request body into dst and validates it against its
+// `validate` tags. Unknown fields, trailing data and bodies larger than
+// server.max_body_size are rejected. On failure the error response has been
+// written and false is returned.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	maxSize := config.Get().Server.MaxBodySize
+	if maxSize <= 0 {
+		maxSize = defaultMaxBodySize
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSize))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(dst)
+	if err == nil {
+		// The body must hold exactly one JSON value
+		if _, extra := decoder.Token(); extra != io.EOF {
+			err = errors.New("unexpected data after JSON body")
+		}
+	}
+	if err != nil {
+		writeDecodeError(w, err)
+		return false
+	}
+
+	if errs := validation.Struct(dst); errs != nil {
+		writeValidationErrors(w, errs)
+		return false
+	}
+
+	return true
+}
+
+// writeDecodeError maps JSON decoding failures to 400, or 413 for oversized bodies
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &maxBytesErr):
+		writeErrorResponse(w, http.StatusRequestEntityTooLarge,
+			localize(w, "Request body must not exceed %d bytes", maxBytesErr.Limit))
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		writeValidationErrors(w, validation.Errors{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Param:   typeErr.Type.String(),
+			Message: "must be a " + typeErr.Type.String(),
+		}})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		writeValidationErrors(w, validation.Errors{{
+			Field:   field,
+			Rule:    "unknown",
+			Message: "is not a recognized field",
+		}})
+	case errors.Is(err, io.EOF):
+		writeErrorResponse(w, http.StatusBadRequest, "Request body is required")
+	default:
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+	}
+}
+
+// writeValidationErrors writes a 400 listing each rejected field
+func writeValidationErrors(w http.ResponseWriter, errs validation.Errors) {
+	lang := i18n.FromHeader(w.Header())
+	writeJSONResponse(w, http.StatusBadRequest, models.ErrorResponse{
+		Error:     i18n.T(lang, "Validation failed"),
+		Code:      models.ErrCodeValidationFailed,
+		Status:    http.StatusBadRequest,
+		RequestID: w.Header().Get(auth.RequestIDHeader),
+		Fields:    errs.Localize(lang),
+	})
+}
+
+// localize formats a message in the response language. Static messages
+// passed to the write helpers are translated there; use this for messages
+// built from a format string.
+func localize(w http.ResponseWriter, format string, args ...interface{}) string {
+	return i18n.Sprintf(i18n.FromHeader(w.Header()), format, args...)
+}
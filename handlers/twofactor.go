@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"jinzmedia-atmt/auth"
+	"jinzmedia-atmt/models"
+)
+
+// VerifyTwoFactorLogin completes a login that returned mfa_required
+func (h *AuthHandlers) VerifyTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	var req models.MFAVerifyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	response, err := h.authService.VerifyMFAChallenge(r.Context(), req.MFAToken, req.Code, clientIP(r))
+	if err != nil {
+		if writeLockedResponse(w, err) {
+			return
+		}
+		switch err {
+		case auth.ErrInvalidMFACode:
+			writeCodedError(w, http.StatusUnauthorized, models.ErrCodeMFACodeInvalid, "Invalid two-factor code")
+		case auth.ErrInvalidToken, auth.ErrUserNotFound, auth.ErrTwoFactorNotEnabled:
+			writeCodedError(w, http.StatusUnauthorized, models.ErrCodeTokenInvalid, "Invalid or expired MFA token")
+		default:
+			writeError(w, err)
+		}
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+// SetupTwoFactor starts TOTP enrollment and returns the secret and provisioning URI
+func (h *AuthHandlers) SetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	setup, err := h.authService.SetupTwoFactor(r.Context(), user)
+	if err != nil {
+		if err == auth.ErrTwoFactorAlreadyEnabled {
+			writeCodedError(w, http.StatusConflict, models.ErrCodeMFAAlreadyEnabled, "Two-factor authentication is already enabled")
+			return
+		}
+		log.Printf("2FA ERROR: Failed to start setup for user %s: %v", user.Email, err)
+		writeErrorResponse(w, http.StatusInternalServerError, "Failed to start two-factor setup")
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, setup)
+}
+
+// EnableTwoFactor confirms enrollment and returns recovery codes
+func (h *AuthHandlers) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	codes, err := h.authService.EnableTwoFactor(r.Context(), user, req.Code)
+	if err != nil {
+		switch err {
+		case auth.ErrTwoFactorAlreadyEnabled:
+			writeCodedError(w, http.StatusConflict, models.ErrCodeMFAAlreadyEnabled, "Two-factor authentication is already enabled")
+		case auth.ErrTwoFactorSetupRequired:
+			writeCodedError(w, http.StatusBadRequest, models.ErrCodeMFASetupRequired, "Start two-factor setup first")
+		case auth.ErrInvalidMFACode:
+			writeCodedError(w, http.StatusBadRequest, models.ErrCodeMFACodeInvalid, "Invalid two-factor code")
+		default:
+			log.Printf("2FA ERROR: Failed to enable for user %s: %v", user.Email, err)
+			writeErrorResponse(w, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		}
+		return
+	}
+
+	log.Printf("2FA: User %s enabled two-factor authentication", user.Email)
+	writeSuccessResponse(w, http.StatusOK, "Two-factor authentication enabled. Store these recovery codes safely; they will not be shown again.",
+		models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor turns off two-factor authentication
+func (h *AuthHandlers) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req models.TwoFactorDisableRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	err := h.authService.DisableTwoFactor(r.Context(), user, req.Password, req.Code)
+	if err != nil {
+		switch err {
+		case auth.ErrTwoFactorNotEnabled:
+			writeCodedError(w, http.StatusBadRequest, models.ErrCodeMFANotEnabled, "Two-factor authentication is not enabled")
+		case auth.ErrTwoFactorRequired:
+			writeCodedError(w, http.StatusForbidden, models.ErrCodeMFARequired, "Two-factor authentication is required for this account")
+		case auth.ErrInvalidCredentials:
+			writeCodedError(w, http.StatusUnauthorized, models.ErrCodeInvalidCredentials, "Invalid password")
+		case auth.ErrInvalidMFACode:
+			writeCodedError(w, http.StatusBadRequest, models.ErrCodeMFACodeInvalid, "Invalid two-factor code")
+		default:
+			log.Printf("2FA ERROR: Failed to disable for user %s: %v", user.Email, err)
+			writeErrorResponse(w, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		}
+		return
+	}
+
+	log.Printf("2FA: User %s disabled two-factor authentication", user.Email)
+	writeSuccessResponse(w, http.StatusOK, "Two-factor authentication disabled", nil)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes
+func (h *AuthHandlers) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req models.TwoFactorCodeRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	codes, err := h.authService.RegenerateRecoveryCodes(r.Context(), user, req.Code)
+	if err != nil {
+		switch err {
+		case auth.ErrTwoFactorNotEnabled:
+			writeCodedError(w, http.StatusBadRequest, models.ErrCodeMFANotEnabled, "Two-factor authentication is not enabled")
+		case auth.ErrInvalidMFACode:
+			writeCodedError(w, http.StatusBadRequest, models.ErrCodeMFACodeInvalid, "Invalid two-factor code")
+		default:
+			log.Printf("2FA ERROR: Failed to regenerate recovery codes for user %s: %v", user.Email, err)
+			writeErrorResponse(w, http.StatusInternalServerError, "Failed to regenerate recovery codes")
+		}
+		return
+	}
+
+	writeSuccessResponse(w, http.StatusOK, "Recovery codes regenerated", models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
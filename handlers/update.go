@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"jinzmedia-atmt/auth"
+	"jinzmedia-atmt/models"
+)
+
+// CheckForUpdate tells a desktop app whether a newer release is available,
+// with a signed link to download it
+func (dh *DownloadHandlers) CheckForUpdate(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	productName := chi.URLParam(r, "product_name")
+	platform, arch, ok := resolveDownloadTarget(w, r, productName, chi.URLParam(r, "platform"))
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	channel, ok := parseReleaseChannel(w, query.Get("channel"))
+	if !ok {
+		return
+	}
+	current := query.Get("current")
+	if current != "" && !models.IsValidVersion(current) {
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid version")
+		return
+	}
+	osVersion := query.Get("os_version")
+	if osVersion != "" && !models.IsValidVersion(osVersion) {
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid OS version")
+		return
+	}
+
+	response, err := dh.downloadService.CheckForUpdate(r.Context(), user.ID, productName, platform, arch, current, osVersion, channel)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+// Appcast serves a Sparkle appcast for the macOS build of a product
+func (dh *DownloadHandlers) Appcast(w http.ResponseWriter, r *http.Request) {
+	user := auth.GetUserFromContext(r.Context())
+	if user == nil {
+		writeErrorResponse(w, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	productName := chi.URLParam(r, "product_name")
+	_, arch, ok := resolveDownloadTarget(w, r, productName, string(models.PlatformMacOS))
+	if !ok {
+		return
+	}
+
+	channel, ok := parseReleaseChannel(w, r.URL.Query().Get("channel"))
+	if !ok {
+		return
+	}
+
+	data, err := dh.downloadService.Appcast(r.Context(), user.ID, productName, arch, channel)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+
+	"jinzmedia-atmt/auth"
+	"jinzmedia-atmt/validation"
+)
+
+// TraceWatermark receives a suspicious build as multipart/form-data in the
+// "file" part and reports the account it was issued to
+func (h *AdminHandlers) TraceWatermark(w http.ResponseWriter, r *http.Request) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeErrorResponse(w, http.StatusBadRequest, "Request must be multipart/form-data")
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			writeValidationErrors(w, validation.Errors{{Field: "file", Rule: "required", Message: "is required"}})
+			return
+		}
+		if err != nil {
+			writeErrorResponse(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		report, err := h.downloadService.TraceFile(r.Context(), part)
+		if err != nil {
+			log.Printf("ADMIN ERROR: Failed to trace watermark: %v", err)
+			writeError(w, err)
+			return
+		}
+
+		admin := auth.GetUserFromContext(r.Context())
+		if report.User != nil {
+			log.Printf("ADMIN WATERMARK: %s traced file %s to user %s", admin.Email, report.SHA256, report.User.Email)
+		} else {
+			log.Printf("ADMIN WATERMARK: %s could not trace file %s", admin.Email, report.SHA256)
+		}
+		writeJSONResponse(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"data":    report,
+		})
+		return
+	}
+}
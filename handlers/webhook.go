@@ -2,8 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
-	"strings"
 
 	"jinzmedia-atmt/models"
 	"jinzmedia-atmt/services"
@@ -24,32 +24,29 @@ func (h *WebhookHandler) HandleSepayWebhook(w http.ResponseWriter, r *http.Reque
 	// Validate authorization header
 	authHeader := r.Header.Get("Authorization")
 	if authHeader != "ApiKey xoxoxoxoxoxo" {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeErrorResponse(w, http.StatusUnauthorized, "Unauthorized")
 		return
 	}
 
 	// Parse webhook payload
 	var webhookReq models.SepayWebhookRequest
 	if err := json.NewDecoder(r.Body).Decode(&webhookReq); err != nil {
-		http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+		writeErrorResponse(w, http.StatusBadRequest, "Invalid JSON payload")
 		return
 	}
 
 	// Process the payment
 	_, err := h.paymentService.ProcessWebhookPayment(&webhookReq)
 	if err != nil {
-		// Log error but return success to prevent webhook retries
-		// In production, you might want to implement proper error handling
-		// and return appropriate status codes based on error type
-		if strings.Contains(err.Error(), "payment already processed") {
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(map[string]string{"status": "already_processed"})
-			return
+		// Return success to prevent webhook retries; the payment record keeps
+		// the details and the code tells SePay's log why it was not applied
+		log.Printf("WEBHOOK ERROR: Payment %d not applied: %v", webhookReq.ID, err)
+		code := models.ErrCodeInternal
+		if mapped, ok := lookupError(err); ok {
+			code = mapped.code
 		}
-		
-		// For other errors, still return success to prevent webhook spam
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"status": "error", "message": err.Error()})
+		json.NewEncoder(w).Encode(map[string]string{"status": "error", "code": string(code)})
 		return
 	}
 
@@ -0,0 +1,146 @@
+// Package i18n localizes API messages.
+//
+// Messages are written in English in the code and used as catalog keys, so a
+// missing translation falls back to the English text. Catalogs live in
+// locales/<lang>.json and map each English message (or fmt format) to its
+// translation.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"jinzmedia-atmt/config"
+)
+
+// Supported languages
+const (
+	Vietnamese = "vi"
+	English    = "en"
+)
+
+// ContentLanguageHeader carries the negotiated language of a response
+const ContentLanguageHeader = "Content-Language"
+
+//go:embed locales/*.json
+var localeFS embed.FS
+
+// catalogs holds translations by language; English is the source language
+var catalogs = map[string]map[string]string{
+	Vietnamese: mustLoad(Vietnamese),
+}
+
+func mustLoad(lang string) map[string]string {
+	data, err := localeFS.ReadFile("locales/" + lang + ".json")
+	if err != nil {
+		panic(fmt.Sprintf("i18n: missing catalog %s: %v", lang, err))
+	}
+	messages := make(map[string]string)
+	if err := json.Unmarshal(data, &messages); err != nil {
+		panic(fmt.Sprintf("i18n: invalid catalog %s: %v", lang, err))
+	}
+	return messages
+}
+
+// T translates an English message into lang, or returns it unchanged
+func T(lang, message string) string {
+	if translated, ok := catalogs[lang][message]; ok && translated != "" {
+		return translated
+	}
+	return message
+}
+
+// Sprintf translates an English format string into lang and formats it
+func Sprintf(lang, format string, args ...interface{}) string {
+	return fmt.Sprintf(T(lang, format), args...)
+}
+
+// Normalize maps a language tag such as "vi-VN" to a supported language, or
+// returns "" if it is not supported
+func Normalize(tag string) string {
+	base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	base, _, _ = strings.Cut(base, "_")
+	switch base {
+	case Vietnamese, English:
+		return base
+	}
+	return ""
+}
+
+// DefaultLanguage returns the configured fallback language
+func DefaultLanguage() string {
+	if lang := Normalize(config.Get().I18n.DefaultLanguage); lang != "" {
+		return lang
+	}
+	return Vietnamese
+}
+
+// Negotiate picks the supported language with the highest weight from an
+// Accept-Language header, falling back to the default language
+func Negotiate(acceptLanguage string) string {
+	type candidate struct {
+		lang   string
+		weight float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		lang := Normalize(tag)
+		if lang == "" {
+			continue
+		}
+
+		weight := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			weight = parsed
+		}
+		if weight > 0 {
+			candidates = append(candidates, candidate{lang, weight})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return DefaultLanguage()
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].weight > candidates[j].weight
+	})
+	return candidates[0].lang
+}
+
+// FromHeader returns the language negotiated for a response, as set by
+// Middleware or SetLanguage
+func FromHeader(h http.Header) string {
+	if lang := Normalize(h.Get(ContentLanguageHeader)); lang != "" {
+		return lang
+	}
+	return DefaultLanguage()
+}
+
+// SetLanguage overrides the negotiated language of a response, e.g. with
+// the signed-in user's preference
+func SetLanguage(w http.ResponseWriter, lang string) {
+	if lang = Normalize(lang); lang != "" {
+		w.Header().Set(ContentLanguageHeader, lang)
+	}
+}
+
+// Middleware negotiates the response language from Accept-Language
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(ContentLanguageHeader, Negotiate(r.Header.Get("Accept-Language")))
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(w, r)
+	})
+}
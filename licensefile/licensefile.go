@@ -0,0 +1,258 @@
+// Package licensefile signs and verifies ATMT license files and revocation
+// lists for offline use.
+//
+// Both are JSON envelopes holding a base64url payload and an Ed25519
+// signature over the payload bytes, so no canonical JSON form is needed:
+//
+//	{"format": "atmt-license-v1", "key_id": "2026-10", "payload": "...", "signature": "..."}
+//
+// The package only depends on the standard library so the desktop apps can
+// embed it together with the published public keys.
+package licensefile
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Envelope formats
+const (
+	FormatLicense        = "atmt-license-v1"
+	FormatRevocationList = "atmt-revocations-v1"
+)
+
+// ClockSkew is the tolerance applied when checking that a license has
+// already been issued, for devices whose clock is slightly behind
+const ClockSkew = 5 * time.Minute
+
+var (
+	ErrMalformed      = errors.New("licensefile: malformed file")
+	ErrUnknownKey     = errors.New("licensefile: signed with an unknown key")
+	ErrBadSignature   = errors.New("licensefile: invalid signature")
+	ErrNotYetValid    = errors.New("licensefile: license is not valid yet")
+	ErrExpired        = errors.New("licensefile: license has expired")
+	ErrWrongDevice    = errors.New("licensefile: license belongs to another device")
+	ErrRevoked        = errors.New("licensefile: license has been revoked")
+	ErrNoProduct      = errors.New("licensefile: license does not include the product")
+	ErrFormatMismatch = errors.New("licensefile: unexpected file format")
+)
+
+// Envelope is the signed container written to disk
+type Envelope struct {
+	Format    string `json:"format"`
+	KeyID     string `json:"key_id"`
+	Payload   string `json:"payload"`   // base64url JSON
+	Signature string `json:"signature"` // base64url Ed25519 signature of the decoded payload
+}
+
+// License grants a user's products to one device
+type License struct {
+	ID              string    `json:"license_id"` // activation ID
+	UserID          string    `json:"user_id"`
+	Email           string    `json:"email"`
+	SerialNumber    string    `json:"serial_number"`
+	Products        []string  `json:"products"`
+	FingerprintHash string    `json:"fingerprint_hash"` // HashFingerprint of the device fingerprint
+	IssuedAt        time.Time `json:"issued_at"`
+	ExpiresAt       time.Time `json:"expires_at"`
+}
+
+// HasProduct reports whether the license includes product
+func (l *License) HasProduct(product string) bool {
+	for _, p := range l.Products {
+		if p == product {
+			return true
+		}
+	}
+	return false
+}
+
+// Revocation withdraws a license before it expires
+type Revocation struct {
+	LicenseID string    `json:"license_id"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
+
+// RevocationList lists revoked licenses that have not expired yet. Apps
+// should fetch a new list when NextUpdate has passed and they are online.
+type RevocationList struct {
+	IssuedAt   time.Time    `json:"issued_at"`
+	NextUpdate time.Time    `json:"next_update"`
+	Revoked    []Revocation `json:"revoked"`
+}
+
+// IsRevoked reports whether the list contains the license
+func (l *RevocationList) IsRevoked(licenseID string) bool {
+	for _, r := range l.Revoked {
+		if r.LicenseID == licenseID {
+			return true
+		}
+	}
+	return false
+}
+
+// HashFingerprint returns the SHA-256 hex digest stored in place of a
+// hardware fingerprint
+func HashFingerprint(fingerprint string) string {
+	sum := sha256.Sum256([]byte(fingerprint))
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign wraps payload in an envelope of the given format signed by key
+func Sign(format, keyID string, key ed25519.PrivateKey, payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("licensefile: failed to encode payload: %w", err)
+	}
+
+	return json.MarshalIndent(Envelope{
+		Format:    format,
+		KeyID:     keyID,
+		Payload:   base64.RawURLEncoding.EncodeToString(data),
+		Signature: base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, data)),
+	}, "", "  ")
+}
+
+// Verifier checks files against a set of trusted public keys
+type Verifier struct {
+	keys map[string]ed25519.PublicKey
+
+	// Now returns the current time; replaceable for tests
+	Now func() time.Time
+}
+
+// NewVerifier trusts the given public keys, indexed by key ID
+func NewVerifier(keys map[string]ed25519.PublicKey) *Verifier {
+	return &Verifier{keys: keys, Now: time.Now}
+}
+
+// VerifyLicense checks the signature and validity period of a license file
+// and that it was issued for the device with the given fingerprint
+func (v *Verifier) VerifyLicense(data []byte, fingerprint string) (*License, error) {
+	var license License
+	if err := v.open(data, FormatLicense, &license); err != nil {
+		return nil, err
+	}
+
+	now := v.Now()
+	if now.Add(ClockSkew).Before(license.IssuedAt) {
+		return nil, ErrNotYetValid
+	}
+	if !now.Before(license.ExpiresAt) {
+		return nil, ErrExpired
+	}
+	if license.FingerprintHash != HashFingerprint(fingerprint) {
+		return nil, ErrWrongDevice
+	}
+
+	return &license, nil
+}
+
+// VerifyRevocationList checks the signature of a revocation list. A list past
+// its NextUpdate is still returned; it remains the best information offline.
+func (v *Verifier) VerifyRevocationList(data []byte) (*RevocationList, error) {
+	var list RevocationList
+	if err := v.open(data, FormatRevocationList, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// Check verifies a license file for a device and product, rejecting it if
+// it appears on the revocation list. revocations may be nil when no list is
+// available yet.
+func (v *Verifier) Check(licenseData []byte, fingerprint, product string, revocations *RevocationList) (*License, error) {
+	license, err := v.VerifyLicense(licenseData, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	if revocations != nil && revocations.IsRevoked(license.ID) {
+		return nil, ErrRevoked
+	}
+	if product != "" && !license.HasProduct(product) {
+		return nil, ErrNoProduct
+	}
+	return license, nil
+}
+
+// open verifies an envelope and decodes its payload into dst
+func (v *Verifier) open(data []byte, format string, dst interface{}) error {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return ErrMalformed
+	}
+	if env.Format != format {
+		return ErrFormatMismatch
+	}
+
+	key, ok := v.keys[env.KeyID]
+	if !ok {
+		return ErrUnknownKey
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(env.Payload)
+	if err != nil {
+		return ErrMalformed
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(env.Signature)
+	if err != nil {
+		return ErrMalformed
+	}
+	if !ed25519.Verify(key, payload, signature) {
+		return ErrBadSignature
+	}
+
+	if err := json.Unmarshal(payload, dst); err != nil {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// ParsePublicKey reads an Ed25519 public key from PEM (PKIX) or from the
+// base64 form published by the server
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("licensefile: invalid public key: %w", err)
+		}
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return nil, errors.New("licensefile: not an Ed25519 public key")
+		}
+		return key, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, errors.New("licensefile: invalid public key")
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// ParsePrivateKey reads an Ed25519 private key from PEM (PKCS #8), as
+// written by `openssl genpkey -algorithm ed25519`
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("licensefile: private key is not PEM encoded")
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("licensefile: invalid private key: %w", err)
+	}
+	key, ok := priv.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("licensefile: not an Ed25519 private key")
+	}
+	return key, nil
+}
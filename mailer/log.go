@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"jinzmedia-atmt/config"
+)
+
+// LogMailer writes messages to .eml files and the application log instead of
+// sending them. Intended for development.
+type LogMailer struct {
+	dir string
+}
+
+// NewLogMailer creates a new log mailer
+func NewLogMailer() *LogMailer {
+	return &LogMailer{
+		dir: config.Get().Email.LogDir,
+	}
+}
+
+// Send writes the message to the log directory
+func (m *LogMailer) Send(ctx context.Context, msg *Message) error {
+	log.Printf("MAIL DEBUG: To=%s Subject=%q", msg.To, msg.Subject)
+
+	if m.dir == "" {
+		return nil
+	}
+
+	data, err := buildMIME(msg)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create mail log directory: %w", err)
+	}
+
+	recipient := strings.NewReplacer("@", "_at_", "/", "_", "\\", "_").Replace(msg.To)
+	filename := fmt.Sprintf("%s_%s.eml", time.Now().Format("20060102T150405.000000000"), recipient)
+	if err := os.WriteFile(filepath.Join(m.dir, filename), data, 0644); err != nil {
+		return fmt.Errorf("failed to write mail log file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"jinzmedia-atmt/config"
+)
+
+// Message represents a single outbound email
+type Message struct {
+	From     string
+	To       string
+	Subject  string
+	TextBody string
+	HTMLBody string
+}
+
+// Mailer delivers a message to its recipient
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// New creates the mailer selected by the email driver in config
+func New() (Mailer, error) {
+	cfg := config.Get()
+
+	switch cfg.Email.Driver {
+	case "smtp":
+		return NewSMTPMailer(), nil
+	case "log", "":
+		return NewLogMailer(), nil
+	default:
+		return nil, fmt.Errorf("unknown email driver: %s", cfg.Email.Driver)
+	}
+}
+
+// defaultFrom returns the configured sender formatted as an RFC 5322 address
+func defaultFrom() string {
+	cfg := config.Get()
+	addr := mail.Address{Name: cfg.Email.FromName, Address: cfg.Email.FromAddress}
+	return addr.String()
+}
+
+// buildMIME renders a message as a multipart/alternative MIME document
+func buildMIME(msg *Message) ([]byte, error) {
+	from := msg.From
+	if from == "" {
+		from = defaultFrom()
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	headers := []string{
+		"From: " + from,
+		"To: " + msg.To,
+		"Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"Message-ID: " + newMessageID(from),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + writer.Boundary(),
+	}
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=utf-8", msg.TextBody},
+		{"text/html; charset=utf-8", msg.HTMLBody},
+	}
+
+	for _, part := range parts {
+		if part.body == "" {
+			continue
+		}
+
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+		pw, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create mime part: %w", err)
+		}
+
+		qp := quotedprintable.NewWriter(pw)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return nil, fmt.Errorf("failed to encode mime part: %w", err)
+		}
+		if err := qp.Close(); err != nil {
+			return nil, fmt.Errorf("failed to encode mime part: %w", err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish mime message: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(strings.Join(headers, "\r\n"))
+	buf.WriteString("\r\n\r\n")
+	buf.Write(body.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+// newMessageID generates a unique Message-ID using the sender's domain
+func newMessageID(from string) string {
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if at := strings.LastIndex(addr.Address, "@"); at != -1 {
+			domain = addr.Address[at+1:]
+		}
+	}
+
+	b := make([]byte, 12)
+	rand.Read(b)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(b), domain)
+}
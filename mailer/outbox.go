@@ -0,0 +1,267 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"jinzmedia-atmt/config"
+	"jinzmedia-atmt/database"
+	"jinzmedia-atmt/models"
+)
+
+// wakeup lets Enqueue nudge the worker so new mail does not wait for the next poll
+var wakeup = make(chan struct{}, 1)
+
+// Outbox persists outgoing emails and delivers them in the background with
+// retry and exponential backoff. Handlers only ever enqueue.
+type Outbox struct {
+	outboxCollection *mongo.Collection
+	cfg              *config.Config
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewOutbox creates a new email outbox
+func NewOutbox() *Outbox {
+	return &Outbox{
+		outboxCollection: database.GetCollection("email_outbox"),
+		cfg:              config.Get(),
+	}
+}
+
+// Enqueue renders a template and stores the resulting email for delivery
+func (o *Outbox) Enqueue(ctx context.Context, userID *primitive.ObjectID, to, template, lang string, data map[string]interface{}) error {
+	rendered, err := Render(template, lang, data)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	entry := &models.EmailOutboxEntry{
+		ID:            primitive.NewObjectID(),
+		UserID:        userID,
+		To:            to,
+		Subject:       rendered.Subject,
+		TextBody:      rendered.TextBody,
+		HTMLBody:      rendered.HTMLBody,
+		Template:      template,
+		Language:      rendered.Language,
+		Status:        models.EmailStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	if _, err := o.outboxCollection.InsertOne(ctx, entry); err != nil {
+		return fmt.Errorf("failed to enqueue email: %w", err)
+	}
+
+	select {
+	case wakeup <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Start launches the background delivery worker
+func (o *Outbox) Start(m Mailer) {
+	ctx, cancel := context.WithCancel(context.Background())
+	o.cancel = cancel
+
+	interval := o.cfg.Email.Outbox.PollInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			o.processBatch(ctx, m)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-wakeup:
+			}
+		}
+	}()
+
+	log.Printf("Email outbox worker started (driver: %s)", o.cfg.Email.Driver)
+}
+
+// Stop signals the worker to exit and waits for in-flight deliveries
+func (o *Outbox) Stop() {
+	if o.cancel == nil {
+		return
+	}
+	o.cancel()
+	o.wg.Wait()
+	log.Println("Email outbox worker stopped")
+}
+
+// processBatch claims and delivers up to BatchSize due entries
+func (o *Outbox) processBatch(ctx context.Context, m Mailer) {
+	batchSize := o.cfg.Email.Outbox.BatchSize
+	if batchSize <= 0 {
+		batchSize = 20
+	}
+
+	for i := 0; i < batchSize; i++ {
+		if ctx.Err() != nil {
+			return
+		}
+
+		entry, err := o.claimNext(ctx)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				log.Printf("MAIL ERROR: Failed to claim outbox entry: %v", err)
+			}
+			return
+		}
+
+		o.deliver(ctx, m, entry)
+	}
+}
+
+// claimNext atomically locks the next due entry, including entries whose
+// previous worker died while sending
+func (o *Outbox) claimNext(ctx context.Context) (*models.EmailOutboxEntry, error) {
+	now := time.Now()
+
+	lockTimeout := o.cfg.Email.Outbox.LockTimeout
+	if lockTimeout <= 0 {
+		lockTimeout = 2 * time.Minute
+	}
+
+	filter := bson.M{
+		"$or": []bson.M{
+			{"status": models.EmailStatusPending, "next_attempt_at": bson.M{"$lte": now}},
+			{"status": models.EmailStatusSending, "locked_until": bson.M{"$lte": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":       models.EmailStatusSending,
+			"locked_until": now.Add(lockTimeout),
+			"updated_at":   now,
+		},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var entry models.EmailOutboxEntry
+	if err := o.outboxCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// deliver sends a claimed entry and records the outcome
+func (o *Outbox) deliver(ctx context.Context, m Mailer, entry *models.EmailOutboxEntry) {
+	timeout := o.cfg.Email.Outbox.LockTimeout
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	sendCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := m.Send(sendCtx, &Message{
+		To:       entry.To,
+		Subject:  entry.Subject,
+		TextBody: entry.TextBody,
+		HTMLBody: entry.HTMLBody,
+	})
+
+	// Record the result even if shutdown has started
+	recordCtx, recordCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer recordCancel()
+
+	now := time.Now()
+	if err == nil {
+		_, updateErr := o.outboxCollection.UpdateOne(recordCtx,
+			bson.M{"_id": entry.ID},
+			bson.M{
+				"$set":   bson.M{"status": models.EmailStatusSent, "sent_at": now, "updated_at": now},
+				"$inc":   bson.M{"attempts": 1},
+				"$unset": bson.M{"locked_until": "", "last_error": ""},
+			})
+		if updateErr != nil {
+			log.Printf("MAIL ERROR: Failed to mark email %s as sent: %v", entry.ID.Hex(), updateErr)
+		}
+		log.Printf("MAIL SUCCESS: Sent %s email to %s", entry.Template, entry.To)
+		return
+	}
+
+	attempts := entry.Attempts + 1
+	status := models.EmailStatusPending
+	nextAttempt := now.Add(o.backoff(attempts))
+
+	maxAttempts := o.cfg.Email.Outbox.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 8
+	}
+	if attempts >= maxAttempts {
+		status = models.EmailStatusFailed
+	}
+
+	_, updateErr := o.outboxCollection.UpdateOne(recordCtx,
+		bson.M{"_id": entry.ID},
+		bson.M{
+			"$set": bson.M{
+				"status":          status,
+				"attempts":        attempts,
+				"last_error":      err.Error(),
+				"next_attempt_at": nextAttempt,
+				"updated_at":      now,
+			},
+			"$unset": bson.M{"locked_until": ""},
+		})
+	if updateErr != nil {
+		log.Printf("MAIL ERROR: Failed to record delivery failure for email %s: %v", entry.ID.Hex(), updateErr)
+	}
+
+	if status == models.EmailStatusFailed {
+		log.Printf("MAIL ERROR: Giving up on %s email to %s after %d attempts: %v", entry.Template, entry.To, attempts, err)
+	} else {
+		log.Printf("MAIL ERROR: Failed to send %s email to %s (attempt %d, retry at %s): %v",
+			entry.Template, entry.To, attempts, nextAttempt.Format(time.RFC3339), err)
+	}
+}
+
+// backoff returns the delay before the given attempt is retried
+func (o *Outbox) backoff(attempts int) time.Duration {
+	initial := o.cfg.Email.Outbox.InitialBackoff
+	if initial <= 0 {
+		initial = 30 * time.Second
+	}
+	maxBackoff := o.cfg.Email.Outbox.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = time.Hour
+	}
+
+	delay := initial
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return delay
+}
@@ -0,0 +1,107 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+
+	"jinzmedia-atmt/config"
+)
+
+// SMTPMailer delivers messages through an SMTP server
+type SMTPMailer struct {
+	cfg *config.Config
+}
+
+// NewSMTPMailer creates a new SMTP mailer
+func NewSMTPMailer() *SMTPMailer {
+	return &SMTPMailer{
+		cfg: config.Get(),
+	}
+}
+
+// Send delivers a message through the configured SMTP server
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	smtpCfg := m.cfg.Email.SMTP
+
+	data, err := buildMIME(msg)
+	if err != nil {
+		return err
+	}
+
+	timeout := smtpCfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	addr := m.cfg.GetSMTPAddress()
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	if smtpCfg.TLSMode == "tls" {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: smtpCfg.Host}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to smtp server: %w", err)
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, smtpCfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start smtp session: %w", err)
+	}
+	defer client.Close()
+
+	if smtpCfg.TLSMode == "starttls" {
+		if err := client.StartTLS(&tls.Config{ServerName: smtpCfg.Host}); err != nil {
+			return fmt.Errorf("failed to start tls: %w", err)
+		}
+	}
+
+	if smtpCfg.Username != "" {
+		auth := smtp.PlainAuth("", smtpCfg.Username, smtpCfg.Password, smtpCfg.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("smtp authentication failed: %w", err)
+		}
+	}
+
+	from := m.cfg.Email.FromAddress
+	if msg.From != "" {
+		if addr, err := mail.ParseAddress(msg.From); err == nil {
+			from = addr.Address
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return fmt.Errorf("smtp MAIL FROM failed: %w", err)
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return fmt.Errorf("smtp RCPT TO failed: %w", err)
+	}
+
+	wc, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA failed: %w", err)
+	}
+	if _, err := wc.Write(data); err != nil {
+		wc.Close()
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := wc.Close(); err != nil {
+		return fmt.Errorf("failed to finish message: %w", err)
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,120 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"strings"
+	texttemplate "text/template"
+
+	"jinzmedia-atmt/config"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// Available email templates
+const (
+	TemplatePaymentConfirmation = "payment_confirmation"
+	TemplateReceipt             = "receipt"
+	TemplatePasswordReset       = "password_reset"
+	TemplateSecurityAlert       = "security_alert"
+	TemplateEmailVerification   = "email_verification"
+	TemplateDataExportReady     = "data_export_ready"
+)
+
+// Supported template languages
+const (
+	LanguageVietnamese = "vi"
+	LanguageEnglish    = "en"
+)
+
+// Rendered holds the subject and bodies produced from a template
+type Rendered struct {
+	Subject  string
+	TextBody string
+	HTMLBody string
+	Language string
+}
+
+// Render renders the named template in the requested language, falling back
+// to the configured default language and then Vietnamese.
+func Render(name, lang string, data map[string]interface{}) (*Rendered, error) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	if _, ok := data["AppName"]; !ok {
+		data["AppName"] = config.Get().App.Name
+	}
+
+	lang = resolveLanguage(name, lang)
+	if lang == "" {
+		return nil, fmt.Errorf("email template not found: %s", name)
+	}
+
+	base := "templates/" + lang + "/" + name
+
+	subject, err := renderText(base+".subject.txt", data)
+	if err != nil {
+		return nil, err
+	}
+
+	textBody, err := renderText(base+".txt", data)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody, err := renderHTML(base+".html", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Rendered{
+		Subject:  strings.TrimSpace(subject),
+		TextBody: textBody,
+		HTMLBody: htmlBody,
+		Language: lang,
+	}, nil
+}
+
+// resolveLanguage returns the first language that has the named template
+func resolveLanguage(name, lang string) string {
+	candidates := []string{lang, config.Get().Email.DefaultLanguage, LanguageVietnamese}
+	for _, candidate := range candidates {
+		if candidate == "" {
+			continue
+		}
+		if _, err := fs.Stat(templateFS, "templates/"+candidate+"/"+name+".subject.txt"); err == nil {
+			return candidate
+		}
+	}
+	return ""
+}
+
+func renderText(path string, data map[string]interface{}) (string, error) {
+	tmpl, err := texttemplate.ParseFS(templateFS, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse email template %s: %w", path, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render email template %s: %w", path, err)
+	}
+	return buf.String(), nil
+}
+
+func renderHTML(path string, data map[string]interface{}) (string, error) {
+	tmpl, err := htmltemplate.ParseFS(templateFS, path)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse email template %s: %w", path, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render email template %s: %w", path, err)
+	}
+	return buf.String(), nil
+}
@@ -17,8 +17,13 @@ import (
 	"jinzmedia-atmt/auth"
 	"jinzmedia-atmt/config"
 	"jinzmedia-atmt/database"
+	"jinzmedia-atmt/geoip"
 	"jinzmedia-atmt/handlers"
+	"jinzmedia-atmt/i18n"
+	"jinzmedia-atmt/mailer"
+	"jinzmedia-atmt/models"
 	"jinzmedia-atmt/services"
+	"jinzmedia-atmt/storage"
 )
 
 func main() {
@@ -29,22 +34,68 @@ func main() {
 
 	cfg := config.Get()
 
+	// Load JWT signing and verification keys
+	if err := auth.LoadKeys(); err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+	if err := services.LoadLicenseKeys(); err != nil {
+		log.Fatalf("Failed to load license signing keys: %v", err)
+	}
+	if err := services.LoadSparkleKey(); err != nil {
+		log.Fatalf("Failed to load Sparkle signing key: %v", err)
+	}
+
+	// Product file storage
+	if err := storage.Init(); err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	// GeoIP databases for download analytics
+	geoip.Init()
+
 	// Connect to database
 	if err := database.Connect(); err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer database.Disconnect()
 
+	// Connect to Redis (optional; in-memory stores are used otherwise)
+	if err := database.ConnectRedis(); err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	defer database.DisconnectRedis()
+
+	// Start email outbox worker
+	mailSender, err := mailer.New()
+	if err != nil {
+		log.Fatalf("Failed to initialize mailer: %v", err)
+	}
+	emailOutbox := mailer.NewOutbox()
+	emailOutbox.Start(mailSender)
+
+	// Start personal data export worker
+	exportService := services.NewDataExportService()
+	exportService.Start()
+
 	// Initialize services
 	paymentService := services.NewPaymentService()
+	licenseService := services.NewLicenseService()
+	downloadService := services.NewDownloadService()
+	// Sign macOS builds stored before Sparkle signing was configured
+	go downloadService.SignSparkleBuilds(context.Background())
+	// Checksums of legacy product files, which are not recorded
+	go downloadService.HashLegacyFiles(context.Background())
 	authService := auth.NewAuthService()
 
 	// Initialize handlers
 	authHandlers := handlers.NewAuthHandlers()
 	paymentHandlers := handlers.NewPaymentHandler(paymentService)
-	downloadHandlers := handlers.NewDownloadHandlers()
+	downloadHandlers := handlers.NewDownloadHandlers(downloadService)
+	releaseHandlers := handlers.NewReleaseHandlers(services.NewReleaseService(), services.NewBuildUploadService())
 	webhookHandlers := handlers.NewWebhookHandler(paymentService)
-	adminHandlers := handlers.NewAdminHandlers()
+	adminHandlers := handlers.NewAdminHandlers(licenseService, downloadService)
+	exportHandlers := handlers.NewDataExportHandlers(exportService)
+	licenseHandlers := handlers.NewLicenseHandlers(licenseService)
 
 	// Create router
 	r := chi.NewRouter()
@@ -53,7 +104,13 @@ func main() {
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(auth.ExposeRequestID)
+	r.Use(i18n.Middleware)
+	realIP, err := auth.RealIP(cfg.Server.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid server.trusted_proxies: %v", err)
+	}
+	r.Use(realIP)
 
 	// CORS middleware
 	r.Use(cors.Handler(cors.Options{
@@ -79,21 +136,91 @@ func main() {
 		r.Post("/auth/register", authHandlers.Register)
 		r.Post("/auth/login", authHandlers.Login)
 		r.Post("/auth/refresh", authHandlers.RefreshToken)
+		r.Post("/auth/2fa/verify", authHandlers.VerifyTwoFactorLogin)
+		r.Get("/auth/oidc/{provider}/login", authHandlers.OIDCLogin)
+		r.Get("/auth/oidc/{provider}/callback", authHandlers.OIDCCallback)
+		r.Post("/auth/email/verify", authHandlers.VerifyEmailChange)
+		r.Get("/auth/data-export/{id}/download", exportHandlers.DownloadExport)
+		r.Get("/licenses/revocations", licenseHandlers.GetRevocationList)
+		r.Post("/licenses/heartbeat", licenseHandlers.Heartbeat)
+		r.Get("/download/signed/{product_name}/{platform}", downloadHandlers.DownloadSignedFile)
+		r.Head("/download/signed/{product_name}/{platform}", downloadHandlers.DownloadSignedFile)
+		r.Get("/releases/{product_name}/{platform}", releaseHandlers.ListReleases)
 
 		// Protected routes (authentication required)
 		r.Group(func(r chi.Router) {
 			r.Use(auth.AuthMiddleware(authService))
 
 			// User routes
-			r.Get("/auth/profile", authHandlers.GetProfile)
+			r.With(auth.RequireScope(models.APIKeyScopeProfileRead)).Get("/auth/profile", authHandlers.GetProfile)
+			r.With(auth.RequireSession()).Patch("/auth/profile", authHandlers.UpdateProfile)
+
 			r.Post("/auth/logout", authHandlers.Logout)
 
+			// Account security (interactive sessions only; not API keys or impersonation)
+			r.Group(func(r chi.Router) {
+				r.Use(auth.RequireSession())
+				r.Use(auth.DenyImpersonation(authService))
+
+				r.Post("/auth/password", authHandlers.ChangePassword)
+				r.Post("/auth/email", authHandlers.RequestEmailChange)
+				r.Delete("/auth/account", authHandlers.DeleteAccount)
+
+				// Personal data export
+				r.Post("/auth/data-export", exportHandlers.RequestExport)
+				r.Get("/auth/data-export", exportHandlers.GetExportStatus)
+
+				// Two-factor authentication
+				r.Post("/auth/2fa/setup", authHandlers.SetupTwoFactor)
+				r.Post("/auth/2fa/enable", authHandlers.EnableTwoFactor)
+				r.Post("/auth/2fa/disable", authHandlers.DisableTwoFactor)
+				r.Post("/auth/2fa/recovery-codes", authHandlers.RegenerateRecoveryCodes)
+
+				// External sign-in providers
+				r.Post("/auth/oidc/{provider}/link", authHandlers.LinkProvider)
+				r.Delete("/auth/oidc/{provider}/link", authHandlers.UnlinkProvider)
+
+				// Personal API keys
+				r.Get("/auth/api-keys", authHandlers.ListAPIKeys)
+				r.Post("/auth/api-keys", authHandlers.CreateAPIKey)
+				r.Delete("/auth/api-keys/{id}", authHandlers.RevokeAPIKey)
+			})
+
 			// Payment routes (authenticated users)
-			r.Post("/payment/initiate", paymentHandlers.InitiatePayment)
-			r.Post("/payment/refresh", paymentHandlers.RefreshPayment)
+			r.Group(func(r chi.Router) {
+				r.Use(auth.RequireScope(models.APIKeyScopePayments))
+				r.Use(auth.DenyImpersonation(authService))
+
+				r.Post("/payment/initiate", paymentHandlers.InitiatePayment)
+				r.Post("/payment/refresh", paymentHandlers.RefreshPayment)
+			})
+
+			// License activation (desktop apps and the account page)
+			r.Group(func(r chi.Router) {
+				r.Use(auth.RequireScope(models.APIKeyScopeLicenses))
+
+				r.Get("/licenses", licenseHandlers.GetLicense)
+
+				r.Group(func(r chi.Router) {
+					r.Use(auth.DenyImpersonation(authService))
+
+					r.Post("/licenses/activate", licenseHandlers.Activate)
+					r.Post("/licenses/deactivate", licenseHandlers.Deactivate)
+					r.Post("/licenses/transfer", licenseHandlers.Transfer)
+					r.Post("/licenses/file", licenseHandlers.IssueLicenseFile)
+					r.Delete("/licenses/activations/{id}", licenseHandlers.DeactivateDevice)
+				})
+			})
 
 			// Download routes (authenticated users)  
-			r.Get("/download/{product_name}/{platform}", downloadHandlers.DownloadProduct)
+			r.With(auth.RequireScope(models.APIKeyScopeDownloads)).Get("/products", downloadHandlers.ListProducts)
+			r.With(auth.RequireScope(models.APIKeyScopeDownloads)).Get("/download/{product_name}/{platform}", downloadHandlers.DownloadProduct)
+			r.With(auth.RequireScope(models.APIKeyScopeDownloads)).Head("/download/{product_name}/{platform}", downloadHandlers.DownloadProduct)
+			r.With(auth.RequireScope(models.APIKeyScopeDownloads)).Post("/download/{product_name}/{platform}/link", downloadHandlers.CreateDownloadLink)
+
+			// Desktop app updates
+			r.With(auth.RequireScope(models.APIKeyScopeDownloads)).Get("/updates/{product_name}/{platform}", downloadHandlers.CheckForUpdate)
+			r.With(auth.RequireScope(models.APIKeyScopeDownloads)).Get("/updates/{product_name}/macos/appcast.xml", downloadHandlers.Appcast)
 
 			// Admin routes
 			r.Group(func(r chi.Router) {
@@ -115,6 +242,7 @@ func main() {
 		r.Route("/admin", func(r chi.Router) {
 			// Admin login
 			r.Post("/login", adminHandlers.Login)
+			r.Post("/login/verify", adminHandlers.VerifyLogin)
 
 			// Dashboard
 			r.Get("/dashboard/stats", adminHandlers.GetDashboardStats)
@@ -132,9 +260,58 @@ func main() {
 			r.Get("/workflows", adminHandlers.GetWorkflows)
 			r.Post("/workflows", adminHandlers.CreateWorkflow)
 			r.Patch("/workflows/{id}", adminHandlers.UpdateWorkflow)
+
+			// Authenticated admin actions
+			r.Group(func(r chi.Router) {
+				r.Use(auth.AuthMiddleware(authService))
+				r.Use(auth.RequireAdmin())
+
+				// Login lockout management
+				r.Post("/users/{id}/unlock", adminHandlers.UnlockUser)
+				r.Post("/security/unlock-ip", adminHandlers.UnlockIP)
+
+				// API key management
+				r.Get("/users/{id}/api-keys", adminHandlers.ListUserAPIKeys)
+				r.Delete("/users/{id}/api-keys/{keyId}", adminHandlers.RevokeUserAPIKey)
+
+				// License devices
+				r.Get("/users/{id}/devices", adminHandlers.ListUserDevices)
+				r.Delete("/users/{id}/devices/{deviceId}", adminHandlers.RevokeUserDevice)
+				r.Get("/licenses/flagged", adminHandlers.ListFlaggedDevices)
+
+				// Download analytics
+				r.Get("/analytics/downloads/stats", adminHandlers.GetDownloadStats)
+				r.Get("/analytics/conversion/stats", adminHandlers.GetConversionStats)
+
+				// Download abuse and leak tracing
+				r.Get("/downloads/abuse", adminHandlers.DownloadAbuseReport)
+				r.Post("/watermarks/trace", adminHandlers.TraceWatermark)
+
+				// Product releases
+				r.Get("/releases", releaseHandlers.AdminListReleases)
+				r.Post("/releases", releaseHandlers.AdminCreateRelease)
+				r.Patch("/releases/{id}", releaseHandlers.AdminUpdateRelease)
+
+				// Build uploads
+				r.Post("/releases/upload", releaseHandlers.AdminUploadBuild)
+				r.Post("/uploads", releaseHandlers.AdminStartUpload)
+				r.Get("/uploads/{id}", releaseHandlers.AdminGetUpload)
+				r.Patch("/uploads/{id}", releaseHandlers.AdminUploadChunk)
+				r.Post("/uploads/{id}/complete", releaseHandlers.AdminCompleteUpload)
+				r.Delete("/uploads/{id}", releaseHandlers.AdminCancelUpload)
+
+				// Support impersonation (super admin only)
+				r.With(auth.RequireSuper()).Post("/users/{id}/impersonate", adminHandlers.ImpersonateUser)
+			})
 		})
 	})
 
+	// Public keys for verifying ATMT tokens in other services
+	r.Get("/.well-known/jwks.json", authHandlers.JWKS)
+
+	// Public keys for verifying license files offline
+	r.Get("/.well-known/license-keys.json", licenseHandlers.PublicKeys)
+
 	// Root endpoint
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		response := fmt.Sprintf("Hello from %s v%s!", cfg.App.Name, cfg.App.Version)
@@ -200,5 +377,8 @@ func main() {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
+	exportService.Stop()
+	emailOutbox.Stop()
+
 	log.Println("Server exited")
 }
@@ -142,6 +142,85 @@ type DailyCost struct {
 	Amount int64  `json:"amount" bson:"amount"`
 }
 
+type DownloadAnalytics struct {
+	Overall        DownloadOverall     `json:"overall"`
+	Period         DownloadPeriod      `json:"period"`
+	DailyDownloads []DailyDownload     `json:"dailyDownloads"`
+	ByProduct      []DownloadBreakdown `json:"byProduct"`
+	ByPlatform     []DownloadBreakdown `json:"byPlatform"`
+	ByVersion      []VersionBreakdown  `json:"byVersion"`
+	ByCountry      []DownloadBreakdown `json:"byCountry"`
+	ByASN          []ASNBreakdown      `json:"byAsn"`
+}
+
+type DownloadOverall struct {
+	TotalDownloads    int64 `json:"totalDownloads" bson:"count"`
+	UniqueDownloaders int64 `json:"uniqueDownloaders" bson:"unique"`
+}
+
+type DownloadPeriod struct {
+	TotalDownloads       int64 `json:"totalDownloads"`
+	UniqueDownloaders    int64 `json:"uniqueDownloaders"`
+	NewDownloaders       int64 `json:"newDownloaders"`       // First download ever within the period
+	ReturningDownloaders int64 `json:"returningDownloaders"` // Had downloaded before the period
+	RepeatDownloads      int64 `json:"repeatDownloads"`      // Downloads beyond each user's first in the period
+}
+
+type DailyDownload struct {
+	ID     string `json:"_id" bson:"_id"`
+	Count  int64  `json:"count" bson:"count"`
+	Unique int64  `json:"unique" bson:"unique"`
+}
+
+// DownloadBreakdown counts downloads and distinct users for one product,
+// platform or country ("unknown" when not located)
+type DownloadBreakdown struct {
+	ID     string `json:"_id" bson:"_id"`
+	Count  int64  `json:"count" bson:"count"`
+	Unique int64  `json:"unique" bson:"unique"`
+}
+
+type VersionBreakdown struct {
+	Product  string `json:"product" bson:"product"`
+	Platform string `json:"platform" bson:"platform"`
+	Version  string `json:"version" bson:"version"` // Empty for the legacy unversioned file
+	Count    int64  `json:"count" bson:"count"`
+	Unique   int64  `json:"unique" bson:"unique"`
+}
+
+// ASNBreakdown counts downloads from one network; ASN 0 when not located
+type ASNBreakdown struct {
+	ASN          uint32 `json:"asn" bson:"asn"`
+	Organization string `json:"organization" bson:"organization"`
+	Count        int64  `json:"count" bson:"count"`
+	Unique       int64  `json:"unique" bson:"unique"`
+}
+
+// ConversionAnalytics follows the users who registered in the period from
+// registration to their first payment and first download
+type ConversionAnalytics struct {
+	Period          ConversionPeriod  `json:"period"`
+	DailyConversion []DailyConversion `json:"dailyConversion"`
+}
+
+type ConversionPeriod struct {
+	Registered              int64   `json:"registered"`
+	Paid                    int64   `json:"paid"`
+	Downloaded              int64   `json:"downloaded"`
+	PaymentRate             float64 `json:"paymentRate"`             // Paid / registered
+	DownloadRate            float64 `json:"downloadRate"`            // Downloaded / paid
+	AvgHoursToPayment       float64 `json:"avgHoursToPayment"`       // From registration
+	AvgHoursToFirstDownload float64 `json:"avgHoursToFirstDownload"` // From registration
+}
+
+// DailyConversion groups users by registration date
+type DailyConversion struct {
+	ID         string `json:"_id" bson:"_id"`
+	Registered int64  `json:"registered" bson:"registered"`
+	Paid       int64  `json:"paid" bson:"paid"`
+	Downloaded int64  `json:"downloaded" bson:"downloaded"`
+}
+
 // Job Models
 type Job struct {
 	ID         primitive.ObjectID `json:"_id" bson:"_id"`
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// API key scopes limit what a key can be used for
+const (
+	APIKeyScopeProfileRead = "profile:read" // GET /auth/profile
+	APIKeyScopePayments    = "payments"     // Initiate and refresh payments
+	APIKeyScopeDownloads   = "downloads"    // Download products
+	APIKeyScopeLicenses    = "licenses"     // Activate and manage license devices
+)
+
+// APIKeyScopes lists every scope a key may be granted
+var APIKeyScopes = []string{APIKeyScopeProfileRead, APIKeyScopePayments, APIKeyScopeDownloads, APIKeyScopeLicenses}
+
+// APIKey represents a personal API key. Only a hash of the secret is stored.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"` // First characters of the key, for identification
+	KeyHash    string             `bson:"key_hash" json:"-"`    // SHA-256 of the full key
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	ExpiresAt  *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	LastUsedIP string             `bson:"last_used_ip,omitempty" json:"last_used_ip,omitempty"`
+	RevokedAt  *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// HasScope reports whether the key was granted scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIKeyRequest represents the request to create an API key
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" validate:"required,max=100"`
+	Scopes        []string `json:"scopes" validate:"required"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"min=0"` // 0 uses the configured default lifetime
+}
+
+// CreateAPIKeyResponse contains the new key; the secret is only shown once
+type CreateAPIKeyResponse struct {
+	APIKey *APIKey `json:"api_key"`
+	Key    string  `json:"key"`
+}
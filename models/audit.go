@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audit trail actions
+const (
+	AuditImpersonationStart   = "impersonation.start"
+	AuditImpersonationRequest = "impersonation.request"
+	AuditImpersonationBlocked = "impersonation.blocked"
+)
+
+// AuditEntry records a security-relevant action in the audit trail
+type AuditEntry struct {
+	ID              primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Action          string                 `bson:"action" json:"action"`
+	ActorID         primitive.ObjectID     `bson:"actor_id" json:"actor_id"` // Who really performed the action
+	ActorEmail      string                 `bson:"actor_email" json:"actor_email"`
+	SubjectUserID   *primitive.ObjectID    `bson:"subject_user_id,omitempty" json:"subject_user_id,omitempty"` // User acted on or impersonated
+	SubjectEmail    string                 `bson:"subject_email,omitempty" json:"subject_email,omitempty"`
+	ImpersonationID string                 `bson:"impersonation_id,omitempty" json:"impersonation_id,omitempty"`
+	Method          string                 `bson:"method,omitempty" json:"method,omitempty"`
+	Path            string                 `bson:"path,omitempty" json:"path,omitempty"`
+	StatusCode      int                    `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	IP              string                 `bson:"ip,omitempty" json:"ip,omitempty"`
+	RequestID       string                 `bson:"request_id,omitempty" json:"request_id,omitempty"`
+	Details         map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+	CreatedAt       time.Time              `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EmailStatus represents the delivery state of an outbox entry
+type EmailStatus string
+
+const (
+	EmailStatusPending EmailStatus = "pending" // Waiting to be sent (or retried)
+	EmailStatusSending EmailStatus = "sending" // Claimed by a worker
+	EmailStatusSent    EmailStatus = "sent"    // Delivered to the mail server
+	EmailStatusFailed  EmailStatus = "failed"  // Gave up after max attempts
+)
+
+// EmailOutboxEntry represents a rendered email waiting in the persistent outbox
+type EmailOutboxEntry struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID        *primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	To            string              `bson:"to" json:"to"`
+	Subject       string              `bson:"subject" json:"subject"`
+	TextBody      string              `bson:"text_body" json:"text_body"`
+	HTMLBody      string              `bson:"html_body" json:"html_body"`
+	Template      string              `bson:"template" json:"template"`
+	Language      string              `bson:"language" json:"language"`
+	Status        EmailStatus         `bson:"status" json:"status"`
+	Attempts      int                 `bson:"attempts" json:"attempts"`
+	LastError     string              `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	NextAttemptAt time.Time           `bson:"next_attempt_at" json:"next_attempt_at"`
+	LockedUntil   *time.Time          `bson:"locked_until,omitempty" json:"locked_until,omitempty"`
+	SentAt        *time.Time          `bson:"sent_at,omitempty" json:"sent_at,omitempty"`
+	CreatedAt     time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time           `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,121 @@
+package models
+
+import "net/http"
+
+// ErrorCode is a stable, machine-readable error identifier returned in
+// ErrorResponse. Clients should branch and localize on the code, never on the
+// message. Codes are never renamed once published.
+type ErrorCode string
+
+// Generic codes, used when no more specific code applies
+const (
+	ErrCodeBadRequest         ErrorCode = "BAD_REQUEST"
+	ErrCodeValidationFailed   ErrorCode = "VALIDATION_FAILED"
+	ErrCodeUnauthorized       ErrorCode = "UNAUTHORIZED"
+	ErrCodeForbidden          ErrorCode = "FORBIDDEN"
+	ErrCodeNotFound           ErrorCode = "NOT_FOUND"
+	ErrCodeConflict           ErrorCode = "CONFLICT"
+	ErrCodeGone               ErrorCode = "GONE"
+	ErrCodePayloadTooLarge    ErrorCode = "PAYLOAD_TOO_LARGE"
+	ErrCodeRateLimited        ErrorCode = "RATE_LIMITED"
+	ErrCodeInternal           ErrorCode = "INTERNAL_ERROR"
+	ErrCodeServiceUnavailable ErrorCode = "SERVICE_UNAVAILABLE"
+)
+
+// Authentication and account codes
+const (
+	ErrCodeAuthRequired             ErrorCode = "AUTH_REQUIRED"
+	ErrCodeTokenInvalid             ErrorCode = "TOKEN_INVALID"
+	ErrCodeInvalidCredentials       ErrorCode = "INVALID_CREDENTIALS"
+	ErrCodeAccountDisabled          ErrorCode = "ACCOUNT_DISABLED"
+	ErrCodeLoginLocked              ErrorCode = "LOGIN_LOCKED"
+	ErrCodeInsufficientPermissions  ErrorCode = "INSUFFICIENT_PERMISSIONS"
+	ErrCodeUserNotFound             ErrorCode = "USER_NOT_FOUND"
+	ErrCodeUserExists               ErrorCode = "USER_EXISTS"
+	ErrCodeEmailReserved            ErrorCode = "EMAIL_RESERVED"
+	ErrCodeEmailUnchanged           ErrorCode = "EMAIL_UNCHANGED"
+	ErrCodeEmailVerificationInvalid ErrorCode = "EMAIL_VERIFICATION_INVALID"
+	ErrCodeDeleteNotConfirmed       ErrorCode = "DELETE_NOT_CONFIRMED"
+	ErrCodeMFARequired              ErrorCode = "MFA_REQUIRED"
+	ErrCodeMFACodeInvalid           ErrorCode = "MFA_CODE_INVALID"
+	ErrCodeMFANotEnabled            ErrorCode = "MFA_NOT_ENABLED"
+	ErrCodeMFAAlreadyEnabled        ErrorCode = "MFA_ALREADY_ENABLED"
+	ErrCodeMFASetupRequired         ErrorCode = "MFA_SETUP_REQUIRED"
+	ErrCodeAPIKeyInvalid            ErrorCode = "API_KEY_INVALID"
+	ErrCodeAPIKeyNotFound           ErrorCode = "API_KEY_NOT_FOUND"
+	ErrCodeAPIKeyLimit              ErrorCode = "API_KEY_LIMIT"
+	ErrCodeAPIKeyScope              ErrorCode = "API_KEY_SCOPE"
+	ErrCodeAPIKeyNotAllowed         ErrorCode = "API_KEY_NOT_ALLOWED"
+	ErrCodeImpersonationNotAllowed  ErrorCode = "IMPERSONATION_NOT_ALLOWED"
+	ErrCodeCannotImpersonateAdmin   ErrorCode = "CANNOT_IMPERSONATE_ADMIN"
+	ErrCodeOIDCProviderNotFound     ErrorCode = "OIDC_PROVIDER_NOT_FOUND"
+	ErrCodeOIDCStateInvalid         ErrorCode = "OIDC_STATE_INVALID"
+	ErrCodeOIDCEmailNotVerified     ErrorCode = "OIDC_EMAIL_NOT_VERIFIED"
+	ErrCodeIdentityLinked           ErrorCode = "IDENTITY_LINKED"
+	ErrCodeIdentityNotLinked        ErrorCode = "IDENTITY_NOT_LINKED"
+	ErrCodeLastSignInMethod         ErrorCode = "LAST_SIGN_IN_METHOD"
+)
+
+// Payment, download, export and license codes
+const (
+	ErrCodeUserBanned             ErrorCode = "USER_BANNED"
+	ErrCodeAlreadyOwned           ErrorCode = "ALREADY_OWNED"
+	ErrCodePaymentSessionNotFound ErrorCode = "PAYMENT_SESSION_NOT_FOUND"
+	ErrCodePaymentSessionExpired  ErrorCode = "PAYMENT_SESSION_EXPIRED"
+	ErrCodePaymentAmountMismatch  ErrorCode = "PAYMENT_AMOUNT_MISMATCH"
+	ErrCodePaymentCodeMissing     ErrorCode = "PAYMENT_CODE_MISSING"
+	ErrCodePaymentCodeUnknown     ErrorCode = "PAYMENT_CODE_UNKNOWN"
+	ErrCodeNotOwned               ErrorCode = "NOT_OWNED"
+	ErrCodeSerialMismatch         ErrorCode = "SERIAL_MISMATCH"
+	ErrCodeInvalidProduct         ErrorCode = "INVALID_PRODUCT"
+	ErrCodeInvalidPlatform        ErrorCode = "INVALID_PLATFORM"
+	ErrCodeInvalidArch            ErrorCode = "INVALID_ARCH"
+	ErrCodeFileNotFound           ErrorCode = "FILE_NOT_FOUND"
+	ErrCodeDownloadLinkInvalid    ErrorCode = "DOWNLOAD_LINK_INVALID"
+	ErrCodeDownloadQuotaExceeded  ErrorCode = "DOWNLOAD_QUOTA_EXCEEDED"
+	ErrCodeDownloadIPLimit        ErrorCode = "DOWNLOAD_IP_LIMIT"
+	ErrCodeReleaseNotFound        ErrorCode = "RELEASE_NOT_FOUND"
+	ErrCodeReleaseExists          ErrorCode = "RELEASE_EXISTS"
+	ErrCodeUploadNotFound         ErrorCode = "UPLOAD_NOT_FOUND"
+	ErrCodeUploadOffsetMismatch   ErrorCode = "UPLOAD_OFFSET_MISMATCH"
+	ErrCodeUploadIncomplete       ErrorCode = "UPLOAD_INCOMPLETE"
+	ErrCodeChecksumMismatch       ErrorCode = "CHECKSUM_MISMATCH"
+	ErrCodeInvalidExecutable      ErrorCode = "INVALID_EXECUTABLE"
+	ErrCodeExportTooSoon          ErrorCode = "EXPORT_TOO_SOON"
+	ErrCodeExportNotFound         ErrorCode = "EXPORT_NOT_FOUND"
+	ErrCodeExportLinkInvalid      ErrorCode = "EXPORT_LINK_INVALID"
+	ErrCodeLicenseDeviceLimit     ErrorCode = "LICENSE_DEVICE_LIMIT"
+	ErrCodeLicenseDeviceActive    ErrorCode = "LICENSE_DEVICE_ACTIVE"
+	ErrCodeLicenseTransferLimit   ErrorCode = "LICENSE_TRANSFER_LIMIT"
+	ErrCodeActivationNotFound     ErrorCode = "ACTIVATION_NOT_FOUND"
+	ErrCodeJobNotFound            ErrorCode = "JOB_NOT_FOUND"
+	ErrCodeWorkflowNotFound       ErrorCode = "WORKFLOW_NOT_FOUND"
+)
+
+// ErrorCodeForStatus returns the generic code for an HTTP status
+func ErrorCodeForStatus(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrCodeBadRequest
+	case http.StatusUnauthorized:
+		return ErrCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrCodeForbidden
+	case http.StatusNotFound:
+		return ErrCodeNotFound
+	case http.StatusConflict:
+		return ErrCodeConflict
+	case http.StatusGone:
+		return ErrCodeGone
+	case http.StatusRequestEntityTooLarge:
+		return ErrCodePayloadTooLarge
+	case http.StatusTooManyRequests:
+		return ErrCodeRateLimited
+	case http.StatusServiceUnavailable:
+		return ErrCodeServiceUnavailable
+	}
+	if status >= 500 {
+		return ErrCodeInternal
+	}
+	return ErrCodeBadRequest
+}
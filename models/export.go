@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DataExportStatus represents the state of a personal data export
+type DataExportStatus string
+
+const (
+	DataExportStatusPending    DataExportStatus = "pending"    // Waiting for the worker
+	DataExportStatusProcessing DataExportStatus = "processing" // Claimed by the worker
+	DataExportStatusReady      DataExportStatus = "ready"      // ZIP can be downloaded until ExpiresAt
+	DataExportStatusFailed     DataExportStatus = "failed"     // Gave up after max attempts
+	DataExportStatusExpired    DataExportStatus = "expired"    // ZIP deleted after the link expired
+)
+
+// DataExport is a user's request for a copy of their personal data
+type DataExport struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID      primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Status      DataExportStatus   `bson:"status" json:"status"`
+	Attempts    int                `bson:"attempts" json:"-"`
+	LastError   string             `bson:"last_error,omitempty" json:"-"`
+	FilePath    string             `bson:"file_path,omitempty" json:"-"`
+	FileSize    int64              `bson:"file_size,omitempty" json:"file_size,omitempty"`
+	TokenHash   string             `bson:"token_hash,omitempty" json:"-"` // SHA-256 of the download link token
+	LockedUntil *time.Time         `bson:"locked_until,omitempty" json:"-"`
+	ReadyAt     *time.Time         `bson:"ready_at,omitempty" json:"ready_at,omitempty"`
+	ExpiresAt   *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LicenseActivationStatus represents whether a device holds a seat of a license
+type LicenseActivationStatus string
+
+const (
+	LicenseActivationActive      LicenseActivationStatus = "active"
+	LicenseActivationDeactivated LicenseActivationStatus = "deactivated"
+)
+
+// Reasons a device was deactivated
+const (
+	DeactivationReasonDevice         = "device"   // The app released its own seat
+	DeactivationReasonUser           = "user"     // The owner removed the device from their account
+	DeactivationReasonTransfer       = "transfer" // The seat was moved to another device
+	DeactivationReasonAdmin          = "admin"    // Revoked by support
+	DeactivationReasonAccountDeleted = "account_deleted"
+)
+
+// LicenseActivation binds a user's license (their serial number) to one
+// device. Only a hash of the hardware fingerprint is stored.
+type LicenseActivation struct {
+	ID                 primitive.ObjectID      `bson:"_id,omitempty" json:"id"`
+	UserID             primitive.ObjectID      `bson:"user_id" json:"user_id"`
+	SerialNumber       string                  `bson:"serial_number" json:"serial_number"`
+	FingerprintHash    string                  `bson:"fingerprint_hash" json:"-"` // SHA-256 of the hardware fingerprint
+	SecretHash         string                  `bson:"secret_hash,omitempty" json:"-"`
+	Secret             string                  `bson:"-" json:"activation_secret,omitempty"` // Returned when the device activates; proves it in heartbeats
+	DeviceName         string                  `bson:"device_name,omitempty" json:"device_name,omitempty"`
+	Platform           Platform                `bson:"platform" json:"platform"`
+	AppVersion         string                  `bson:"app_version,omitempty" json:"app_version,omitempty"`
+	Status             LicenseActivationStatus `bson:"status" json:"status"`
+	IPAddress          string                  `bson:"ip_address" json:"ip_address"`
+	ActivatedAt        time.Time               `bson:"activated_at" json:"activated_at"`
+	LastSeenAt         time.Time               `bson:"last_seen_at" json:"last_seen_at"`
+	DeactivatedAt      *time.Time              `bson:"deactivated_at,omitempty" json:"deactivated_at,omitempty"`
+	DeactivationReason string                  `bson:"deactivation_reason,omitempty" json:"deactivation_reason,omitempty"`
+
+	// Heartbeat tracking
+	RecentIPs []DeviceIP       `bson:"recent_ips,omitempty" json:"recent_ips,omitempty"` // Addresses seen within license.anomaly_window
+	Anomalies []LicenseAnomaly `bson:"anomalies,omitempty" json:"anomalies,omitempty"`   // Latest entries, oldest first
+	FlaggedAt *time.Time       `bson:"flagged_at,omitempty" json:"flagged_at,omitempty"` // Last time an anomaly was detected
+}
+
+// DeviceIP is an address a device sent heartbeats from
+type DeviceIP struct {
+	IP         string    `bson:"ip" json:"ip"`
+	LastSeenAt time.Time `bson:"last_seen_at" json:"last_seen_at"`
+}
+
+// Kinds of suspicious license use
+const (
+	AnomalyManyIPs             = "many_ips"             // One activation reporting from many addresses
+	AnomalyFingerprintMismatch = "fingerprint_mismatch" // A heartbeat for the activation from another device
+)
+
+// LicenseAnomaly records suspicious use of an activation, e.g. sharing
+type LicenseAnomaly struct {
+	Kind       string    `bson:"kind" json:"kind"`
+	Detail     string    `bson:"detail" json:"detail"`
+	DetectedAt time.Time `bson:"detected_at" json:"detected_at"`
+}
+
+// LicenseState is the verdict returned to a heartbeat
+type LicenseState string
+
+const (
+	LicenseStateValid       LicenseState = "valid"
+	LicenseStateRevoked     LicenseState = "revoked"      // Device deactivated or account deleted
+	LicenseStateBanned      LicenseState = "banned"       // Account banned
+	LicenseStateNotOwned    LicenseState = "not_owned"    // Purchase refunded or withdrawn
+	LicenseStateWrongDevice LicenseState = "wrong_device" // Fingerprint does not match the activation
+)
+
+// ActivateLicenseRequest represents a desktop app claiming a seat for its device
+type ActivateLicenseRequest struct {
+	SerialNumber string   `json:"serial_number" validate:"required,max=100"`
+	Fingerprint  string   `json:"fingerprint" validate:"required,min=16,max=256"`
+	DeviceName   string   `json:"device_name" validate:"max=100"`
+	Platform     Platform `json:"platform" validate:"required,oneof=windows macos linux"`
+	AppVersion   string   `json:"app_version" validate:"max=50"`
+}
+
+// DeactivateLicenseRequest represents a desktop app releasing its seat
+type DeactivateLicenseRequest struct {
+	SerialNumber string `json:"serial_number" validate:"required,max=100"`
+	Fingerprint  string `json:"fingerprint" validate:"required,min=16,max=256"`
+}
+
+// LicenseFileRequest asks for a signed license file for an activated device
+type LicenseFileRequest struct {
+	SerialNumber string `json:"serial_number" validate:"required,max=100"`
+	Fingerprint  string `json:"fingerprint" validate:"required,min=16,max=256"`
+}
+
+// TransferLicenseRequest moves the seat of an existing activation to a new device
+type TransferLicenseRequest struct {
+	ActivationID string                 `json:"activation_id" validate:"required,len=24"`
+	Device       ActivateLicenseRequest `json:"device"`
+}
+
+// LicenseHeartbeatRequest is sent periodically by a running app
+type LicenseHeartbeatRequest struct {
+	ActivationID     string `json:"activation_id" validate:"required,len=24"`
+	ActivationSecret string `json:"activation_secret" validate:"required,max=128"`
+	Fingerprint      string `json:"fingerprint" validate:"required,min=16,max=256"`
+	AppVersion       string `json:"app_version" validate:"max=50"`
+}
+
+// LicenseHeartbeatResponse tells the app whether it may keep running
+type LicenseHeartbeatResponse struct {
+	Valid            bool         `json:"valid"`
+	State            LicenseState `json:"state"`
+	NextHeartbeatSec int          `json:"next_heartbeat_seconds"`
+}
+
+// LicenseStatusResponse summarizes a license and the devices holding its seats
+type LicenseStatusResponse struct {
+	SerialNumber       string               `json:"serial_number"`
+	MaxDevices         int                  `json:"max_devices"`
+	ActiveDevices      int                  `json:"active_devices"`
+	TransfersRemaining int                  `json:"transfers_remaining"`
+	Activations        []*LicenseActivation `json:"activations"`
+}
+
+// LicensePublicKey is an Ed25519 key that verifies license files
+type LicensePublicKey struct {
+	KeyID     string `json:"key_id"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"` // base64 of the raw 32-byte key
+}
+
+// LicenseKeysResponse is the document served at /.well-known/license-keys.json
+type LicenseKeysResponse struct {
+	ActiveKeyID string             `json:"active_key_id"`
+	Keys        []LicensePublicKey `json:"keys"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OIDCIntent is what a pending OpenID Connect flow was started for
+type OIDCIntent string
+
+const (
+	OIDCIntentLogin OIDCIntent = "login" // Sign in, creating the account if needed
+	OIDCIntentLink  OIDCIntent = "link"  // Attach the provider to a signed-in account
+)
+
+// FederatedIdentity links a user to an account at an external provider
+type FederatedIdentity struct {
+	Provider string    `bson:"provider" json:"provider"`
+	Subject  string    `bson:"subject" json:"-"` // Provider's stable user id ("sub" claim)
+	Email    string    `bson:"email" json:"email"`
+	LinkedAt time.Time `bson:"linked_at" json:"linked_at"`
+}
+
+// OIDCState is a pending authorization request, consumed once by the callback
+type OIDCState struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	State        string              `bson:"state" json:"state"`
+	Provider     string              `bson:"provider" json:"provider"`
+	Intent       OIDCIntent          `bson:"intent" json:"intent"`
+	UserID       *primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"` // Set for link intent
+	Nonce        string              `bson:"nonce" json:"-"`
+	CodeVerifier string              `bson:"code_verifier" json:"-"` // PKCE verifier
+	BrowserHash  string              `bson:"browser_hash" json:"-"`  // SHA-256 of the key in the browser's cookie
+	ExpiresAt    time.Time           `bson:"expires_at" json:"expires_at"`
+	CreatedAt    time.Time           `bson:"created_at" json:"created_at"`
+}
+
+// OIDCAuthorizationResponse contains the provider URL to send the browser to
+type OIDCAuthorizationResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
@@ -0,0 +1,110 @@
+package models
+
+// Platform is an operating system products are built for
+type Platform string
+
+const (
+	PlatformWindows Platform = "windows"
+	PlatformMacOS   Platform = "macos"
+	PlatformLinux   Platform = "linux"
+)
+
+// PlatformAuto asks the server to detect the client's platform and
+// architecture from the request
+const PlatformAuto = "auto"
+
+// Arch is the CPU architecture of a build or client
+type Arch string
+
+const (
+	ArchAMD64     Arch = "amd64"
+	ArchARM64     Arch = "arm64"
+	ArchUniversal Arch = "universal" // macOS universal binary, native on Intel and Apple silicon
+)
+
+// ArchAuto asks the server to detect the client's architecture
+const ArchAuto = "auto"
+
+// PlatformInfo describes a platform in the catalog
+type PlatformInfo struct {
+	Name          Platform `json:"name"`
+	DisplayName   string   `json:"display_name"`
+	Architectures []Arch   `json:"architectures"` // Architectures builds are made for
+	Extension     string   `json:"-"`             // Added to filenames without an extension
+
+	// Runs lists, for each client architecture, the build architectures
+	// it can run, best first. The "" entry is used when the client's
+	// architecture is unknown and should only name builds that run on
+	// every client.
+	Runs map[Arch][]Arch `json:"-"`
+}
+
+// Platforms is the catalog of supported platforms
+var Platforms = []PlatformInfo{
+	{
+		Name:          PlatformWindows,
+		DisplayName:   "Windows",
+		Architectures: []Arch{ArchAMD64, ArchARM64},
+		Extension:     ".exe",
+		Runs: map[Arch][]Arch{
+			ArchAMD64: {ArchAMD64},
+			ArchARM64: {ArchARM64, ArchAMD64}, // x64 emulation on Windows 11
+			"":        {ArchAMD64},
+		},
+	},
+	{
+		Name:          PlatformMacOS,
+		DisplayName:   "macOS",
+		Architectures: []Arch{ArchUniversal, ArchARM64, ArchAMD64},
+		Runs: map[Arch][]Arch{
+			ArchAMD64:     {ArchAMD64, ArchUniversal},
+			ArchARM64:     {ArchARM64, ArchUniversal, ArchAMD64}, // Intel builds run under Rosetta 2
+			ArchUniversal: {ArchUniversal},
+			"":            {ArchUniversal, ArchAMD64},
+		},
+	},
+	{
+		Name:          PlatformLinux,
+		DisplayName:   "Linux",
+		Architectures: []Arch{ArchAMD64, ArchARM64},
+		Runs: map[Arch][]Arch{
+			ArchAMD64: {ArchAMD64},
+			ArchARM64: {ArchARM64},
+			"":        {ArchAMD64},
+		},
+	},
+}
+
+// GetPlatform returns a platform of the catalog by name
+func GetPlatform(name string) (PlatformInfo, bool) {
+	for _, platform := range Platforms {
+		if string(platform.Name) == name {
+			return platform, true
+		}
+	}
+	return PlatformInfo{}, false
+}
+
+// IsKnownPlatform checks if a platform is in the catalog
+func IsKnownPlatform(name string) bool {
+	_, ok := GetPlatform(name)
+	return ok
+}
+
+// HasArch checks if builds for the platform are made for an architecture
+func (p PlatformInfo) HasArch(arch Arch) bool {
+	for _, a := range p.Architectures {
+		if a == arch {
+			return true
+		}
+	}
+	return false
+}
+
+// ArchPreference returns the build architectures a client of arch can run,
+// best first, ending with "" for builds registered without an architecture,
+// which run everywhere. An empty arch means the client's is unknown.
+func (p PlatformInfo) ArchPreference(arch Arch) []Arch {
+	archs := append([]Arch(nil), p.Runs[arch]...)
+	return append(archs, "")
+}
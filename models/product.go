@@ -12,24 +12,37 @@ type Product struct {
 	DisplayName string   `json:"display_name"`
 	Available   bool     `json:"available"`
 	Platforms   []string `json:"platforms"`
+
+	// Architectures with a published stable build, by platform; absent for
+	// platforms whose builds run on any architecture
+	Architectures map[string][]Arch `json:"architectures,omitempty"`
 }
 
 // Available products
 var Products = []Product{
-	{Name: "chatgpt", DisplayName: "ChatGPT", Available: true, Platforms: []string{"windows", "macos"}},
-	{Name: "dalle", DisplayName: "DALL-E", Available: true, Platforms: []string{"windows", "macos"}},
-	{Name: "gemini", DisplayName: "Gemini", Available: true, Platforms: []string{"windows", "macos"}},
-	{Name: "hailuo", DisplayName: "Hailuo", Available: true, Platforms: []string{"windows", "macos"}},
-	{Name: "runway", DisplayName: "Runway", Available: true, Platforms: []string{"windows", "macos"}},
-	{Name: "sora", DisplayName: "Sora", Available: true, Platforms: []string{"windows", "macos"}},
-	{Name: "veo3", DisplayName: "Veo 3", Available: true, Platforms: []string{"windows", "macos"}},
-	{Name: "veo3_pro", DisplayName: "Veo 3 Pro", Available: true, Platforms: []string{"windows", "macos"}},
+	{Name: "chatgpt", DisplayName: "ChatGPT", Available: true, Platforms: []string{"windows", "macos", "linux"}},
+	{Name: "dalle", DisplayName: "DALL-E", Available: true, Platforms: []string{"windows", "macos", "linux"}},
+	{Name: "gemini", DisplayName: "Gemini", Available: true, Platforms: []string{"windows", "macos", "linux"}},
+	{Name: "hailuo", DisplayName: "Hailuo", Available: true, Platforms: []string{"windows", "macos", "linux"}},
+	{Name: "runway", DisplayName: "Runway", Available: true, Platforms: []string{"windows", "macos", "linux"}},
+	{Name: "sora", DisplayName: "Sora", Available: true, Platforms: []string{"windows", "macos", "linux"}},
+	{Name: "veo3", DisplayName: "Veo 3", Available: true, Platforms: []string{"windows", "macos", "linux"}},
+	{Name: "veo3_pro", DisplayName: "Veo 3 Pro", Available: true, Platforms: []string{"windows", "macos", "linux"}},
 }
 
 // ProductsResponse represents the response for listing products
 type ProductsResponse struct {
-	Products []Product `json:"products"`
-	User     UserInfo  `json:"user"`
+	Products  []Product         `json:"products"`
+	User      UserInfo          `json:"user"`
+	Platforms []PlatformInfo    `json:"platforms"`
+	Detected  *DetectedPlatform `json:"detected,omitempty"` // Client platform guessed from the request
+}
+
+// DetectedPlatform is the platform and architecture of a client, detected
+// from its User-Agent and client hints
+type DetectedPlatform struct {
+	Platform Platform `json:"platform"`
+	Arch     Arch     `json:"arch,omitempty"` // Empty when it cannot be told
 }
 
 // UserInfo represents user information in product response
@@ -47,17 +60,76 @@ type DownloadRecord struct {
 	UserID       primitive.ObjectID `bson:"user_id" json:"user_id"`
 	ProductName  string             `bson:"product_name" json:"product_name"`
 	Platform     string             `bson:"platform" json:"platform"`
+	Version      string             `bson:"version,omitempty" json:"version,omitempty"` // Release version; empty for the legacy unversioned file
+	Arch         Arch               `bson:"arch,omitempty" json:"arch,omitempty"`       // Architecture of the release build
 	SerialNumber string             `bson:"serial_number" json:"serial_number"`
 	IPAddress    string             `bson:"ip_address" json:"ip_address"`
 	UserAgent    string             `bson:"user_agent" json:"user_agent"`
+	Country      string             `bson:"country,omitempty" json:"country,omitempty"` // ISO 3166 code from downloads.country_header or GeoIP
+	ASN          uint32             `bson:"asn,omitempty" json:"asn,omitempty"`         // Autonomous system of the IP address, from GeoIP
+	ASOrg        string             `bson:"as_org,omitempty" json:"as_org,omitempty"`
 	DownloadedAt time.Time          `bson:"downloaded_at" json:"downloaded_at"`
 }
 
+// Reasons an account appears in the download abuse report
+const (
+	AbuseManyIPs       = "many_ips"       // Downloads from many IP addresses
+	AbuseManyCountries = "many_countries" // Downloads from many countries
+	AbuseManyDownloads = "many_downloads" // More downloads than one customer needs
+	AbuseScriptedAgent = "scripted_agent" // Downloads by curl, wget and similar tools
+)
+
+// DownloadAbuseEntry summarizes the recent downloads of an account whose
+// pattern suggests it is shared or leaked
+type DownloadAbuseEntry struct {
+	UserID          primitive.ObjectID `bson:"_id" json:"user_id"`
+	Email           string             `bson:"email" json:"email"`
+	IsBanned        bool               `bson:"is_banned" json:"is_banned"`
+	Downloads       int                `bson:"downloads" json:"downloads"`
+	IPAddresses     []string           `bson:"ip_addresses" json:"ip_addresses"`
+	Countries       []string           `bson:"countries" json:"countries"`
+	UserAgents      []string           `bson:"user_agents" json:"user_agents"`
+	ScriptedAgents  []string           `bson:"-" json:"scripted_agents,omitempty"`
+	FirstDownloadAt time.Time          `bson:"first_download_at" json:"first_download_at"`
+	LastDownloadAt  time.Time          `bson:"last_download_at" json:"last_download_at"`
+	Reasons         []string           `bson:"-" json:"reasons"`
+}
+
 // DownloadInfo represents download file information
 type DownloadInfo struct {
-	FilePath string
+	Key      string // Storage key of the file
 	Filename string
 	Size     int64
+	ModTime  time.Time
+	Version  string // Release version; empty for the legacy unversioned file
+	Arch     Arch   // Architecture of the release build; empty if it runs on any
+	SHA256   string // Recorded for releases; computed on first download for legacy files
+}
+
+// ETag returns the strong entity tag of the file, derived from its checksum
+func (d *DownloadInfo) ETag() string {
+	if d.SHA256 == "" {
+		return ""
+	}
+	return `"` + d.SHA256 + `"`
+}
+
+// DownloadLinkRequest asks for a signed link to a product file
+type DownloadLinkRequest struct {
+	SerialNumber string         `json:"serial_number" validate:"required,max=100"`
+	Version      string         `json:"version" validate:"max=50"`                      // A specific published release
+	Channel      ReleaseChannel `json:"channel" validate:"omitempty,oneof=stable beta"` // Latest release in the channel; default stable
+}
+
+// DownloadLinkResponse is a short-lived link that downloads a product file
+// without an Authorization header
+type DownloadLinkResponse struct {
+	URL       string    `json:"url"`
+	Filename  string    `json:"filename"`
+	Version   string    `json:"version,omitempty"`
+	Arch      Arch      `json:"arch,omitempty"`
+	Size      int64     `json:"size"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 // IsValidProduct checks if a product name is valid
@@ -0,0 +1,281 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReleaseChannel groups releases by how far they have been tested
+type ReleaseChannel string
+
+const (
+	ReleaseChannelStable ReleaseChannel = "stable"
+	ReleaseChannelBeta   ReleaseChannel = "beta" // Testers; also receives stable releases newer than the latest beta
+)
+
+// Release is one build of a product for a platform. Only published releases
+// are offered for download; unpublishing a bad build rolls users back to the
+// previous one.
+type Release struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ProductName  string             `bson:"product_name" json:"product_name"`
+	Platform     string             `bson:"platform" json:"platform"`
+	Arch         Arch               `bson:"arch,omitempty" json:"arch,omitempty"` // Empty for builds that run on every architecture of the platform
+	Version      string             `bson:"version" json:"version"`               // Semantic version, e.g. 1.4.0 or 1.5.0-beta.2
+	Channel      ReleaseChannel     `bson:"channel" json:"channel"`
+	FilePath     string             `bson:"file_path" json:"-"` // Storage key
+	Filename     string             `bson:"filename" json:"filename"`
+	Size         int64              `bson:"size" json:"size"`
+	SHA256       string             `bson:"sha256" json:"sha256"`
+	ReleaseNotes string             `bson:"release_notes,omitempty" json:"release_notes,omitempty"`
+	MinOSVersion string             `bson:"min_os_version,omitempty" json:"min_os_version,omitempty"`
+	Published    bool               `bson:"published" json:"published"`
+	PublishedAt  *time.Time         `bson:"published_at,omitempty" json:"published_at,omitempty"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+
+	// Sparkle EdDSA signature of the file, computed when the release is
+	// created or, for older releases, in the background at startup
+	SparkleSignature string `bson:"sparkle_signature,omitempty" json:"-"`
+
+	// Set when the product is watermarked but this build cannot carry a
+	// mark, e.g. a code-signed macOS binary; it is served unmarked
+	WatermarkNote string `bson:"watermark_note,omitempty" json:"watermark_note,omitempty"`
+}
+
+// CreateReleaseRequest registers a build already copied to storage below
+// <product>/<platform>/. Each version may have one build per architecture.
+type CreateReleaseRequest struct {
+	ProductName  string         `json:"product_name" validate:"required,max=50"`
+	Platform     string         `json:"platform" validate:"required,oneof=windows macos linux"`
+	Arch         Arch           `json:"arch" validate:"omitempty,oneof=amd64 arm64 universal"`
+	Version      string         `json:"version" validate:"required,max=50"`
+	Channel      ReleaseChannel `json:"channel" validate:"required,oneof=stable beta"`
+	File         string         `json:"file" validate:"required,max=255"` // Path relative to <product>/<platform>/
+	ReleaseNotes string         `json:"release_notes" validate:"max=10000"`
+	MinOSVersion string         `json:"min_os_version" validate:"max=50"`
+	Published    bool           `json:"published"`
+}
+
+// UpdateReleaseRequest changes a release's metadata; omitted fields are kept
+type UpdateReleaseRequest struct {
+	Channel      *ReleaseChannel `json:"channel,omitempty" validate:"omitempty,oneof=stable beta"`
+	ReleaseNotes *string         `json:"release_notes,omitempty" validate:"omitempty,max=10000"`
+	MinOSVersion *string         `json:"min_os_version,omitempty" validate:"omitempty,max=50"`
+	Published    *bool           `json:"published,omitempty"`
+}
+
+// BuildUploadStatus tracks a resumable upload of a product build
+type BuildUploadStatus string
+
+const (
+	BuildUploadUploading BuildUploadStatus = "uploading"
+	BuildUploadCompleted BuildUploadStatus = "completed" // Registered as a draft release
+)
+
+// BuildUpload is a resumable upload of a product build. Chunks are appended
+// at Offset until Size bytes have arrived, then the build becomes a draft
+// release.
+type BuildUpload struct {
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	ProductName    string              `bson:"product_name" json:"product_name"`
+	Platform       string              `bson:"platform" json:"platform"`
+	Arch           Arch                `bson:"arch,omitempty" json:"arch,omitempty"`
+	Version        string              `bson:"version" json:"version"`
+	Channel        ReleaseChannel      `bson:"channel" json:"channel"`
+	Filename       string              `bson:"filename" json:"filename"`
+	Size           int64               `bson:"size" json:"size"`
+	ExpectedSHA256 string              `bson:"expected_sha256,omitempty" json:"expected_sha256,omitempty"`
+	ReleaseNotes   string              `bson:"release_notes,omitempty" json:"release_notes,omitempty"`
+	MinOSVersion   string              `bson:"min_os_version,omitempty" json:"min_os_version,omitempty"`
+	Offset         int64               `bson:"offset" json:"offset"` // Bytes received so far
+	Status         BuildUploadStatus   `bson:"status" json:"status"`
+	ReleaseID      *primitive.ObjectID `bson:"release_id,omitempty" json:"release_id,omitempty"`
+	CreatedBy      primitive.ObjectID  `bson:"created_by" json:"created_by"`
+	CreatedAt      time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time           `bson:"updated_at" json:"updated_at"`
+	ExpiresAt      time.Time           `bson:"expires_at" json:"expires_at"`
+}
+
+// Metadata returns the build description of the upload
+func (u *BuildUpload) Metadata() *BuildMetadata {
+	return &BuildMetadata{
+		ProductName:    u.ProductName,
+		Platform:       u.Platform,
+		Arch:           u.Arch,
+		Version:        u.Version,
+		Channel:        u.Channel,
+		Filename:       u.Filename,
+		ExpectedSHA256: u.ExpectedSHA256,
+		ReleaseNotes:   u.ReleaseNotes,
+		MinOSVersion:   u.MinOSVersion,
+	}
+}
+
+// BuildMetadata describes an uploaded build and the draft release it becomes
+type BuildMetadata struct {
+	ProductName    string         `json:"product_name" validate:"required,max=50"`
+	Platform       string         `json:"platform" validate:"required,oneof=windows macos linux"`
+	Arch           Arch           `json:"arch" validate:"omitempty,oneof=amd64 arm64 universal"`
+	Version        string         `json:"version" validate:"required,max=50"`
+	Channel        ReleaseChannel `json:"channel" validate:"required,oneof=stable beta"`
+	Filename       string         `json:"filename" validate:"required,max=255"`
+	ExpectedSHA256 string         `json:"sha256" validate:"omitempty,len=64"` // Rejects the build if the upload does not match
+	ReleaseNotes   string         `json:"release_notes" validate:"max=10000"`
+	MinOSVersion   string         `json:"min_os_version" validate:"max=50"`
+}
+
+// CreateBuildUploadRequest starts a resumable build upload
+type CreateBuildUploadRequest struct {
+	ProductName    string         `json:"product_name" validate:"required,max=50"`
+	Platform       string         `json:"platform" validate:"required,oneof=windows macos linux"`
+	Arch           Arch           `json:"arch" validate:"omitempty,oneof=amd64 arm64 universal"`
+	Version        string         `json:"version" validate:"required,max=50"`
+	Channel        ReleaseChannel `json:"channel" validate:"required,oneof=stable beta"`
+	Filename       string         `json:"filename" validate:"required,max=255"`
+	Size           int64          `json:"size" validate:"required,min=1"`
+	ExpectedSHA256 string         `json:"sha256" validate:"omitempty,len=64"`
+	ReleaseNotes   string         `json:"release_notes" validate:"max=10000"`
+	MinOSVersion   string         `json:"min_os_version" validate:"max=50"`
+}
+
+// Metadata returns the build description of the request
+func (r *CreateBuildUploadRequest) Metadata() *BuildMetadata {
+	return &BuildMetadata{
+		ProductName:    r.ProductName,
+		Platform:       r.Platform,
+		Arch:           r.Arch,
+		Version:        r.Version,
+		Channel:        r.Channel,
+		Filename:       r.Filename,
+		ExpectedSHA256: r.ExpectedSHA256,
+		ReleaseNotes:   r.ReleaseNotes,
+		MinOSVersion:   r.MinOSVersion,
+	}
+}
+
+// UpdateResponse tells an app whether a newer release is available
+type UpdateResponse struct {
+	UpdateAvailable bool        `json:"update_available"`
+	CurrentVersion  string      `json:"current_version,omitempty"`
+	Update          *UpdateInfo `json:"update,omitempty"`
+}
+
+// UpdateInfo describes the release an app should update to
+type UpdateInfo struct {
+	Version      string         `json:"version"`
+	Channel      ReleaseChannel `json:"channel"`
+	Arch         Arch           `json:"arch,omitempty"`
+	ReleaseNotes string         `json:"release_notes,omitempty"`
+	MinOSVersion string         `json:"min_os_version,omitempty"`
+	PublishedAt  *time.Time     `json:"published_at,omitempty"`
+	Filename     string         `json:"filename"`
+	Size         int64          `json:"size"`
+	SHA256       string         `json:"sha256"`
+	URL          string         `json:"url"` // Signed download link
+	ExpiresAt    time.Time      `json:"expires_at"`
+}
+
+// parseVersion splits a semantic version into its numeric core and
+// pre-release part. The "v" prefix and build metadata are ignored.
+func parseVersion(version string) (core []int, prerelease string, ok bool) {
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexByte(version, '+'); i >= 0 {
+		version = version[:i]
+	}
+	if i := strings.IndexByte(version, '-'); i >= 0 {
+		version, prerelease = version[:i], version[i+1:]
+		if prerelease == "" {
+			return nil, "", false
+		}
+	}
+
+	parts := strings.Split(version, ".")
+	if len(parts) > 4 {
+		return nil, "", false
+	}
+	core = make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, "", false
+		}
+		core[i] = n
+	}
+	return core, prerelease, true
+}
+
+// IsValidVersion reports whether version can be ordered by CompareVersions
+func IsValidVersion(version string) bool {
+	_, _, ok := parseVersion(version)
+	return ok
+}
+
+// CompareVersions orders two versions: -1 if a < b, 0 if equal, 1 if a > b.
+// Pre-releases sort before the release they lead up to.
+func CompareVersions(a, b string) int {
+	coreA, preA, _ := parseVersion(a)
+	coreB, preB, _ := parseVersion(b)
+
+	for i := 0; i < max(len(coreA), len(coreB)); i++ {
+		var x, y int
+		if i < len(coreA) {
+			x = coreA[i]
+		}
+		if i < len(coreB) {
+			y = coreB[i]
+		}
+		if x != y {
+			return compareInts(x, y)
+		}
+	}
+
+	switch {
+	case preA == preB:
+		return 0
+	case preA == "":
+		return 1
+	case preB == "":
+		return -1
+	}
+	return comparePrerelease(preA, preB)
+}
+
+// comparePrerelease compares dot-separated identifiers, numerically where
+// both are numbers, so beta.10 sorts after beta.9
+func comparePrerelease(a, b string) int {
+	partsA := strings.Split(a, ".")
+	partsB := strings.Split(b, ".")
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		x, errX := strconv.Atoi(partsA[i])
+		y, errY := strconv.Atoi(partsB[i])
+		switch {
+		case errX == nil && errY == nil:
+			if x != y {
+				return compareInts(x, y)
+			}
+		case errX == nil:
+			return -1
+		case errY == nil:
+			return 1
+		default:
+			if c := strings.Compare(partsA[i], partsB[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInts(len(partsA), len(partsB))
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
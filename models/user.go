@@ -19,19 +19,47 @@ type User struct {
 	SerialNumber string             `bson:"serial_number" json:"serial_number"`
 	PaymentCode  string             `bson:"payment_code,omitempty" json:"payment_code,omitempty"`
 	Role         string             `bson:"role" json:"role"`
+	Language     string             `bson:"language,omitempty" json:"language,omitempty"` // Preferred language for API messages and emails (vi, en)
 	IsActive     bool               `bson:"is_active" json:"is_active"`
 	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
 	LastLogin    *time.Time         `bson:"last_login,omitempty" json:"last_login,omitempty"`
+
+	// Two-factor authentication
+	TwoFactorEnabled       bool     `bson:"two_factor_enabled" json:"two_factor_enabled"`
+	TwoFactorSecret        string   `bson:"two_factor_secret,omitempty" json:"-"`
+	TwoFactorPendingSecret string   `bson:"two_factor_pending_secret,omitempty" json:"-"`
+	TwoFactorLastStep      int64    `bson:"two_factor_last_step,omitempty" json:"-"` // Last accepted TOTP step (replay protection)
+	RecoveryCodes          []string `bson:"recovery_codes,omitempty" json:"-"`       // SHA-256 hashes of unused recovery codes
+
+	// External sign-in providers (OpenID Connect)
+	Identities []FederatedIdentity `bson:"identities,omitempty" json:"identities,omitempty"`
+
+	// Pending email change, applied once the new address is verified
+	PendingEmail              string     `bson:"pending_email,omitempty" json:"pending_email,omitempty"`
+	EmailVerificationHash     string     `bson:"email_verification_hash,omitempty" json:"-"` // SHA-256 of the emailed token
+	EmailVerificationExpireAt *time.Time `bson:"email_verification_expires_at,omitempty" json:"-"`
+
+	// Account deletion (personal data is anonymized, the record is kept for accounting)
+	DeletedAt        *time.Time `bson:"deleted_at,omitempty" json:"-"`
+	DeletedEmailHash string     `bson:"deleted_email_hash,omitempty" json:"-"` // SHA-256 of the former email, reserved until EmailReusableAt
+	EmailReusableAt  *time.Time `bson:"email_reusable_at,omitempty" json:"-"`
+
+	// Set on the request's user when an admin is impersonating them; never stored
+	ImpersonatedBy *Impersonator `bson:"-" json:"impersonated_by,omitempty"`
 }
 
-// Platform represents supported platforms
-type Platform string
+// Impersonator identifies the admin behind an impersonated request
+type Impersonator struct {
+	AdminID         primitive.ObjectID `json:"admin_id"`
+	AdminEmail      string             `json:"admin_email"`
+	ImpersonationID string             `json:"impersonation_id"`
+}
 
-const (
-	PlatformWindows Platform = "windows"
-	PlatformMacOS   Platform = "macos"
-)
+// IsImpersonated reports whether the request is made by an admin acting as this user
+func (u *User) IsImpersonated() bool {
+	return u.ImpersonatedBy != nil
+}
 
 // UserRole represents user roles in the system
 type UserRole string
@@ -45,32 +73,120 @@ const (
 // LoginRequest represents the login request payload
 type LoginRequest struct {
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=6"`
+	Password string `json:"password" validate:"required"` // Length rules apply at registration, not login
 }
 
 // RegisterRequest represents the registration request payload
 type RegisterRequest struct {
 	Email        string    `json:"email" validate:"required,email"`
 	Password     string    `json:"password" validate:"required,min=6"`
-	FullName     string    `json:"full_name" validate:"required"`
-	DateOfBirth  time.Time `json:"date_of_birth"`
-	Platform     Platform  `json:"platform" validate:"required"`
-	SerialNumber string    `json:"serial_number" validate:"required"`
+	FullName     string    `json:"full_name" validate:"required,max=100"`
+	DateOfBirth  time.Time `json:"date_of_birth" validate:"omitempty,past"`
+	Platform     Platform  `json:"platform" validate:"required,oneof=windows macos linux"`
+	SerialNumber string    `json:"serial_number" validate:"required,max=100"`
+	Language     string    `json:"language,omitempty" validate:"omitempty,oneof=vi en"`
 }
 
-// LoginResponse represents the login response
+// ChangePasswordRequest represents the request to change the current user's password
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"` // Not required when the account has no password yet
+	NewPassword     string `json:"new_password" validate:"required,min=6"`
+}
+
+// UpdateProfileRequest represents a partial profile update; omitted fields are unchanged
+type UpdateProfileRequest struct {
+	FullName    *string    `json:"full_name,omitempty" validate:"omitempty,min=1,max=100"`
+	DateOfBirth *time.Time `json:"date_of_birth,omitempty" validate:"omitempty,past"`
+	Platform    *Platform  `json:"platform,omitempty" validate:"omitempty,oneof=windows macos linux"`
+	Language    *string    `json:"language,omitempty" validate:"omitempty,oneof=vi en"`
+}
+
+// ChangeEmailRequest represents the request to move the account to a new email
+type ChangeEmailRequest struct {
+	NewEmail string `json:"new_email" validate:"required,email"`
+	Password string `json:"password"` // Required when the account has a password
+}
+
+// VerifyEmailRequest confirms a pending email change
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// DeleteAccountRequest confirms account deletion
+type DeleteAccountRequest struct {
+	Password     string `json:"password"`      // Required when the account has a password
+	Code         string `json:"code"`          // Required when two-factor is enabled
+	ConfirmEmail string `json:"confirm_email"` // Required for accounts without a password
+}
+
+// ImpersonateRequest represents a super admin's request to act as a user
+type ImpersonateRequest struct {
+	Reason string `json:"reason" validate:"required"` // Recorded in the audit trail
+}
+
+// ImpersonateResponse contains the short-lived impersonation token
+type ImpersonateResponse struct {
+	Token           string `json:"token"`
+	ExpiresAt       int64  `json:"expires_at"`
+	ImpersonationID string `json:"impersonation_id"`
+	User            *User  `json:"user"`
+}
+
+// LoginResponse represents the login response.
+// When MFARequired is set, only MFAToken and ExpiresAt are populated and the
+// client must complete the second step before receiving real tokens.
 type LoginResponse struct {
-	User         *User  `json:"user"`
-	Token        string `json:"token"`
-	RefreshToken string `json:"refresh_token"`
+	User         *User  `json:"user,omitempty"`
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
 	ExpiresAt    int64  `json:"expires_at"`
+	MFARequired  bool   `json:"mfa_required,omitempty"`
+	MFAToken     string `json:"mfa_token,omitempty"`
+}
+
+// MFAVerifyRequest represents the second login step payload
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"` // TOTP code or recovery code
+}
+
+// TwoFactorCodeRequest represents a request confirmed by a TOTP or recovery code
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// TwoFactorDisableRequest represents the request to turn off two-factor authentication
+type TwoFactorDisableRequest struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
+
+// TwoFactorSetupResponse contains the secret for authenticator app enrollment
+type TwoFactorSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"` // Encode as QR code for authenticator apps
+}
+
+// RecoveryCodesResponse contains freshly generated recovery codes (shown once)
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
 }
 
 // ErrorResponse represents an error response
 type ErrorResponse struct {
-	Error   string `json:"error"`
-	Message string `json:"message,omitempty"`
-	Code    int    `json:"code,omitempty"`
+	Error     string       `json:"error"`                // Human-readable message
+	Code      ErrorCode    `json:"code"`                 // Stable machine-readable code
+	Status    int          `json:"status"`               // HTTP status code
+	RequestID string       `json:"request_id,omitempty"` // Quote this when contacting support
+	Fields    []FieldError `json:"fields,omitempty"`     // Set for VALIDATION_FAILED
+}
+
+// FieldError describes why one request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
 }
 
 // SuccessResponse represents a success response
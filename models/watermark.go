@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WatermarkedBuild is a user's copy of a build carrying their mark. It is
+// created on first download and served again for the same user and build,
+// so resumed downloads and checksums stay consistent.
+type WatermarkedBuild struct {
+	ID           string             `bson:"_id" json:"id"` // <user ID>:<source SHA-256>
+	UserID       primitive.ObjectID `bson:"user_id" json:"user_id"`
+	ProductName  string             `bson:"product_name" json:"product_name"`
+	Platform     string             `bson:"platform" json:"platform"`
+	Version      string             `bson:"version,omitempty" json:"version,omitempty"` // Empty for the legacy unversioned file
+	SourceSHA256 string             `bson:"source_sha256" json:"source_sha256"`
+	Key          string             `bson:"key" json:"-"` // Storage key of the copy
+	Size         int64              `bson:"size" json:"size"`
+	SHA256       string             `bson:"sha256" json:"sha256"`
+	Method       string             `bson:"method" json:"method"` // Where the mark was placed: slot, pe_certificate or appended
+	IssuedAt     time.Time          `bson:"issued_at" json:"issued_at"`
+
+	// Sparkle EdDSA signature of the copy, computed on first use
+	SparkleSignature string `bson:"sparkle_signature,omitempty" json:"-"`
+}
+
+// WatermarkReport identifies the account a suspicious file was issued to.
+// A file is identified by its mark or, when the mark has been stripped, by
+// an exact checksum match with a copy that was served.
+type WatermarkReport struct {
+	SHA256     string            `json:"sha256"`
+	Size       int64             `json:"size"`
+	Found      bool              `json:"found"`      // A mark sealed with the current secret was found
+	Unreadable bool              `json:"unreadable"` // A mark was found but is corrupt or sealed with another secret
+	Mark       *WatermarkMark    `json:"mark,omitempty"`
+	Build      *WatermarkedBuild `json:"build,omitempty"` // The served copy with the same checksum, if any
+	User       *WatermarkUser    `json:"user,omitempty"`
+}
+
+// WatermarkMark is the payload recovered from a file
+type WatermarkMark struct {
+	UserID       string    `json:"user_id"`
+	SerialNumber string    `json:"serial_number"`
+	ProductName  string    `json:"product_name"`
+	Platform     string    `json:"platform"`
+	Version      string    `json:"version,omitempty"`
+	IssuedAt     time.Time `json:"issued_at"`
+}
+
+// WatermarkUser is the account a file was traced to
+type WatermarkUser struct {
+	ID           primitive.ObjectID `json:"id"`
+	Email        string             `json:"email"`
+	FullName     string             `json:"full_name"`
+	SerialNumber string             `json:"serial_number"`
+	IsBanned     bool               `json:"is_banned"`
+	DeletedAt    *time.Time         `json:"deleted_at,omitempty"`
+}
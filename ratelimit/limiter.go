@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+const limiterKeyPrefix = "limit:"
+
+// LimitedError is returned when a Limiter refuses a request
+type LimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LimitedError) Error() string {
+	return fmt.Sprintf("too many requests, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// Limiter allows a fixed number of requests per key within a window
+type Limiter struct {
+	store  Store
+	name   string
+	limit  int64
+	window time.Duration
+}
+
+// NewLimiter creates a limiter using the default store. name separates its
+// counters from those of other limiters; a limit of 0 or less disables it.
+func NewLimiter(name string, limit int, window time.Duration) *Limiter {
+	return &Limiter{
+		store:  DefaultStore(),
+		name:   name,
+		limit:  int64(limit),
+		window: window,
+	}
+}
+
+// Allow counts a request for key and returns a *LimitedError once the key
+// has used up its requests for the current window. Store failures let the
+// request through.
+func (l *Limiter) Allow(ctx context.Context, key string) error {
+	if l.limit <= 0 || key == "" {
+		return nil
+	}
+
+	storeKey := limiterKeyPrefix + l.name + ":" + key
+	count, err := l.store.Increment(ctx, storeKey, l.window)
+	if err != nil {
+		log.Printf("RATE LIMIT ERROR: Failed to count %s request: %v", l.name, err)
+		return nil
+	}
+	if count <= l.limit {
+		return nil
+	}
+
+	retryAfter, err := l.store.TTL(ctx, storeKey)
+	if err != nil || retryAfter <= 0 {
+		retryAfter = l.window
+	}
+	return &LimitedError{RetryAfter: retryAfter}
+}
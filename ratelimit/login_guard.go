@@ -0,0 +1,244 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"jinzmedia-atmt/config"
+	"jinzmedia-atmt/mailer"
+)
+
+const loginKeyPrefix = "login_guard:"
+
+// LockedError is returned when a login is refused because of earlier failures
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// LoginGuard tracks failed logins per email and per client IP, applying
+// exponential backoff, temporary lockouts and password-spray alerts
+type LoginGuard struct {
+	store  Store
+	cfg    *config.Config
+	outbox *mailer.Outbox
+}
+
+// NewLoginGuard creates a new login guard using the default store
+func NewLoginGuard() *LoginGuard {
+	return &LoginGuard{
+		store:  DefaultStore(),
+		cfg:    config.Get(),
+		outbox: mailer.NewOutbox(),
+	}
+}
+
+// Check returns a *LockedError if the email or IP is currently locked out
+func (g *LoginGuard) Check(ctx context.Context, email, ip string) error {
+	if !g.cfg.LoginGuard.Enabled {
+		return nil
+	}
+
+	keys := []string{g.lockKey("email", normalizeEmail(email))}
+	if ip = normalizeIP(ip); ip != "" {
+		keys = append(keys, g.lockKey("ip", ip))
+	}
+
+	var retryAfter time.Duration
+	for _, key := range keys {
+		ttl, err := g.store.TTL(ctx, key)
+		if err != nil {
+			// Fail open: a broken store must not lock everyone out
+			log.Printf("LOGIN GUARD ERROR: Failed to read lock %s: %v", key, err)
+			continue
+		}
+		if ttl > retryAfter {
+			retryAfter = ttl
+		}
+	}
+
+	if retryAfter > 0 {
+		return &LockedError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed attempt and applies backoff or lockout
+func (g *LoginGuard) RecordFailure(ctx context.Context, email, ip string) {
+	if !g.cfg.LoginGuard.Enabled {
+		return
+	}
+
+	policy := g.cfg.LoginGuard
+	window := g.window()
+	email = normalizeEmail(email)
+	ip = normalizeIP(ip)
+
+	// Per-email: exponential backoff, then a temporary lockout
+	emailFailures, err := g.store.Increment(ctx, g.failKey("email", email), window)
+	if err != nil {
+		log.Printf("LOGIN GUARD ERROR: Failed to count failure for %s: %v", email, err)
+	} else if delay := g.emailDelay(emailFailures); delay > 0 {
+		g.lock(ctx, "email", email, delay)
+		if policy.EmailMaxAttempts > 0 && emailFailures == int64(policy.EmailMaxAttempts) {
+			log.Printf("LOGIN GUARD: Account %s locked for %s after %d failed attempts", email, delay, emailFailures)
+		}
+	}
+
+	if ip == "" {
+		return
+	}
+
+	// Per-IP: temporary lockout only, with a higher threshold
+	ipFailures, err := g.store.Increment(ctx, g.failKey("ip", ip), window)
+	if err != nil {
+		log.Printf("LOGIN GUARD ERROR: Failed to count failure for IP %s: %v", ip, err)
+	} else if policy.IPMaxAttempts > 0 && ipFailures >= int64(policy.IPMaxAttempts) {
+		g.lock(ctx, "ip", ip, g.lockoutDuration())
+		if ipFailures == int64(policy.IPMaxAttempts) {
+			log.Printf("LOGIN GUARD: IP %s locked for %s after %d failed attempts", ip, g.lockoutDuration(), ipFailures)
+		}
+	}
+
+	// Password spraying: one IP failing against many different accounts
+	if policy.SprayThreshold > 0 && email != "" {
+		accounts, err := g.store.AddToSet(ctx, loginKeyPrefix+"spray:"+ip, email, window)
+		if err != nil {
+			log.Printf("LOGIN GUARD ERROR: Failed to track accounts for IP %s: %v", ip, err)
+		} else if accounts >= int64(policy.SprayThreshold) {
+			g.lock(ctx, "ip", ip, g.lockoutDuration())
+			if accounts == int64(policy.SprayThreshold) {
+				g.alertSpray(ctx, ip, accounts)
+			}
+		}
+	}
+}
+
+// RecordSuccess clears the per-email failure history after a good login
+func (g *LoginGuard) RecordSuccess(ctx context.Context, email, ip string) {
+	if !g.cfg.LoginGuard.Enabled {
+		return
+	}
+
+	email = normalizeEmail(email)
+	if err := g.store.Delete(ctx, g.failKey("email", email), g.lockKey("email", email)); err != nil {
+		log.Printf("LOGIN GUARD ERROR: Failed to reset failures for %s: %v", email, err)
+	}
+}
+
+// UnlockEmail clears the lockout and failure history for an account
+func (g *LoginGuard) UnlockEmail(ctx context.Context, email string) error {
+	email = normalizeEmail(email)
+	return g.store.Delete(ctx, g.failKey("email", email), g.lockKey("email", email))
+}
+
+// UnlockIP clears the lockout, failure and spray history for a client IP
+func (g *LoginGuard) UnlockIP(ctx context.Context, ip string) error {
+	ip = normalizeIP(ip)
+	return g.store.Delete(ctx, g.failKey("ip", ip), g.lockKey("ip", ip), loginKeyPrefix+"spray:"+ip)
+}
+
+// emailDelay returns how long an account is locked after the given number of failures
+func (g *LoginGuard) emailDelay(failures int64) time.Duration {
+	policy := g.cfg.LoginGuard
+
+	if policy.EmailMaxAttempts > 0 && failures >= int64(policy.EmailMaxAttempts) {
+		return g.lockoutDuration()
+	}
+	if failures <= int64(policy.FreeAttempts) {
+		return 0
+	}
+
+	delay := policy.BaseDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	maxDelay := policy.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 5 * time.Minute
+	}
+
+	for i := int64(policy.FreeAttempts) + 1; i < failures; i++ {
+		delay *= 2
+		if delay >= maxDelay {
+			return maxDelay
+		}
+	}
+	return delay
+}
+
+func (g *LoginGuard) lock(ctx context.Context, kind, subject string, duration time.Duration) {
+	if err := g.store.Set(ctx, g.lockKey(kind, subject), 1, duration); err != nil {
+		log.Printf("LOGIN GUARD ERROR: Failed to lock %s %s: %v", kind, subject, err)
+	}
+}
+
+// alertSpray logs and emails a security alert about a password-spraying IP
+func (g *LoginGuard) alertSpray(ctx context.Context, ip string, accounts int64) {
+	log.Printf("SECURITY ALERT: IP %s failed logins against %d different accounts within %s; IP locked for %s",
+		ip, accounts, g.window(), g.lockoutDuration())
+
+	to := g.cfg.LoginGuard.AlertEmail
+	if to == "" {
+		return
+	}
+
+	err := g.outbox.Enqueue(ctx, nil, to, mailer.TemplateSecurityAlert, "", map[string]interface{}{
+		"IP":         ip,
+		"Accounts":   accounts,
+		"Window":     g.window().String(),
+		"LockedFor":  g.lockoutDuration().String(),
+		"DetectedAt": time.Now().Format("02/01/2006 15:04:05"),
+	})
+	if err != nil {
+		log.Printf("LOGIN GUARD ERROR: Failed to queue security alert: %v", err)
+	}
+}
+
+func (g *LoginGuard) window() time.Duration {
+	if g.cfg.LoginGuard.Window > 0 {
+		return g.cfg.LoginGuard.Window
+	}
+	return time.Hour
+}
+
+func (g *LoginGuard) lockoutDuration() time.Duration {
+	if g.cfg.LoginGuard.LockoutDuration > 0 {
+		return g.cfg.LoginGuard.LockoutDuration
+	}
+	return 15 * time.Minute
+}
+
+func (g *LoginGuard) failKey(kind, subject string) string {
+	return loginKeyPrefix + "fail:" + kind + ":" + subject
+}
+
+func (g *LoginGuard) lockKey(kind, subject string) string {
+	return loginKeyPrefix + "lock:" + kind + ":" + subject
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// normalizeIP returns the subject per-IP limits are kept under. IPv6
+// clients are grouped by /64, the smallest block a host is usually given,
+// so cycling through addresses in it does not reset the counters.
+func normalizeIP(ip string) string {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return strings.TrimSpace(ip)
+	}
+	if parsed.To4() != nil {
+		return parsed.String()
+	}
+	network := net.IPNet{IP: parsed.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}
+	return network.String()
+}
@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-process Store. Counters are not shared between
+// instances, so use Redis when running more than one.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	value     int64
+	members   map[string]struct{}
+	expiresAt time.Time
+}
+
+// NewMemoryStore creates a new in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries:   make(map[string]*memoryEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// Increment adds one to the counter at key
+func (s *MemoryStore) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.getLocked(key)
+	if entry == nil {
+		entry = &memoryEntry{expiresAt: time.Now().Add(window)}
+		s.entries[key] = entry
+	}
+	entry.value++
+	return entry.value, nil
+}
+
+// Get returns the counter at key
+func (s *MemoryStore) Get(ctx context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry := s.getLocked(key); entry != nil {
+		return entry.value, nil
+	}
+	return 0, nil
+}
+
+// Set stores a value at key that expires after ttl
+func (s *MemoryStore) Set(ctx context.Context, key string, value int64, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = &memoryEntry{value: value, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// TTL returns the remaining lifetime of key
+func (s *MemoryStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry := s.getLocked(key); entry != nil {
+		return time.Until(entry.expiresAt), nil
+	}
+	return 0, nil
+}
+
+// Delete removes the given keys
+func (s *MemoryStore) Delete(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// AddToSet adds member to the set at key and returns the set size
+func (s *MemoryStore) AddToSet(ctx context.Context, key, member string, window time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.getLocked(key)
+	if entry == nil {
+		entry = &memoryEntry{expiresAt: time.Now().Add(window)}
+		s.entries[key] = entry
+	}
+	if entry.members == nil {
+		entry.members = make(map[string]struct{})
+	}
+	entry.members[member] = struct{}{}
+	return int64(len(entry.members)), nil
+}
+
+// getLocked returns a live entry, dropping it if expired. Also sweeps all
+// expired entries once a minute so abandoned keys do not accumulate.
+func (s *MemoryStore) getLocked(key string) *memoryEntry {
+	now := time.Now()
+
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, e := range s.entries {
+			if now.After(e.expiresAt) {
+				delete(s.entries, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	if now.After(entry.expiresAt) {
+		delete(s.entries, key)
+		return nil
+	}
+	return entry
+}
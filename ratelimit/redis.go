@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore is a Store backed by Redis, shared across instances
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a new Redis-backed store
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// Increment adds one to the counter at key
+func (s *RedisStore) Increment(ctx context.Context, key string, window time.Duration) (int64, error) {
+	count, err := s.client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 {
+		if err := s.client.Expire(ctx, key, window).Err(); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// Get returns the counter at key
+func (s *RedisStore) Get(ctx context.Context, key string) (int64, error) {
+	value, err := s.client.Get(ctx, key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return value, err
+}
+
+// Set stores a value at key that expires after ttl
+func (s *RedisStore) Set(ctx context.Context, key string, value int64, ttl time.Duration) error {
+	return s.client.Set(ctx, key, value, ttl).Err()
+}
+
+// TTL returns the remaining lifetime of key
+func (s *RedisStore) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	// Negative values mean the key is missing or has no expiry
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// Delete removes the given keys
+func (s *RedisStore) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return s.client.Del(ctx, keys...).Err()
+}
+
+// AddToSet adds member to the set at key and returns the set size
+func (s *RedisStore) AddToSet(ctx context.Context, key, member string, window time.Duration) (int64, error) {
+	pipe := s.client.TxPipeline()
+	pipe.SAdd(ctx, key, member)
+	sizeCmd := pipe.SCard(ctx, key)
+	ttlCmd := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	// Start the window when the set is first created
+	if ttlCmd.Val() < 0 {
+		if err := s.client.PExpire(ctx, key, window).Err(); err != nil {
+			return sizeCmd.Val(), err
+		}
+	}
+	return sizeCmd.Val(), nil
+}
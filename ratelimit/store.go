@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"jinzmedia-atmt/database"
+)
+
+// Store keeps expiring counters and sets shared by rate limiters
+type Store interface {
+	// Increment adds one to the counter at key. The expiry window starts on
+	// the first increment and is not extended by later ones.
+	Increment(ctx context.Context, key string, window time.Duration) (int64, error)
+	// Get returns the counter at key, or 0 if it does not exist
+	Get(ctx context.Context, key string) (int64, error)
+	// Set stores a value at key that expires after ttl
+	Set(ctx context.Context, key string, value int64, ttl time.Duration) error
+	// TTL returns the remaining lifetime of key, or 0 if it does not exist
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	// Delete removes the given keys
+	Delete(ctx context.Context, keys ...string) error
+	// AddToSet adds member to the set at key and returns the set size. The
+	// expiry window starts when the set is created.
+	AddToSet(ctx context.Context, key, member string, window time.Duration) (int64, error)
+}
+
+var (
+	defaultStore     Store
+	defaultStoreOnce sync.Once
+)
+
+// DefaultStore returns the Redis store when Redis is connected and a shared
+// in-memory store otherwise
+func DefaultStore() Store {
+	defaultStoreOnce.Do(func() {
+		if client := database.GetRedis(); client != nil {
+			defaultStore = NewRedisStore(client)
+			return
+		}
+		defaultStore = NewMemoryStore()
+	})
+	return defaultStore
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"jinzmedia-atmt/auth"
 	"jinzmedia-atmt/config"
@@ -19,7 +20,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	email := os.Args[1]
+	email := strings.ToLower(strings.TrimSpace(os.Args[1]))
 	password := os.Args[2]
 	firstName := os.Args[3]
 	lastName := os.Args[4]
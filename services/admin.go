@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -15,6 +16,12 @@ import (
 	"jinzmedia-atmt/models"
 )
 
+var (
+	ErrJobNotFound      = errors.New("job not found")
+	ErrWorkflowNotFound = errors.New("workflow not found")
+	ErrInvalidDateRange = errors.New("invalid date range")
+)
+
 type AdminService struct {
 	userCollection     *mongo.Collection
 	paymentCollection  *mongo.Collection
@@ -137,25 +144,9 @@ func (as *AdminService) GetCostStats(params *models.AnalyticsParams) (*models.Co
 	ctx := context.Background()
 
 	// Calculate date range
-	var startDate, endDate time.Time
-	if params.Period > 0 {
-		endDate = time.Now()
-		startDate = endDate.AddDate(0, 0, -params.Period)
-	} else if params.StartDate != "" && params.EndDate != "" {
-		var err error
-		startDate, err = time.Parse("2006-01-02", params.StartDate)
-		if err != nil {
-			return nil, fmt.Errorf("invalid start date format: %w", err)
-		}
-		endDate, err = time.Parse("2006-01-02", params.EndDate)
-		if err != nil {
-			return nil, fmt.Errorf("invalid end date format: %w", err)
-		}
-		endDate = endDate.Add(24 * time.Hour) // Include the end date
-	} else {
-		// Default to last 30 days
-		endDate = time.Now()
-		startDate = endDate.AddDate(0, 0, -30)
+	startDate, endDate, err := analyticsRange(params)
+	if err != nil {
+		return nil, err
 	}
 
 	// Get all successful payments for overall stats
@@ -202,6 +193,34 @@ func (as *AdminService) GetCostStats(params *models.AnalyticsParams) (*models.Co
 	}, nil
 }
 
+// analyticsRange returns the time range selected by analytics parameters:
+// the last Period days, StartDate to EndDate inclusive, or the last 30 days
+func analyticsRange(params *models.AnalyticsParams) (time.Time, time.Time, error) {
+	if params.Period > 0 {
+		endDate := time.Now()
+		return endDate.AddDate(0, 0, -params.Period), endDate, nil
+	}
+
+	if params.StartDate != "" && params.EndDate != "" {
+		startDate, err := time.Parse("2006-01-02", params.StartDate)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("%w: invalid start date format", ErrInvalidDateRange)
+		}
+		endDate, err := time.Parse("2006-01-02", params.EndDate)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("%w: invalid end date format", ErrInvalidDateRange)
+		}
+		if endDate.Before(startDate) {
+			return time.Time{}, time.Time{}, fmt.Errorf("%w: end date is before start date", ErrInvalidDateRange)
+		}
+		return startDate, endDate.Add(24 * time.Hour), nil // Include the end date
+	}
+
+	// Default to last 30 days
+	endDate := time.Now()
+	return endDate.AddDate(0, 0, -30), endDate, nil
+}
+
 // GetJobs returns paginated jobs list
 func (as *AdminService) GetJobs(params *models.JobsParams) (*models.JobsList, error) {
 	// Mock data for now - in production, you'd query your job database
@@ -0,0 +1,466 @@
+package services
+
+import (
+	"context"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"jinzmedia-atmt/config"
+	"jinzmedia-atmt/database"
+	"jinzmedia-atmt/models"
+	"jinzmedia-atmt/storage"
+)
+
+var (
+	ErrUploadNotFound       = errors.New("upload not found")
+	ErrUploadOffsetMismatch = errors.New("upload offset does not match")
+	ErrUploadTooLarge       = errors.New("upload is too large")
+	ErrUploadIncomplete     = errors.New("upload is incomplete")
+	ErrChecksumMismatch     = errors.New("uploaded file does not match the expected SHA-256")
+	ErrInvalidExecutable    = errors.New("uploaded file is not an executable for the platform")
+)
+
+// Defaults for build uploads when file_upload is not configured
+const (
+	defaultUploadPath       = "uploads/"
+	defaultUploadChunkSize  = 10 << 20
+	defaultBuildMaxSize     = 2 << 30
+	defaultUploadExpiration = 24 * time.Hour
+)
+
+// BuildUploadService receives product builds from admins, verifies them and
+// registers them as draft releases
+type BuildUploadService struct {
+	uploadCollection *mongo.Collection
+	releases         *ReleaseService
+	storage          storage.Storage
+	cfg              *config.Config
+}
+
+func NewBuildUploadService() *BuildUploadService {
+	return &BuildUploadService{
+		uploadCollection: database.GetCollection("build_uploads"),
+		releases:         NewReleaseService(),
+		storage:          storage.Default(),
+		cfg:              config.Get(),
+	}
+}
+
+// UploadBuild stores a build sent in a single request and registers it as a
+// draft release
+func (s *BuildUploadService) UploadBuild(ctx context.Context, meta *models.BuildMetadata, body io.Reader) (*models.Release, error) {
+	if err := s.checkBuild(ctx, meta); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(s.tempDir(), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	tempPath := filepath.Join(s.tempDir(), primitive.NewObjectID().Hex()+".part")
+
+	file, err := os.OpenFile(tempPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	written, err := io.Copy(file, io.LimitReader(body, s.buildMaxSize()+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > s.buildMaxSize() {
+		err = ErrUploadTooLarge
+	}
+	if err == nil && written == 0 {
+		err = fmt.Errorf("%w: file is empty", ErrInvalidRelease)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
+
+	release, err := s.finishBuild(ctx, meta, tempPath)
+	if err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
+	return release, nil
+}
+
+// StartUpload begins a resumable upload. The build is then sent in chunks
+// of at most file_upload.max_size bytes with AppendChunk.
+func (s *BuildUploadService) StartUpload(ctx context.Context, adminID primitive.ObjectID, req *models.CreateBuildUploadRequest) (*models.BuildUpload, error) {
+	if err := s.checkBuild(ctx, req.Metadata()); err != nil {
+		return nil, err
+	}
+	if req.Size > s.buildMaxSize() {
+		return nil, ErrUploadTooLarge
+	}
+	if err := checkSparkleSize(s.cfg, req.Platform, req.Size); err != nil {
+		return nil, err
+	}
+
+	s.removeExpiredUploads(ctx)
+
+	now := time.Now()
+	upload := &models.BuildUpload{
+		ID:             primitive.NewObjectID(),
+		ProductName:    req.ProductName,
+		Platform:       req.Platform,
+		Arch:           req.Arch,
+		Version:        req.Version,
+		Channel:        req.Channel,
+		Filename:       req.Filename,
+		Size:           req.Size,
+		ExpectedSHA256: strings.ToLower(req.ExpectedSHA256),
+		ReleaseNotes:   req.ReleaseNotes,
+		MinOSVersion:   req.MinOSVersion,
+		Status:         models.BuildUploadUploading,
+		CreatedBy:      adminID,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		ExpiresAt:      now.Add(s.uploadExpiration()),
+	}
+
+	if err := os.MkdirAll(s.tempDir(), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	file, err := os.OpenFile(s.uploadPath(upload.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	file.Close()
+
+	if _, err := s.uploadCollection.InsertOne(ctx, upload); err != nil {
+		os.Remove(s.uploadPath(upload.ID))
+		return nil, fmt.Errorf("failed to create upload: %w", err)
+	}
+	return upload, nil
+}
+
+// GetUpload returns an upload in progress, e.g. to resume at its offset
+func (s *BuildUploadService) GetUpload(ctx context.Context, uploadID primitive.ObjectID) (*models.BuildUpload, error) {
+	var upload models.BuildUpload
+	err := s.uploadCollection.FindOne(ctx, bson.M{
+		"_id":        uploadID,
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&upload)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUploadNotFound
+		}
+		return nil, fmt.Errorf("failed to get upload: %w", err)
+	}
+	return &upload, nil
+}
+
+// AppendChunk writes the next chunk of an upload. offset must equal the bytes
+// received so far; a chunk that fails midway is discarded and can be resent.
+func (s *BuildUploadService) AppendChunk(ctx context.Context, uploadID primitive.ObjectID, offset int64, body io.Reader) (*models.BuildUpload, error) {
+	upload, err := s.GetUpload(ctx, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Status != models.BuildUploadUploading || offset != upload.Offset {
+		return nil, ErrUploadOffsetMismatch
+	}
+
+	file, err := os.OpenFile(s.uploadPath(upload.ID), os.O_WRONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload file: %w", err)
+	}
+	defer file.Close()
+
+	// Drop anything left behind by an interrupted chunk
+	if err := file.Truncate(offset); err != nil {
+		return nil, fmt.Errorf("failed to prepare upload file: %w", err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to prepare upload file: %w", err)
+	}
+
+	limit := min(s.chunkSize(), upload.Size-offset)
+	written, err := io.Copy(file, io.LimitReader(body, limit+1))
+	if err == nil && written > limit {
+		err = ErrUploadTooLarge
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Truncate(offset)
+		return nil, err
+	}
+
+	now := time.Now()
+	result, err := s.uploadCollection.UpdateOne(ctx,
+		bson.M{"_id": upload.ID, "offset": offset, "status": models.BuildUploadUploading},
+		bson.M{"$set": bson.M{"offset": offset + written, "updated_at": now}},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record upload progress: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrUploadOffsetMismatch
+	}
+
+	upload.Offset += written
+	upload.UpdatedAt = now
+	return upload, nil
+}
+
+// CompleteUpload verifies a fully received upload and registers it as a
+// draft release
+func (s *BuildUploadService) CompleteUpload(ctx context.Context, uploadID primitive.ObjectID) (*models.Release, error) {
+	upload, err := s.GetUpload(ctx, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.Status != models.BuildUploadUploading || upload.Offset != upload.Size {
+		return nil, ErrUploadIncomplete
+	}
+
+	meta := upload.Metadata()
+	if err := s.checkBuild(ctx, meta); err != nil {
+		return nil, err
+	}
+
+	release, err := s.finishBuild(ctx, meta, s.uploadPath(upload.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.uploadCollection.UpdateOne(ctx,
+		bson.M{"_id": upload.ID},
+		bson.M{"$set": bson.M{
+			"status":     models.BuildUploadCompleted,
+			"release_id": release.ID,
+			"updated_at": time.Now(),
+		}},
+	); err != nil {
+		log.Printf("RELEASE ERROR: Failed to mark upload %s completed: %v", upload.ID.Hex(), err)
+	}
+	return release, nil
+}
+
+// CancelUpload discards an unfinished upload
+func (s *BuildUploadService) CancelUpload(ctx context.Context, uploadID primitive.ObjectID) error {
+	result, err := s.uploadCollection.DeleteOne(ctx, bson.M{"_id": uploadID, "status": models.BuildUploadUploading})
+	if err != nil {
+		return fmt.Errorf("failed to cancel upload: %w", err)
+	}
+	if result.DeletedCount == 0 {
+		return ErrUploadNotFound
+	}
+
+	os.Remove(s.uploadPath(uploadID))
+	return nil
+}
+
+// checkBuild validates the metadata of an upload before any data is stored
+func (s *BuildUploadService) checkBuild(ctx context.Context, meta *models.BuildMetadata) error {
+	if meta.Filename != filepath.Base(meta.Filename) || strings.ContainsAny(meta.Filename, `/\`) ||
+		meta.Filename == "." || meta.Filename == ".." {
+		return fmt.Errorf("%w: filename must not contain a path", ErrInvalidRelease)
+	}
+	return s.releases.checkNewRelease(ctx, meta.ProductName, meta.Platform, meta.Arch, meta.Version)
+}
+
+// finishBuild verifies an uploaded file, stores it as
+// <product>/<platform>/<version>/[<arch>/]<filename> and creates a draft
+// release. The temporary file is removed once the build is stored.
+func (s *BuildUploadService) finishBuild(ctx context.Context, meta *models.BuildMetadata, tempPath string) (*models.Release, error) {
+	if err := checkExecutable(tempPath, meta.Platform, meta.Arch); err != nil {
+		return nil, err
+	}
+
+	size, checksum, err := hashFile(tempPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash upload: %w", err)
+	}
+	if meta.ExpectedSHA256 != "" && !strings.EqualFold(meta.ExpectedSHA256, checksum) {
+		return nil, ErrChecksumMismatch
+	}
+	if err := checkSparkleSize(s.cfg, meta.Platform, size); err != nil {
+		return nil, err
+	}
+
+	// Tell the admin up front when a watermarked product's build will be
+	// served without marks
+	var note string
+	if watermarkedProduct(s.cfg, meta.ProductName) {
+		note, err = checkWatermarkable(tempPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var signature string
+	if meta.Platform == string(models.PlatformMacOS) {
+		if signature, err = signSparkleFile(tempPath, sparkleMaxSize(s.cfg)); err != nil {
+			return nil, err
+		}
+	}
+
+	key := path.Join(meta.ProductName, meta.Platform, meta.Version, string(meta.Arch), meta.Filename)
+	if _, err := s.storage.Stat(ctx, key); err == nil {
+		return nil, fmt.Errorf("%w: %s already exists", ErrInvalidRelease, key)
+	} else if err != storage.ErrNotExist {
+		return nil, fmt.Errorf("failed to check release file: %w", err)
+	}
+
+	file, err := os.Open(tempPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload: %w", err)
+	}
+	err = s.storage.Put(ctx, key, file, size)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to store build: %w", err)
+	}
+	os.Remove(tempPath)
+
+	release := &models.Release{
+		ProductName:   meta.ProductName,
+		Platform:      meta.Platform,
+		Arch:          meta.Arch,
+		Version:       meta.Version,
+		Channel:       meta.Channel,
+		FilePath:      key,
+		Filename:      meta.Filename,
+		Size:          size,
+		SHA256:        checksum,
+		ReleaseNotes:  meta.ReleaseNotes,
+		MinOSVersion:  meta.MinOSVersion,
+		WatermarkNote: note,
+
+		SparkleSignature: signature,
+	}
+	if err := s.releases.insertRelease(ctx, release); err != nil {
+		if err := s.storage.Delete(ctx, key); err != nil {
+			log.Printf("RELEASE ERROR: Failed to delete %s: %v", key, err)
+		}
+		return nil, err
+	}
+	return release, nil
+}
+
+// removeExpiredUploads deletes abandoned uploads and their partial files
+func (s *BuildUploadService) removeExpiredUploads(ctx context.Context) {
+	filter := bson.M{"status": models.BuildUploadUploading, "expires_at": bson.M{"$lte": time.Now()}}
+
+	var expired []models.BuildUpload
+	cursor, err := s.uploadCollection.Find(ctx, filter)
+	if err == nil {
+		err = cursor.All(ctx, &expired)
+	}
+	if err != nil {
+		log.Printf("RELEASE ERROR: Failed to list expired uploads: %v", err)
+		return
+	}
+
+	for _, upload := range expired {
+		os.Remove(s.uploadPath(upload.ID))
+		s.uploadCollection.DeleteOne(ctx, bson.M{"_id": upload.ID})
+	}
+}
+
+// checkExecutable verifies the file is a PE executable for Windows, a
+// Mach-O (thin or universal) binary for macOS or an ELF binary for Linux.
+// The CPU of Mach-O and ELF binaries must match arch; Windows installers
+// are often 32-bit whatever they install, so PE files are not checked.
+func checkExecutable(path, platform string, arch models.Arch) error {
+	switch platform {
+	case string(models.PlatformWindows):
+		if f, err := pe.Open(path); err == nil {
+			f.Close()
+			return nil
+		}
+	case string(models.PlatformMacOS):
+		if f, err := macho.Open(path); err == nil {
+			cpu := f.Cpu
+			f.Close()
+			if arch == "" || arch == machoArch(cpu) {
+				return nil
+			}
+		}
+		if f, err := macho.OpenFat(path); err == nil {
+			defer f.Close()
+			if arch == "" || arch == models.ArchUniversal {
+				return nil
+			}
+			for _, fatArch := range f.Arches {
+				if machoArch(fatArch.Cpu) == arch {
+					return nil
+				}
+			}
+		}
+	case string(models.PlatformLinux):
+		if f, err := elf.Open(path); err == nil {
+			machine := f.Machine
+			f.Close()
+			switch {
+			case arch == "",
+				arch == models.ArchAMD64 && machine == elf.EM_X86_64,
+				arch == models.ArchARM64 && machine == elf.EM_AARCH64:
+				return nil
+			}
+		}
+	}
+	return ErrInvalidExecutable
+}
+
+func machoArch(cpu macho.Cpu) models.Arch {
+	switch cpu {
+	case macho.CpuAmd64:
+		return models.ArchAMD64
+	case macho.CpuArm64:
+		return models.ArchARM64
+	}
+	return ""
+}
+
+func (s *BuildUploadService) tempDir() string {
+	uploadPath := s.cfg.FileUpload.UploadPath
+	if uploadPath == "" {
+		uploadPath = defaultUploadPath
+	}
+	return filepath.Join(uploadPath, "builds")
+}
+
+func (s *BuildUploadService) uploadPath(uploadID primitive.ObjectID) string {
+	return filepath.Join(s.tempDir(), uploadID.Hex()+".part")
+}
+
+func (s *BuildUploadService) chunkSize() int64 {
+	if s.cfg.FileUpload.MaxSize > 0 {
+		return s.cfg.FileUpload.MaxSize
+	}
+	return defaultUploadChunkSize
+}
+
+func (s *BuildUploadService) buildMaxSize() int64 {
+	if s.cfg.FileUpload.BuildMaxSize > 0 {
+		return s.cfg.FileUpload.BuildMaxSize
+	}
+	return defaultBuildMaxSize
+}
+
+func (s *BuildUploadService) uploadExpiration() time.Duration {
+	if s.cfg.FileUpload.UploadExpiration > 0 {
+		return s.cfg.FileUpload.UploadExpiration
+	}
+	return defaultUploadExpiration
+}
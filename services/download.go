@@ -2,29 +2,63 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/sync/singleflight"
 
+	"jinzmedia-atmt/config"
 	"jinzmedia-atmt/database"
+	"jinzmedia-atmt/geoip"
 	"jinzmedia-atmt/models"
+	"jinzmedia-atmt/storage"
+)
+
+// defaultDownloadCountWindow is used when downloads.count_window is not set
+const defaultDownloadCountWindow = time.Hour
+
+// presignExpiration is how long a presigned storage URL stays valid; the
+// client is redirected to it at once
+const presignExpiration = 5 * time.Minute
+
+var (
+	ErrNotOwned       = errors.New("you do not own this product")
+	ErrSerialMismatch = errors.New("serial number does not match")
+	ErrFileNotFound   = errors.New("file not found")
+	// ErrFilePreparing is returned for a watermarked legacy file whose
+	// checksum is still being computed
+	ErrFilePreparing = errors.New("file is being prepared")
 )
 
 type DownloadService struct {
-	userCollection     *mongo.Collection
-	downloadCollection *mongo.Collection
+	userCollection      *mongo.Collection
+	downloadCollection  *mongo.Collection
+	releaseCollection   *mongo.Collection
+	watermarkCollection *mongo.Collection
+	storage             storage.Storage
+	cfg                 *config.Config
 }
 
 func NewDownloadService() *DownloadService {
 	return &DownloadService{
-		userCollection:     database.GetCollection("users"),
-		downloadCollection: database.GetCollection("downloads"),
+		userCollection:      database.GetCollection("users"),
+		downloadCollection:  database.GetCollection("downloads"),
+		releaseCollection:   database.GetCollection("releases"),
+		watermarkCollection: database.GetCollection("watermarked_builds"),
+		storage:             storage.Default(),
+		cfg:                 config.Get(),
 	}
 }
 
@@ -37,7 +71,7 @@ func (ds *DownloadService) GetProductsAndUserInfo(userID primitive.ObjectID) (*m
 	err := ds.userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -51,14 +85,27 @@ func (ds *DownloadService) GetProductsAndUserInfo(userID primitive.ObjectID) (*m
 		SerialNumber: user.SerialNumber,
 	}
 
-	// Get products and check availability based on file existence
+	released, err := ds.releasedPlatforms(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get products and check availability based on published releases or
+	// file existence; storage caches the lookups
 	products := make([]models.Product, 0, len(models.Products))
 	for _, product := range models.Products {
 		// Check if files exist for each platform
 		availablePlatforms := make([]string, 0)
+		architectures := make(map[string][]models.Arch)
 		for _, platform := range product.Platforms {
-			filePath := ds.getProductFilePath(product.Name, platform)
-			if _, err := os.Stat(filePath); err == nil {
+			if archs, ok := released[product.Name+"/"+platform]; ok {
+				availablePlatforms = append(availablePlatforms, platform)
+				if len(archs) > 0 {
+					architectures[platform] = archs
+				}
+				continue
+			}
+			if _, err := ds.storage.Stat(ctx, legacyFileKey(product.Name, platform)); err == nil {
 				availablePlatforms = append(availablePlatforms, platform)
 			}
 		}
@@ -66,109 +113,345 @@ func (ds *DownloadService) GetProductsAndUserInfo(userID primitive.ObjectID) (*m
 		productCopy := product
 		productCopy.Platforms = availablePlatforms
 		productCopy.Available = len(availablePlatforms) > 0
+		if len(architectures) > 0 {
+			productCopy.Architectures = architectures
+		}
 		products = append(products, productCopy)
 	}
 
 	response := &models.ProductsResponse{
-		Products: products,
-		User:     userInfo,
+		Products:  products,
+		User:      userInfo,
+		Platforms: models.Platforms,
 	}
 
 	return response, nil
 }
 
 // ProcessDownloadRequest handles download validation and file serving
-func (ds *DownloadService) ProcessDownloadRequest(userID primitive.ObjectID, productName, platform, serial string, r *http.Request) (*models.DownloadInfo, error) {
+func (ds *DownloadService) ProcessDownloadRequest(userID primitive.ObjectID, productName, platform string, arch models.Arch, serial, version string, channel models.ReleaseChannel, r *http.Request) (*models.DownloadInfo, error) {
 	ctx := context.Background()
 
-	// Get user information
+	user, err := ds.checkDownloadAccess(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate serial number
+	if user.SerialNumber != serial {
+		return nil, ErrSerialMismatch
+	}
+
+	downloadInfo, err := ds.getDownloadInfo(ctx, productName, platform, arch, version, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	ds.fileChecksum(downloadInfo)
+
+	// Refuse an exceeded quota before any watermarked copy is made
+	newDownload, err := ds.checkNewDownload(ctx, userID, productName, platform, downloadInfo, r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Serve the user's own copy of watermarked products
+	if _, err := ds.watermarkDownload(ctx, user, productName, platform, downloadInfo); err != nil {
+		return nil, err
+	}
+
+	if newDownload {
+		ds.recordDownload(userID, productName, platform, serial, downloadInfo, r)
+	}
+
+	return downloadInfo, nil
+}
+
+// checkDownloadAccess returns the user if they may download products
+func (ds *DownloadService) checkDownloadAccess(ctx context.Context, userID primitive.ObjectID) (*models.User, error) {
 	var user models.User
 	err := ds.userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
+	if user.DeletedAt != nil {
+		return nil, ErrUserNotFound
+	}
+
 	// Check if user is banned
 	if user.IsBanned {
-		return nil, fmt.Errorf("user is banned")
+		return nil, ErrUserBanned
 	}
 
 	// Check if user owns the product
 	if !user.Owned {
-		return nil, fmt.Errorf("you do not own this product")
+		return nil, ErrNotOwned
 	}
 
-	// Validate serial number
-	if user.SerialNumber != serial {
-		return nil, fmt.Errorf("serial number does not match")
+	return &user, nil
+}
+
+// getDownloadInfo locates the file served for a product and platform: the
+// requested version, else the newest release in the channel, in the build
+// that best matches the client's architecture (empty if unknown). Products
+// without published releases fall back to the single legacy file.
+func (ds *DownloadService) getDownloadInfo(ctx context.Context, productName, platform string, arch models.Arch, version string, channel models.ReleaseChannel) (*models.DownloadInfo, error) {
+	release, err := findPublishedRelease(ctx, ds.releaseCollection, productName, platform, archPreference(platform, arch), version, channel)
+	if err != nil {
+		return nil, err
+	}
+	if release == nil {
+		return ds.getLegacyDownloadInfo(ctx, productName, platform)
 	}
+	return ds.getReleaseDownloadInfo(ctx, release)
+}
 
-	// Get file path
-	filePath := ds.getProductFilePath(productName, platform)
-	
-	// Check if file exists
-	fileInfo, err := os.Stat(filePath)
+// getReleaseDownloadInfo locates the file of a release
+func (ds *DownloadService) getReleaseDownloadInfo(ctx context.Context, release *models.Release) (*models.DownloadInfo, error) {
+	fileInfo, err := ds.statFile(ctx, release.FilePath)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("file not found")
-		}
-		return nil, fmt.Errorf("failed to access file: %w", err)
+		return nil, err
 	}
 
-	// Log download
-	err = ds.logDownload(userID, productName, platform, serial, r)
+	return &models.DownloadInfo{
+		Key:      fileInfo.Key,
+		Filename: release.Filename,
+		Size:     fileInfo.Size,
+		ModTime:  fileInfo.ModTime,
+		Version:  release.Version,
+		Arch:     release.Arch,
+		SHA256:   release.SHA256,
+	}, nil
+}
+
+// getLegacyDownloadInfo locates the unversioned file of a product
+func (ds *DownloadService) getLegacyDownloadInfo(ctx context.Context, productName, platform string) (*models.DownloadInfo, error) {
+	fileInfo, err := ds.statFile(ctx, legacyFileKey(productName, platform))
 	if err != nil {
-		// Don't fail the download if logging fails, just log the error
-		fmt.Printf("Failed to log download: %v\n", err)
+		return nil, err
 	}
 
 	// Prepare download info
-	filename := fmt.Sprintf("%s", filepath.Base(filePath))
-	if platform == "windows" && filepath.Ext(filename) == "" {
-		filename += ".exe"
-	}
+	filename := platformFilename(platform, path.Base(fileInfo.Key))
 
 	return &models.DownloadInfo{
-		FilePath: filePath,
+		Key:      fileInfo.Key,
 		Filename: filename,
-		Size:     fileInfo.Size(),
+		Size:     fileInfo.Size,
+		ModTime:  fileInfo.ModTime,
 	}, nil
 }
 
-// getProductFilePath returns the file path for a product and platform
-func (ds *DownloadService) getProductFilePath(productName, platform string) string {
-	baseDir := "dist"
-	return filepath.Join(baseDir, productName, platform, productName)
+// statFile looks up a product file in storage
+func (ds *DownloadService) statFile(ctx context.Context, key string) (*storage.ObjectInfo, error) {
+	fileInfo, err := ds.storage.Stat(ctx, key)
+	if err != nil {
+		if err == storage.ErrNotExist {
+			return nil, ErrFileNotFound
+		}
+		return nil, fmt.Errorf("failed to access file: %w", err)
+	}
+	return fileInfo, nil
+}
+
+// OpenFile returns a seekable reader over a product file for serving with
+// http.ServeContent
+func (ds *DownloadService) OpenFile(ctx context.Context, downloadInfo *models.DownloadInfo) *storage.Reader {
+	return storage.NewReader(ctx, ds.storage, downloadInfo.Key, downloadInfo.Size)
 }
 
-// logDownload records a download in the database
-func (ds *DownloadService) logDownload(userID primitive.ObjectID, productName, platform, serial string, r *http.Request) error {
-	ctx := context.Background()
+// PresignFile returns a URL that downloads a product file straight from
+// storage, or "" when files are served by the API
+func (ds *DownloadService) PresignFile(ctx context.Context, downloadInfo *models.DownloadInfo) (string, error) {
+	if !ds.cfg.Storage.S3.PresignDownloads {
+		return "", nil
+	}
+
+	url, err := ds.storage.Presign(ctx, downloadInfo.Key, downloadInfo.Filename, presignExpiration)
+	if err == storage.ErrPresignUnsupported {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to presign file: %w", err)
+	}
+	return url, nil
+}
+
+// legacyFileKey returns the storage key of the unversioned file of a
+// product and platform
+func legacyFileKey(productName, platform string) string {
+	return path.Join(productName, platform, productName)
+}
+
+var (
+	// legacyChecksums caches the SHA-256 of legacy files, which have no
+	// recorded checksum, by storage key and file version
+	legacyChecksums sync.Map
+	// legacyHashing hashes each legacy file version once, however many
+	// requests ask for it
+	legacyHashing singleflight.Group
+)
+
+// fileChecksum fills in the SHA-256 of a legacy file when it is known.
+// Legacy files are hashed in the background, at startup and when a new
+// version is first requested; until then they are served without a
+// checksum or strong ETag rather than making the request wait.
+func (ds *DownloadService) fileChecksum(downloadInfo *models.DownloadInfo) {
+	if downloadInfo.SHA256 != "" {
+		return
+	}
+
+	cacheKey := downloadInfo.Key + "\n" + fileVersion(downloadInfo)
+	if checksum, ok := legacyChecksums.Load(cacheKey); ok {
+		downloadInfo.SHA256 = checksum.(string)
+		return
+	}
+	go ds.hashLegacyFile(downloadInfo.Key, cacheKey)
+}
+
+// hashLegacyFile computes and caches the SHA-256 of a legacy file version
+func (ds *DownloadService) hashLegacyFile(key, cacheKey string) {
+	legacyHashing.Do(cacheKey, func() (interface{}, error) {
+		if checksum, ok := legacyChecksums.Load(cacheKey); ok {
+			return checksum, nil
+		}
+		_, checksum, err := hashObject(context.Background(), ds.storage, key)
+		if err != nil {
+			if err != storage.ErrNotExist {
+				log.Printf("DOWNLOAD ERROR: Failed to hash %s: %v", key, err)
+			}
+			return nil, err
+		}
+		legacyChecksums.Store(cacheKey, checksum)
+		return checksum, nil
+	})
+}
+
+// HashLegacyFiles computes the checksums of the legacy files in storage so
+// their downloads carry one. It is meant to run in the background at
+// startup.
+func (ds *DownloadService) HashLegacyFiles(ctx context.Context) {
+	for _, product := range models.Products {
+		for _, platform := range product.Platforms {
+			fileInfo, err := ds.storage.Stat(ctx, legacyFileKey(product.Name, platform))
+			if err != nil {
+				continue
+			}
+			downloadInfo := &models.DownloadInfo{Key: fileInfo.Key, Size: fileInfo.Size, ModTime: fileInfo.ModTime}
+			ds.hashLegacyFile(fileInfo.Key, fileInfo.Key+"\n"+fileVersion(downloadInfo))
+		}
+	}
+}
+
+// checkNewDownload reports whether a request starts a new download, which
+// is recorded once the file is ready to serve. A GET for a file the user
+// already downloaded from the same IP address within
+// downloads.count_window is a restart or a resumed range and is not
+// recorded again. Any other GET, including a range request, is a new
+// download and counts against the quota, so a range covering the whole
+// file cannot bypass it. It returns an error only when a new download
+// exceeds the quota.
+func (ds *DownloadService) checkNewDownload(ctx context.Context, userID primitive.ObjectID, productName, platform string, downloadInfo *models.DownloadInfo, r *http.Request) (bool, error) {
+	if r.Method != http.MethodGet {
+		return false, nil
+	}
+
+	ip := requestIP(r)
 
-	// Get client IP
-	clientIP := r.Header.Get("X-Forwarded-For")
-	if clientIP == "" {
-		clientIP = r.Header.Get("X-Real-IP")
+	// Legacy downloads are stored without a version or architecture; null
+	// matches them. Only repeats from the same address are folded, so
+	// downloading from another IP address still goes through the quota check.
+	var version, arch interface{}
+	if downloadInfo.Version != "" {
+		version = downloadInfo.Version
 	}
-	if clientIP == "" {
-		clientIP = r.RemoteAddr
+	if downloadInfo.Arch != "" {
+		arch = downloadInfo.Arch
 	}
+	recent, err := ds.downloadCollection.CountDocuments(ctx, bson.M{
+		"user_id":       userID,
+		"product_name":  productName,
+		"platform":      platform,
+		"version":       version,
+		"arch":          arch,
+		"ip_address":    ip,
+		"downloaded_at": bson.M{"$gte": time.Now().Add(-ds.countWindow())},
+	}, options.Count().SetLimit(1))
+	if err != nil {
+		log.Printf("DOWNLOAD ERROR: Failed to check recent downloads: %v", err)
+	} else if recent > 0 {
+		return false, nil
+	}
+
+	if err := ds.checkDownloadQuota(ctx, userID, productName, ip); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// recordDownload logs a new download; logging failures never fail the
+// download
+func (ds *DownloadService) recordDownload(userID primitive.ObjectID, productName, platform, serial string, downloadInfo *models.DownloadInfo, r *http.Request) {
+	if err := ds.logDownload(userID, productName, platform, downloadInfo, serial, r); err != nil {
+		log.Printf("DOWNLOAD ERROR: %v", err)
+	}
+}
+
+// requestIP returns the client address without port. The RealIP middleware
+// has already replaced RemoteAddr with the forwarded address when the
+// request came through a trusted proxy.
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (ds *DownloadService) countWindow() time.Duration {
+	if ds.cfg.Downloads.CountWindow > 0 {
+		return ds.cfg.Downloads.CountWindow
+	}
+	return defaultDownloadCountWindow
+}
+
+// logDownload records a download in the database
+func (ds *DownloadService) logDownload(userID primitive.ObjectID, productName, platform string, downloadInfo *models.DownloadInfo, serial string, r *http.Request) error {
+	ctx := context.Background()
+
+	// Get client IP; quotas count distinct addresses, so use the single
+	// address RealIP resolved rather than the raw forwarding headers
+	clientIP := requestIP(r)
 
 	// Get user agent
 	userAgent := r.Header.Get("User-Agent")
 
+	// Country as reported by the CDN, when configured, else from GeoIP
+	location := geoip.Lookup(clientIP)
+	if ds.cfg.Downloads.CountryHeader != "" {
+		if country := strings.ToUpper(strings.TrimSpace(r.Header.Get(ds.cfg.Downloads.CountryHeader))); country != "" {
+			location.Country = country
+		}
+	}
+
 	// Create download record
 	downloadRecord := models.DownloadRecord{
 		ID:           primitive.NewObjectID(),
 		UserID:       userID,
 		ProductName:  productName,
 		Platform:     platform,
+		Version:      downloadInfo.Version,
+		Arch:         downloadInfo.Arch,
 		SerialNumber: serial,
 		IPAddress:    clientIP,
 		UserAgent:    userAgent,
+		Country:      location.Country,
+		ASN:          location.ASN,
+		ASOrg:        location.ASOrganization,
 		DownloadedAt: time.Now(),
 	}
 
@@ -181,6 +464,34 @@ func (ds *DownloadService) logDownload(userID primitive.ObjectID, productName, p
 	return nil
 }
 
+// releasedPlatforms returns the product/platform pairs with a published
+// stable release, with the architectures of their builds
+func (ds *DownloadService) releasedPlatforms(ctx context.Context) (map[string][]models.Arch, error) {
+	cursor, err := ds.releaseCollection.Find(ctx,
+		bson.M{"published": true, "channel": models.ReleaseChannelStable},
+		options.Find().SetProjection(bson.M{"product_name": 1, "platform": 1, "arch": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list releases: %w", err)
+	}
+
+	var releases []models.Release
+	if err := cursor.All(ctx, &releases); err != nil {
+		return nil, fmt.Errorf("failed to decode releases: %w", err)
+	}
+
+	released := make(map[string][]models.Arch, len(releases))
+	for _, release := range releases {
+		key := release.ProductName + "/" + release.Platform
+		archs := released[key]
+		if release.Arch != "" && !slices.Contains(archs, release.Arch) {
+			archs = append(archs, release.Arch)
+		}
+		released[key] = archs
+	}
+	return released, nil
+}
+
 // GetUserDownloadHistory returns download history for a user
 func (ds *DownloadService) GetUserDownloadHistory(userID primitive.ObjectID) ([]*models.DownloadRecord, error) {
 	ctx := context.Background()
@@ -207,47 +518,3 @@ func (ds *DownloadService) GetUserDownloadHistory(userID primitive.ObjectID) ([]
 
 	return downloads, nil
 }
-
-// GetDownloadStats returns download statistics (for admin use)
-func (ds *DownloadService) GetDownloadStats() (map[string]interface{}, error) {
-	ctx := context.Background()
-
-	// Count total downloads
-	totalDownloads, err := ds.downloadCollection.CountDocuments(ctx, bson.M{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to count downloads: %w", err)
-	}
-
-	// Count downloads by product
-	pipeline := []bson.M{
-		{"$group": bson.M{
-			"_id":   "$product_name",
-			"count": bson.M{"$sum": 1},
-		}},
-	}
-
-	cursor, err := ds.downloadCollection.Aggregate(ctx, pipeline)
-	if err != nil {
-		return nil, fmt.Errorf("failed to aggregate downloads: %w", err)
-	}
-	defer cursor.Close(ctx)
-
-	productStats := make(map[string]int)
-	for cursor.Next(ctx) {
-		var result struct {
-			ID    string `bson:"_id"`
-			Count int    `bson:"count"`
-		}
-		if err := cursor.Decode(&result); err != nil {
-			continue
-		}
-		productStats[result.ID] = result.Count
-	}
-
-	stats := map[string]interface{}{
-		"total_downloads": totalDownloads,
-		"by_product":      productStats,
-	}
-
-	return stats, nil
-}
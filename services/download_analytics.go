@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"jinzmedia-atmt/models"
+)
+
+// topNetworks bounds the networks listed in download analytics
+const topNetworks = 50
+
+// GetDownloadStats returns download analytics for the period selected by
+// params: downloads over time and by product, platform, version, country
+// and network, and how many came from new, returning and repeat downloaders
+func (ds *DownloadService) GetDownloadStats(ctx context.Context, params *models.AnalyticsParams) (*models.DownloadAnalytics, error) {
+	startDate, endDate, err := analyticsRange(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// All-time totals and the users whose first download falls in the period
+	var overall struct {
+		Totals []models.DownloadOverall `bson:"totals"`
+		New    []struct {
+			Count int64 `bson:"count"`
+		} `bson:"new"`
+	}
+	if err := ds.aggregateOne(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": "$user_id", "n": bson.M{"$sum": 1}, "first": bson.M{"$min": "$downloaded_at"}}}},
+		{{Key: "$facet", Value: bson.M{
+			"totals": bson.A{
+				bson.M{"$group": bson.M{"_id": nil, "count": bson.M{"$sum": "$n"}, "unique": bson.M{"$sum": 1}}},
+			},
+			"new": bson.A{
+				bson.M{"$match": bson.M{"first": bson.M{"$gte": startDate, "$lt": endDate}}},
+				bson.M{"$count": "count"},
+			},
+		}}},
+	}, &overall); err != nil {
+		return nil, err
+	}
+
+	var period struct {
+		Totals     []models.DownloadOverall   `bson:"totals"`
+		Daily      []models.DailyDownload     `bson:"daily"`
+		ByProduct  []models.DownloadBreakdown `bson:"by_product"`
+		ByPlatform []models.DownloadBreakdown `bson:"by_platform"`
+		ByVersion  []models.VersionBreakdown  `bson:"by_version"`
+		ByCountry  []models.DownloadBreakdown `bson:"by_country"`
+		ByASN      []models.ASNBreakdown      `bson:"by_asn"`
+	}
+	if err := ds.aggregateOne(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"downloaded_at": bson.M{"$gte": startDate, "$lt": endDate}}}},
+		{{Key: "$facet", Value: bson.M{
+			"totals": breakdownStages(nil, nil, 0),
+			"daily": breakdownStages(
+				bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$downloaded_at"}},
+				bson.D{{Key: "_id", Value: 1}}, 0),
+			"by_product":  breakdownStages("$product_name", nil, 0),
+			"by_platform": breakdownStages("$platform", nil, 0),
+			"by_version": append(breakdownStages(
+				bson.M{"product": "$product_name", "platform": "$platform", "version": bson.M{"$ifNull": bson.A{"$version", ""}}},
+				nil, 0),
+				bson.M{"$project": bson.M{"_id": 0, "product": "$_id.product", "platform": "$_id.platform", "version": "$_id.version", "count": 1, "unique": 1}}),
+			"by_country": breakdownStages(bson.M{"$ifNull": bson.A{"$country", "unknown"}}, nil, 0),
+			"by_asn": append(breakdownStages(
+				bson.M{"asn": bson.M{"$ifNull": bson.A{"$asn", 0}}, "organization": bson.M{"$ifNull": bson.A{"$as_org", ""}}},
+				nil, topNetworks),
+				bson.M{"$project": bson.M{"_id": 0, "asn": "$_id.asn", "organization": "$_id.organization", "count": 1, "unique": 1}}),
+		}}},
+	}, &period); err != nil {
+		return nil, err
+	}
+
+	analytics := &models.DownloadAnalytics{
+		DailyDownloads: emptyIfNil(period.Daily),
+		ByProduct:      emptyIfNil(period.ByProduct),
+		ByPlatform:     emptyIfNil(period.ByPlatform),
+		ByVersion:      emptyIfNil(period.ByVersion),
+		ByCountry:      emptyIfNil(period.ByCountry),
+		ByASN:          emptyIfNil(period.ByASN),
+	}
+	if len(overall.Totals) > 0 {
+		analytics.Overall = overall.Totals[0]
+	}
+	if len(period.Totals) > 0 {
+		analytics.Period.TotalDownloads = period.Totals[0].TotalDownloads
+		analytics.Period.UniqueDownloaders = period.Totals[0].UniqueDownloaders
+	}
+	if len(overall.New) > 0 {
+		analytics.Period.NewDownloaders = overall.New[0].Count
+	}
+	analytics.Period.ReturningDownloaders = analytics.Period.UniqueDownloaders - analytics.Period.NewDownloaders
+	analytics.Period.RepeatDownloads = analytics.Period.TotalDownloads - analytics.Period.UniqueDownloaders
+
+	return analytics, nil
+}
+
+// GetConversionStats follows the users who registered in the period
+// selected by params to their first payment and first download
+func (ds *DownloadService) GetConversionStats(ctx context.Context, params *models.AnalyticsParams) (*models.ConversionAnalytics, error) {
+	startDate, endDate, err := analyticsRange(params)
+	if err != nil {
+		return nil, err
+	}
+
+	const hour = 3600 * 1000 // milliseconds
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"created_at": bson.M{"$gte": startDate, "$lt": endDate},
+			"role":       bson.M{"$nin": bson.A{models.RoleAdmin, models.RoleSuper}},
+		}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": "payments",
+			"let":  bson.M{"user_id": "$_id"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$user_id", "$$user_id"}}, "status": models.PaymentStatusProcessed}},
+				bson.M{"$group": bson.M{"_id": nil, "at": bson.M{"$min": bson.M{"$ifNull": bson.A{"$processed_at", "$created_at"}}}}},
+			},
+			"as": "payment",
+		}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": "downloads",
+			"let":  bson.M{"user_id": "$_id"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$user_id", "$$user_id"}}}},
+				bson.M{"$group": bson.M{"_id": nil, "at": bson.M{"$min": "$downloaded_at"}}},
+			},
+			"as": "download",
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"day":         bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$created_at"}},
+			"created_at":  1,
+			"paid_at":     bson.M{"$arrayElemAt": bson.A{"$payment.at", 0}},
+			"download_at": bson.M{"$arrayElemAt": bson.A{"$download.at", 0}},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":        "$day",
+			"registered": bson.M{"$sum": 1},
+			"paid":       bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$ifNull": bson.A{"$paid_at", false}}, 1, 0}}},
+			"downloaded": bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$ifNull": bson.A{"$download_at", false}}, 1, 0}}},
+			"payment_hours": bson.M{"$sum": bson.M{"$divide": bson.A{
+				bson.M{"$ifNull": bson.A{bson.M{"$subtract": bson.A{"$paid_at", "$created_at"}}, 0}}, hour}}},
+			"download_hours": bson.M{"$sum": bson.M{"$divide": bson.A{
+				bson.M{"$ifNull": bson.A{bson.M{"$subtract": bson.A{"$download_at", "$created_at"}}, 0}}, hour}}},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+
+	cursor, err := ds.userCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate users: %w", err)
+	}
+	var days []struct {
+		models.DailyConversion `bson:",inline"`
+		PaymentHours           float64 `bson:"payment_hours"`
+		DownloadHours          float64 `bson:"download_hours"`
+	}
+	if err := cursor.All(ctx, &days); err != nil {
+		return nil, fmt.Errorf("failed to decode conversion: %w", err)
+	}
+
+	analytics := &models.ConversionAnalytics{DailyConversion: make([]models.DailyConversion, 0, len(days))}
+	var paymentHours, downloadHours float64
+	for _, day := range days {
+		analytics.DailyConversion = append(analytics.DailyConversion, day.DailyConversion)
+		analytics.Period.Registered += day.Registered
+		analytics.Period.Paid += day.Paid
+		analytics.Period.Downloaded += day.Downloaded
+		paymentHours += day.PaymentHours
+		downloadHours += day.DownloadHours
+	}
+
+	p := &analytics.Period
+	p.PaymentRate = ratio(float64(p.Paid), p.Registered)
+	p.DownloadRate = ratio(float64(p.Downloaded), p.Paid)
+	p.AvgHoursToPayment = ratio(paymentHours, p.Paid)
+	p.AvgHoursToFirstDownload = ratio(downloadHours, p.Downloaded)
+	return analytics, nil
+}
+
+// breakdownStages groups downloads by key, counting downloads and distinct
+// users, sorted by sort or else by count. limit > 0 keeps the top groups.
+func breakdownStages(key interface{}, sort bson.D, limit int) bson.A {
+	if sort == nil {
+		sort = bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}
+	}
+	stages := bson.A{
+		bson.M{"$group": bson.M{"_id": bson.M{"key": key, "user": "$user_id"}, "n": bson.M{"$sum": 1}}},
+		bson.M{"$group": bson.M{"_id": "$_id.key", "count": bson.M{"$sum": "$n"}, "unique": bson.M{"$sum": 1}}},
+		bson.M{"$sort": sort},
+	}
+	if limit > 0 {
+		stages = append(stages, bson.M{"$limit": limit})
+	}
+	return stages
+}
+
+// aggregateOne runs a download pipeline that produces a single document
+func (ds *DownloadService) aggregateOne(ctx context.Context, pipeline mongo.Pipeline, result interface{}) error {
+	cursor, err := ds.downloadCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return fmt.Errorf("failed to aggregate downloads: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(result); err != nil {
+			return fmt.Errorf("failed to decode downloads: %w", err)
+		}
+	}
+	return cursor.Err()
+}
+
+func emptyIfNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
+
+func ratio(n float64, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return n / float64(total)
+}
@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"jinzmedia-atmt/models"
+)
+
+var (
+	ErrDownloadLinksDisabled = errors.New("download link secret is not configured")
+	ErrDownloadLinkInvalid   = errors.New("download link is invalid or has expired")
+)
+
+// defaultDownloadLinkExpiration is used when downloads.link_expiration is not set
+const defaultDownloadLinkExpiration = 15 * time.Minute
+
+// DownloadLink identifies the file a signed link grants access to
+type DownloadLink struct {
+	UserID    primitive.ObjectID
+	Product   string
+	Platform  string
+	Release   string      // release version; empty for the legacy unversioned file
+	Arch      models.Arch // architecture of the release build
+	Version   string      // changes whenever the file is replaced, so old links stop working
+	ExpiresAt time.Time
+	Signature string
+}
+
+// CreateDownloadLink runs the same checks as ProcessDownloadRequest and
+// returns a signed URL for the selected release. The serial number stays out
+// of the URL; the download is recorded when the link is used.
+func (ds *DownloadService) CreateDownloadLink(ctx context.Context, userID primitive.ObjectID, productName, platform string, arch models.Arch, req *models.DownloadLinkRequest) (*models.DownloadLinkResponse, error) {
+	secret, err := ds.linkSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := ds.checkDownloadAccess(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.SerialNumber != req.SerialNumber {
+		return nil, ErrSerialMismatch
+	}
+
+	downloadInfo, err := ds.getDownloadInfo(ctx, productName, platform, arch, req.Version, req.Channel)
+	if err != nil {
+		return nil, err
+	}
+
+	return ds.signedLink(secret, user.ID, productName, platform, downloadInfo), nil
+}
+
+// signedLink returns a link to the file described by downloadInfo
+func (ds *DownloadService) signedLink(secret []byte, userID primitive.ObjectID, productName, platform string, downloadInfo *models.DownloadInfo) *models.DownloadLinkResponse {
+	link := &DownloadLink{
+		UserID:    userID,
+		Product:   productName,
+		Platform:  platform,
+		Release:   downloadInfo.Version,
+		Arch:      downloadInfo.Arch,
+		Version:   fileVersion(downloadInfo),
+		ExpiresAt: time.Now().Add(ds.linkExpiration()).Truncate(time.Second),
+	}
+	link.Signature = signDownloadLink(secret, link)
+
+	query := url.Values{}
+	query.Set("uid", link.UserID.Hex())
+	if link.Release != "" {
+		query.Set("release", link.Release)
+	}
+	if link.Arch != "" {
+		query.Set("arch", string(link.Arch))
+	}
+	query.Set("v", link.Version)
+	query.Set("exp", strconv.FormatInt(link.ExpiresAt.Unix(), 10))
+	query.Set("sig", link.Signature)
+
+	return &models.DownloadLinkResponse{
+		URL: fmt.Sprintf("%s/api/v1/download/signed/%s/%s?%s",
+			strings.TrimRight(ds.cfg.Downloads.BaseURL, "/"),
+			url.PathEscape(productName), url.PathEscape(platform), query.Encode()),
+		Filename:  downloadInfo.Filename,
+		Version:   downloadInfo.Version,
+		Arch:      downloadInfo.Arch,
+		Size:      downloadInfo.Size,
+		ExpiresAt: link.ExpiresAt,
+	}
+}
+
+// OpenDownloadLink validates a signed link and returns the file to serve.
+// The user must still be allowed to download and the file must not have
+// changed since the link was issued.
+func (ds *DownloadService) OpenDownloadLink(ctx context.Context, link *DownloadLink, r *http.Request) (*models.DownloadInfo, error) {
+	secret, err := ds.linkSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	expected := signDownloadLink(secret, link)
+	if !hmac.Equal([]byte(expected), []byte(link.Signature)) || !time.Now().Before(link.ExpiresAt) {
+		return nil, ErrDownloadLinkInvalid
+	}
+
+	user, err := ds.checkDownloadAccess(ctx, link.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var downloadInfo *models.DownloadInfo
+	if link.Release == "" {
+		downloadInfo, err = ds.getLegacyDownloadInfo(ctx, link.Product, link.Platform)
+	} else {
+		// A release withdrawn since the link was issued is no longer served
+		var release *models.Release
+		release, err = findPublishedRelease(ctx, ds.releaseCollection, link.Product, link.Platform, []models.Arch{link.Arch}, link.Release, "")
+		if err == ErrReleaseNotFound {
+			err = ErrDownloadLinkInvalid
+		}
+		if err == nil {
+			downloadInfo, err = ds.getReleaseDownloadInfo(ctx, release)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if fileVersion(downloadInfo) != link.Version {
+		return nil, ErrDownloadLinkInvalid
+	}
+
+	ds.fileChecksum(downloadInfo)
+	newDownload, err := ds.checkNewDownload(ctx, user.ID, link.Product, link.Platform, downloadInfo, r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ds.watermarkDownload(ctx, user, link.Product, link.Platform, downloadInfo); err != nil {
+		return nil, err
+	}
+	if newDownload {
+		ds.recordDownload(user.ID, link.Product, link.Platform, user.SerialNumber, downloadInfo, r)
+	}
+
+	return downloadInfo, nil
+}
+
+// signDownloadLink returns the HMAC-SHA256 of the link fields
+func signDownloadLink(secret []byte, link *DownloadLink) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "v2\n%s\n%s\n%s\n%s\n%s\n%s\n%d",
+		link.UserID.Hex(), link.Product, link.Platform, link.Release, link.Arch, link.Version, link.ExpiresAt.Unix())
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// fileVersion identifies the current contents of a product file
+func fileVersion(info *models.DownloadInfo) string {
+	return strconv.FormatInt(info.ModTime.Unix(), 36) + "-" + strconv.FormatInt(info.Size, 36)
+}
+
+func (ds *DownloadService) linkSecret() ([]byte, error) {
+	if ds.cfg.Downloads.LinkSecret == "" {
+		return nil, ErrDownloadLinksDisabled
+	}
+	return []byte(ds.cfg.Downloads.LinkSecret), nil
+}
+
+func (ds *DownloadService) linkExpiration() time.Duration {
+	if ds.cfg.Downloads.LinkExpiration > 0 {
+		return ds.cfg.Downloads.LinkExpiration
+	}
+	return defaultDownloadLinkExpiration
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"jinzmedia-atmt/models"
+)
+
+var (
+	ErrDownloadQuotaExceeded = errors.New("daily download limit reached")
+	ErrDownloadIPLimit       = errors.New("too many IP addresses downloading with this account")
+)
+
+// Quota windows and defaults when downloads.* is not configured
+const (
+	downloadQuotaWindow   = 24 * time.Hour
+	downloadIPQuotaWindow = 7 * 24 * time.Hour
+
+	defaultDownloadDailyLimit    = 10
+	defaultDownloadWeeklyIPLimit = 10
+
+	defaultAbuseIPThreshold       = 5
+	defaultAbuseCountryThreshold  = 3
+	defaultAbuseDownloadThreshold = 30
+)
+
+// defaultScriptedUserAgents is used when downloads.abuse.scripted_user_agents
+// is not set
+var defaultScriptedUserAgents = []string{"curl", "wget", "python", "go-http-client", "java/", "okhttp", "libwww-perl", "powershell", "aria2", "axel", "httpie", "node-fetch", "axios", "scrapy", "headless"}
+
+// checkDownloadQuota rejects a new download that would exceed the user's
+// daily downloads of the product or the distinct IP addresses allowed per
+// week. Database failures are logged and do not block the download.
+func (ds *DownloadService) checkDownloadQuota(ctx context.Context, userID primitive.ObjectID, productName, ip string) error {
+	now := time.Now()
+
+	if limit := ds.dailyLimit(); limit > 0 {
+		count, err := ds.downloadCollection.CountDocuments(ctx, bson.M{
+			"user_id":       userID,
+			"product_name":  productName,
+			"downloaded_at": bson.M{"$gte": now.Add(-downloadQuotaWindow)},
+		})
+		if err != nil {
+			log.Printf("DOWNLOAD ERROR: Failed to check download quota of user %s: %v", userID.Hex(), err)
+			return nil
+		}
+		if count >= int64(limit) {
+			log.Printf("DOWNLOAD ALERT: User %s reached the daily limit of %d downloads of %s", userID.Hex(), limit, productName)
+			return ErrDownloadQuotaExceeded
+		}
+	}
+
+	if limit := ds.weeklyIPLimit(); limit > 0 {
+		ips, err := ds.downloadCollection.Distinct(ctx, "ip_address", bson.M{
+			"user_id":       userID,
+			"downloaded_at": bson.M{"$gte": now.Add(-downloadIPQuotaWindow)},
+		})
+		if err != nil {
+			log.Printf("DOWNLOAD ERROR: Failed to check download IPs of user %s: %v", userID.Hex(), err)
+			return nil
+		}
+		if len(ips) < limit {
+			return nil
+		}
+		for _, seen := range ips {
+			if seen == ip {
+				return nil
+			}
+		}
+		log.Printf("DOWNLOAD ALERT: User %s blocked downloading from %s after %d IP addresses this week", userID.Hex(), ip, len(ips))
+		return ErrDownloadIPLimit
+	}
+
+	return nil
+}
+
+// DownloadAbuseReport lists accounts whose downloads since the given time
+// came from many IP addresses or countries, were unusually frequent, or
+// were made by scripts. Accounts with the most downloads come first.
+func (ds *DownloadService) DownloadAbuseReport(ctx context.Context, since time.Time, limit int64) ([]*models.DownloadAbuseEntry, error) {
+	ipThreshold := ds.abuseIPThreshold()
+	countryThreshold := ds.abuseCountryThreshold()
+	downloadThreshold := ds.abuseDownloadThreshold()
+	scripted := ds.scriptedUserAgents()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"downloaded_at": bson.M{"$gte": since}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":               "$user_id",
+			"downloads":         bson.M{"$sum": 1},
+			"ip_addresses":      bson.M{"$addToSet": "$ip_address"},
+			"countries":         bson.M{"$addToSet": "$country"},
+			"user_agents":       bson.M{"$addToSet": "$user_agent"},
+			"first_download_at": bson.M{"$min": "$downloaded_at"},
+			"last_download_at":  bson.M{"$max": "$downloaded_at"},
+		}}},
+		{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"$expr": bson.M{"$gte": bson.A{bson.M{"$size": "$ip_addresses"}, ipThreshold}}},
+			bson.M{"$expr": bson.M{"$gte": bson.A{bson.M{"$size": "$countries"}, countryThreshold}}},
+			bson.M{"downloads": bson.M{"$gte": downloadThreshold}},
+			bson.M{"user_agents": primitive.Regex{Pattern: userAgentPattern(scripted), Options: "i"}},
+			bson.M{"user_agents": ""},
+		}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "downloads", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "users",
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "user",
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"email":     bson.M{"$arrayElemAt": bson.A{"$user.email", 0}},
+			"is_banned": bson.M{"$arrayElemAt": bson.A{"$user.is_banned", 0}},
+		}}},
+		{{Key: "$unset", Value: "user"}},
+	}
+
+	cursor, err := ds.downloadCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate downloads: %w", err)
+	}
+	entries := make([]*models.DownloadAbuseEntry, 0)
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode downloads: %w", err)
+	}
+
+	for _, entry := range entries {
+		for _, userAgent := range entry.UserAgents {
+			if isScriptedUserAgent(userAgent, scripted) {
+				entry.ScriptedAgents = append(entry.ScriptedAgents, userAgent)
+			}
+		}
+
+		entry.Reasons = make([]string, 0, 4)
+		if len(entry.IPAddresses) >= ipThreshold {
+			entry.Reasons = append(entry.Reasons, models.AbuseManyIPs)
+		}
+		if len(entry.Countries) >= countryThreshold {
+			entry.Reasons = append(entry.Reasons, models.AbuseManyCountries)
+		}
+		if entry.Downloads >= downloadThreshold {
+			entry.Reasons = append(entry.Reasons, models.AbuseManyDownloads)
+		}
+		if len(entry.ScriptedAgents) > 0 {
+			entry.Reasons = append(entry.Reasons, models.AbuseScriptedAgent)
+		}
+	}
+	return entries, nil
+}
+
+// userAgentPattern matches user agents containing any of the substrings
+func userAgentPattern(substrings []string) string {
+	quoted := make([]string, len(substrings))
+	for i, s := range substrings {
+		quoted[i] = regexp.QuoteMeta(s)
+	}
+	return strings.Join(quoted, "|")
+}
+
+func isScriptedUserAgent(userAgent string, scripted []string) bool {
+	userAgent = strings.ToLower(userAgent)
+	if userAgent == "" {
+		return true
+	}
+	for _, s := range scripted {
+		if strings.Contains(userAgent, strings.ToLower(s)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ds *DownloadService) dailyLimit() int {
+	if ds.cfg.Downloads.DailyLimit != 0 {
+		return ds.cfg.Downloads.DailyLimit
+	}
+	return defaultDownloadDailyLimit
+}
+
+func (ds *DownloadService) weeklyIPLimit() int {
+	if ds.cfg.Downloads.WeeklyIPLimit != 0 {
+		return ds.cfg.Downloads.WeeklyIPLimit
+	}
+	return defaultDownloadWeeklyIPLimit
+}
+
+func (ds *DownloadService) abuseIPThreshold() int {
+	if ds.cfg.Downloads.Abuse.IPThreshold > 0 {
+		return ds.cfg.Downloads.Abuse.IPThreshold
+	}
+	return defaultAbuseIPThreshold
+}
+
+func (ds *DownloadService) abuseCountryThreshold() int {
+	if ds.cfg.Downloads.Abuse.CountryThreshold > 0 {
+		return ds.cfg.Downloads.Abuse.CountryThreshold
+	}
+	return defaultAbuseCountryThreshold
+}
+
+func (ds *DownloadService) abuseDownloadThreshold() int {
+	if ds.cfg.Downloads.Abuse.DownloadThreshold > 0 {
+		return ds.cfg.Downloads.Abuse.DownloadThreshold
+	}
+	return defaultAbuseDownloadThreshold
+}
+
+func (ds *DownloadService) scriptedUserAgents() []string {
+	if len(ds.cfg.Downloads.Abuse.ScriptedUserAgents) > 0 {
+		return ds.cfg.Downloads.Abuse.ScriptedUserAgents
+	}
+	return defaultScriptedUserAgents
+}
@@ -0,0 +1,504 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"jinzmedia-atmt/config"
+	"jinzmedia-atmt/database"
+	"jinzmedia-atmt/mailer"
+	"jinzmedia-atmt/models"
+)
+
+var (
+	ErrExportTooSoon     = errors.New("a data export was requested recently")
+	ErrExportNotFound    = errors.New("data export not found")
+	ErrExportLinkInvalid = errors.New("download link is invalid or has expired")
+)
+
+// exportWakeup lets RequestExport nudge the worker so exports start promptly
+var exportWakeup = make(chan struct{}, 1)
+
+// DataExportService builds ZIP archives of a user's personal data in the
+// background and emails an expiring download link when they are ready
+type DataExportService struct {
+	exportCollection         *mongo.Collection
+	userCollection           *mongo.Collection
+	paymentCollection        *mongo.Collection
+	paymentSessionCollection *mongo.Collection
+	downloadCollection       *mongo.Collection
+	apiKeyCollection         *mongo.Collection
+	activationCollection     *mongo.Collection
+	auditCollection          *mongo.Collection
+	outbox                   *mailer.Outbox
+	cfg                      *config.Config
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewDataExportService() *DataExportService {
+	return &DataExportService{
+		exportCollection:         database.GetCollection("data_exports"),
+		userCollection:           database.GetCollection("users"),
+		paymentCollection:        database.GetCollection("payments"),
+		paymentSessionCollection: database.GetCollection("payment_sessions"),
+		downloadCollection:       database.GetCollection("downloads"),
+		apiKeyCollection:         database.GetCollection("api_keys"),
+		activationCollection:     database.GetCollection("license_activations"),
+		auditCollection:          database.GetCollection("audit_logs"),
+		outbox:                   mailer.NewOutbox(),
+		cfg:                      config.Get(),
+	}
+}
+
+// RequestExport queues a new export for the user. An export that is still
+// pending or processing is returned instead of creating another one.
+func (s *DataExportService) RequestExport(ctx context.Context, userID primitive.ObjectID) (*models.DataExport, error) {
+	var latest models.DataExport
+	err := s.exportCollection.FindOne(ctx,
+		bson.M{"user_id": userID},
+		options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	).Decode(&latest)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to get latest export: %w", err)
+	}
+
+	if err == nil {
+		if latest.Status == models.DataExportStatusPending || latest.Status == models.DataExportStatusProcessing {
+			return &latest, nil
+		}
+		if time.Since(latest.CreatedAt) < s.cfg.DataExport.MinInterval && latest.Status != models.DataExportStatusFailed {
+			return nil, ErrExportTooSoon
+		}
+	}
+
+	now := time.Now()
+	export := &models.DataExport{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Status:    models.DataExportStatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if _, err := s.exportCollection.InsertOne(ctx, export); err != nil {
+		return nil, fmt.Errorf("failed to queue export: %w", err)
+	}
+
+	select {
+	case exportWakeup <- struct{}{}:
+	default:
+	}
+
+	return export, nil
+}
+
+// GetLatestExport returns the user's most recent export
+func (s *DataExportService) GetLatestExport(ctx context.Context, userID primitive.ObjectID) (*models.DataExport, error) {
+	var export models.DataExport
+	err := s.exportCollection.FindOne(ctx,
+		bson.M{"user_id": userID},
+		options.FindOne().SetSort(bson.D{{Key: "created_at", Value: -1}}),
+	).Decode(&export)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrExportNotFound
+		}
+		return nil, err
+	}
+	return &export, nil
+}
+
+// OpenExport validates a download link and returns the ZIP file path
+func (s *DataExportService) OpenExport(ctx context.Context, exportID primitive.ObjectID, token string) (string, error) {
+	var export models.DataExport
+	err := s.exportCollection.FindOne(ctx, bson.M{
+		"_id":        exportID,
+		"status":     models.DataExportStatusReady,
+		"token_hash": hashExportToken(token),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&export)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", ErrExportLinkInvalid
+		}
+		return "", err
+	}
+
+	if _, err := os.Stat(export.FilePath); err != nil {
+		return "", ErrExportLinkInvalid
+	}
+
+	return export.FilePath, nil
+}
+
+// Start launches the background export worker
+func (s *DataExportService) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	interval := s.cfg.DataExport.PollInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			s.processPending(ctx)
+			s.expireOld(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-exportWakeup:
+			}
+		}
+	}()
+
+	log.Println("Data export worker started")
+}
+
+// Stop signals the worker to exit and waits for the current export
+func (s *DataExportService) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+	log.Println("Data export worker stopped")
+}
+
+// processPending builds all due exports one at a time
+func (s *DataExportService) processPending(ctx context.Context) {
+	for ctx.Err() == nil {
+		export, err := s.claimNext(ctx)
+		if err != nil {
+			if err != mongo.ErrNoDocuments {
+				log.Printf("EXPORT ERROR: Failed to claim export: %v", err)
+			}
+			return
+		}
+
+		s.process(ctx, export)
+	}
+}
+
+// claimNext atomically locks the next pending export, including exports whose
+// previous worker died while building
+func (s *DataExportService) claimNext(ctx context.Context) (*models.DataExport, error) {
+	now := time.Now()
+
+	filter := bson.M{
+		"$or": []bson.M{
+			{"status": models.DataExportStatusPending},
+			{"status": models.DataExportStatusProcessing, "locked_until": bson.M{"$lte": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":       models.DataExportStatusProcessing,
+			"locked_until": now.Add(10 * time.Minute),
+			"updated_at":   now,
+		},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var export models.DataExport
+	if err := s.exportCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&export); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// process builds one export, marks it ready and emails the link
+func (s *DataExportService) process(ctx context.Context, export *models.DataExport) {
+	var user models.User
+	err := s.userCollection.FindOne(ctx, bson.M{"_id": export.UserID}).Decode(&user)
+	if err == nil {
+		err = s.buildArchive(ctx, export, &user)
+	}
+
+	// Record the result even if shutdown has started
+	recordCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err != nil {
+		s.recordFailure(recordCtx, export, err)
+		return
+	}
+
+	token, err := newExportToken()
+	if err != nil {
+		s.recordFailure(recordCtx, export, err)
+		return
+	}
+
+	linkExpiration := s.cfg.DataExport.LinkExpiration
+	if linkExpiration <= 0 {
+		linkExpiration = 72 * time.Hour
+	}
+
+	now := time.Now()
+	_, err = s.exportCollection.UpdateOne(recordCtx,
+		bson.M{"_id": export.ID},
+		bson.M{
+			"$set": bson.M{
+				"status":     models.DataExportStatusReady,
+				"file_path":  export.FilePath,
+				"file_size":  export.FileSize,
+				"token_hash": hashExportToken(token),
+				"ready_at":   now,
+				"expires_at": now.Add(linkExpiration),
+				"updated_at": now,
+			},
+			"$inc":   bson.M{"attempts": 1},
+			"$unset": bson.M{"locked_until": "", "last_error": ""},
+		},
+	)
+	if err != nil {
+		log.Printf("EXPORT ERROR: Failed to mark export %s as ready: %v", export.ID.Hex(), err)
+		return
+	}
+
+	downloadURL := fmt.Sprintf("%s/api/v1/auth/data-export/%s/download?token=%s",
+		s.cfg.DataExport.BaseURL, export.ID.Hex(), token)
+	err = s.outbox.Enqueue(recordCtx, &user.ID, user.Email, mailer.TemplateDataExportReady, user.Language, map[string]interface{}{
+		"FullName":    user.FullName,
+		"DownloadURL": downloadURL,
+		"ExpiresIn":   linkExpiration.String(),
+	})
+	if err != nil {
+		log.Printf("EXPORT ERROR: Failed to queue ready email for export %s: %v", export.ID.Hex(), err)
+	}
+
+	log.Printf("EXPORT SUCCESS: Data export %s ready for user %s (%d bytes)", export.ID.Hex(), user.Email, export.FileSize)
+}
+
+// recordFailure schedules a retry or gives up after max attempts
+func (s *DataExportService) recordFailure(ctx context.Context, export *models.DataExport, cause error) {
+	attempts := export.Attempts + 1
+	status := models.DataExportStatusPending
+
+	maxAttempts := s.cfg.DataExport.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	if attempts >= maxAttempts {
+		status = models.DataExportStatusFailed
+	}
+
+	_, err := s.exportCollection.UpdateOne(ctx,
+		bson.M{"_id": export.ID},
+		bson.M{
+			"$set": bson.M{
+				"status":     status,
+				"attempts":   attempts,
+				"last_error": cause.Error(),
+				"updated_at": time.Now(),
+			},
+			"$unset": bson.M{"locked_until": ""},
+		},
+	)
+	if err != nil {
+		log.Printf("EXPORT ERROR: Failed to record failure for export %s: %v", export.ID.Hex(), err)
+	}
+
+	log.Printf("EXPORT ERROR: Export %s failed (attempt %d, status %s): %v", export.ID.Hex(), attempts, status, cause)
+}
+
+// buildArchive writes the user's data as JSON files into a ZIP archive
+func (s *DataExportService) buildArchive(ctx context.Context, export *models.DataExport, user *models.User) error {
+	var paymentSessions []models.PaymentSession
+	if err := s.findAll(ctx, s.paymentSessionCollection, bson.M{"user_id": user.ID}, &paymentSessions); err != nil {
+		return fmt.Errorf("payment sessions: %w", err)
+	}
+
+	var payments []models.Payment
+	if err := s.findAll(ctx, s.paymentCollection, bson.M{"user_id": user.ID}, &payments); err != nil {
+		return fmt.Errorf("payments: %w", err)
+	}
+
+	var downloads []models.DownloadRecord
+	if err := s.findAll(ctx, s.downloadCollection, bson.M{"user_id": user.ID}, &downloads); err != nil {
+		return fmt.Errorf("downloads: %w", err)
+	}
+
+	var activations []models.LicenseActivation
+	if err := s.findAll(ctx, s.activationCollection, bson.M{"user_id": user.ID}, &activations); err != nil {
+		return fmt.Errorf("license activations: %w", err)
+	}
+
+	var apiKeys []models.APIKey
+	if err := s.findAll(ctx, s.apiKeyCollection, bson.M{"user_id": user.ID}, &apiKeys); err != nil {
+		return fmt.Errorf("api keys: %w", err)
+	}
+
+	var auditEntries []models.AuditEntry
+	if err := s.findAll(ctx, s.auditCollection, bson.M{"subject_user_id": user.ID}, &auditEntries); err != nil {
+		return fmt.Errorf("audit trail: %w", err)
+	}
+
+	// There are no server-side login sessions (JWTs are stateless), so this
+	// covers everything we record about how the account was accessed
+	sessions := map[string]interface{}{
+		"last_login":         user.LastLogin,
+		"linked_identities":  user.Identities,
+		"api_keys":           apiKeys,
+		"support_access_log": auditEntries,
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", user},
+		{"payment_sessions.json", paymentSessions},
+		{"payments.json", payments},
+		{"downloads.json", downloads},
+		{"licenses.json", activations},
+		{"sessions.json", sessions},
+	}
+
+	dir := s.cfg.DataExport.Dir
+	if dir == "" {
+		dir = "exports/"
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("atmt-data-%s-%s.zip", user.ID.Hex(), export.ID.Hex()))
+	tmpPath := path + ".tmp"
+
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	zw := zip.NewWriter(f)
+	for _, file := range files {
+		w, err := zw.Create(file.name)
+		if err != nil {
+			f.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to add %s: %w", file.name, err)
+		}
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			f.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to write %s: %w", file.name, err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to store archive: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	export.FilePath = path
+	export.FileSize = info.Size()
+	return nil
+}
+
+// expireOld deletes archives whose download link has expired
+func (s *DataExportService) expireOld(ctx context.Context) {
+	cursor, err := s.exportCollection.Find(ctx, bson.M{
+		"status":     models.DataExportStatusReady,
+		"expires_at": bson.M{"$lte": time.Now()},
+	})
+	if err != nil {
+		log.Printf("EXPORT ERROR: Failed to find expired exports: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var export models.DataExport
+		if err := cursor.Decode(&export); err != nil {
+			continue
+		}
+
+		if err := os.Remove(export.FilePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("EXPORT ERROR: Failed to delete %s: %v", export.FilePath, err)
+			continue
+		}
+
+		_, err := s.exportCollection.UpdateOne(ctx,
+			bson.M{"_id": export.ID},
+			bson.M{
+				"$set":   bson.M{"status": models.DataExportStatusExpired, "updated_at": time.Now()},
+				"$unset": bson.M{"file_path": "", "token_hash": ""},
+			},
+		)
+		if err != nil {
+			log.Printf("EXPORT ERROR: Failed to mark export %s as expired: %v", export.ID.Hex(), err)
+		}
+	}
+}
+
+func (s *DataExportService) findAll(ctx context.Context, collection *mongo.Collection, filter bson.M, results interface{}) error {
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	return cursor.All(ctx, results)
+}
+
+// newExportToken returns a random token for the download link
+func newExportToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashExportToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
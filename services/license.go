@@ -0,0 +1,420 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"jinzmedia-atmt/config"
+	"jinzmedia-atmt/database"
+	"jinzmedia-atmt/licensefile"
+	"jinzmedia-atmt/models"
+	"jinzmedia-atmt/ratelimit"
+)
+
+var (
+	ErrDeviceLimit          = errors.New("license device limit reached")
+	ErrDeviceActivated      = errors.New("device already holds a seat of this license")
+	ErrActivationNotFound   = errors.New("license activation not found")
+	ErrLicenseTransferLimit = errors.New("license transfer limit reached")
+)
+
+// Defaults used when the license section is not configured
+const (
+	defaultLicenseMaxDevices     = 2
+	defaultLicenseMaxTransfers   = 3
+	defaultLicenseTransferWindow = 30 * 24 * time.Hour
+)
+
+// LicenseService binds a user's license to a limited number of devices. The
+// license is identified by the serial number of an account that owns the
+// products; each device is identified by a hardware fingerprint sent by the
+// desktop apps.
+type LicenseService struct {
+	activationCollection *mongo.Collection
+	userCollection       *mongo.Collection
+	heartbeatLimiter     *ratelimit.Limiter
+	cfg                  *config.Config
+}
+
+func NewLicenseService() *LicenseService {
+	s := &LicenseService{
+		activationCollection: database.GetCollection("license_activations"),
+		userCollection:       database.GetCollection("users"),
+		cfg:                  config.Get(),
+	}
+	s.heartbeatLimiter = ratelimit.NewLimiter("license_heartbeat", s.heartbeatLimit(), time.Hour)
+	return s
+}
+
+// Activate claims a seat of the user's license for a device. Activating a
+// device that already holds a seat refreshes it; created reports whether a
+// new seat was taken.
+func (s *LicenseService) Activate(ctx context.Context, user *models.User, req *models.ActivateLicenseRequest, ip string) (activation *models.LicenseActivation, created bool, err error) {
+	if err := checkLicense(user, req.SerialNumber); err != nil {
+		return nil, false, err
+	}
+
+	existing, err := s.findActive(ctx, user.ID, licensefile.HashFingerprint(req.Fingerprint))
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		return s.touch(ctx, existing, req, ip)
+	}
+
+	activation, err = s.insert(ctx, user, req, ip)
+	if err != nil {
+		return nil, false, err
+	}
+
+	log.Printf("LICENSE: User %s activated device %s (%s)", user.Email, activation.ID.Hex(), activation.DeviceName)
+	return activation, true, nil
+}
+
+// Deactivate releases the seat held by the device with the given fingerprint
+func (s *LicenseService) Deactivate(ctx context.Context, user *models.User, req *models.DeactivateLicenseRequest) error {
+	if user.SerialNumber != req.SerialNumber {
+		return ErrSerialMismatch
+	}
+
+	activation, err := s.findActive(ctx, user.ID, licensefile.HashFingerprint(req.Fingerprint))
+	if err != nil {
+		return err
+	}
+	if activation == nil {
+		return ErrActivationNotFound
+	}
+
+	return s.release(ctx, user, activation, models.DeactivationReasonDevice)
+}
+
+// DeactivateByID releases the seat of one of the user's devices, e.g. a lost
+// machine removed from the account page
+func (s *LicenseService) DeactivateByID(ctx context.Context, user *models.User, activationID primitive.ObjectID) error {
+	activation, err := s.getActive(ctx, user.ID, activationID)
+	if err != nil {
+		return err
+	}
+
+	return s.release(ctx, user, activation, models.DeactivationReasonUser)
+}
+
+// Transfer moves the seat of an existing activation to a new device in one
+// step, so a full license can be moved without freeing a seat first
+func (s *LicenseService) Transfer(ctx context.Context, user *models.User, activationID primitive.ObjectID, req *models.ActivateLicenseRequest, ip string) (*models.LicenseActivation, error) {
+	if err := checkLicense(user, req.SerialNumber); err != nil {
+		return nil, err
+	}
+
+	source, err := s.getActive(ctx, user.ID, activationID)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprintHash := licensefile.HashFingerprint(req.Fingerprint)
+	if source.FingerprintHash == fingerprintHash {
+		activation, _, err := s.touch(ctx, source, req, ip)
+		return activation, err
+	}
+	target, err := s.findActive(ctx, user.ID, fingerprintHash)
+	if err != nil {
+		return nil, err
+	}
+	if target != nil {
+		return nil, ErrDeviceActivated
+	}
+
+	if err := s.release(ctx, user, source, models.DeactivationReasonTransfer); err != nil {
+		return nil, err
+	}
+
+	activation, err := s.insert(ctx, user, req, ip)
+	if err != nil {
+		// Give the seat back so a failed transfer does not cost the user a device
+		if _, restoreErr := s.activationCollection.UpdateOne(ctx,
+			bson.M{"_id": source.ID},
+			bson.M{
+				"$set":   bson.M{"status": models.LicenseActivationActive},
+				"$unset": bson.M{"deactivated_at": "", "deactivation_reason": ""},
+			},
+		); restoreErr != nil {
+			log.Printf("LICENSE ERROR: Failed to restore activation %s after failed transfer: %v", source.ID.Hex(), restoreErr)
+		}
+		return nil, err
+	}
+
+	log.Printf("LICENSE: User %s transferred activation %s to %s (%s)", user.Email, source.ID.Hex(), activation.ID.Hex(), activation.DeviceName)
+	return activation, nil
+}
+
+// GetStatus returns the user's license with its active devices and the
+// transfers left in the current window
+func (s *LicenseService) GetStatus(ctx context.Context, user *models.User) (*models.LicenseStatusResponse, error) {
+	activations, err := s.findActivations(ctx,
+		bson.M{"user_id": user.ID, "status": models.LicenseActivationActive},
+		options.Find().SetSort(bson.D{{Key: "activated_at", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	used, err := s.countTransfers(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.LicenseStatusResponse{
+		SerialNumber:       user.SerialNumber,
+		MaxDevices:         s.maxDevices(),
+		ActiveDevices:      len(activations),
+		TransfersRemaining: max(s.maxTransfers()-int(used), 0),
+		Activations:        activations,
+	}, nil
+}
+
+// insert takes a new seat, failing with ErrDeviceLimit when none is free.
+// The limit is checked again after inserting so that concurrent activations
+// cannot exceed it; the losing request removes its own record.
+func (s *LicenseService) insert(ctx context.Context, user *models.User, req *models.ActivateLicenseRequest, ip string) (*models.LicenseActivation, error) {
+	active, err := s.countActive(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if active >= int64(s.maxDevices()) {
+		return nil, ErrDeviceLimit
+	}
+
+	secret, err := newActivationSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate activation secret: %w", err)
+	}
+
+	now := time.Now()
+	activation := &models.LicenseActivation{
+		UserID:          user.ID,
+		SerialNumber:    user.SerialNumber,
+		FingerprintHash: licensefile.HashFingerprint(req.Fingerprint),
+		SecretHash:      hashActivationSecret(secret),
+		DeviceName:      req.DeviceName,
+		Platform:        req.Platform,
+		AppVersion:      req.AppVersion,
+		Status:          models.LicenseActivationActive,
+		IPAddress:       ip,
+		ActivatedAt:     now,
+		LastSeenAt:      now,
+	}
+
+	result, err := s.activationCollection.InsertOne(ctx, activation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create activation: %w", err)
+	}
+	activation.ID = result.InsertedID.(primitive.ObjectID)
+	activation.Secret = secret
+
+	// Keep the oldest seats if concurrent activations overshot the limit
+	cursor, err := s.activationCollection.Find(ctx,
+		bson.M{"user_id": user.ID, "status": models.LicenseActivationActive},
+		options.Find().
+			SetSort(bson.D{{Key: "activated_at", Value: 1}, {Key: "_id", Value: 1}}).
+			SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify device limit: %w", err)
+	}
+	var seats []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &seats); err != nil {
+		return nil, fmt.Errorf("failed to verify device limit: %w", err)
+	}
+	for i, seat := range seats {
+		if seat.ID == activation.ID && i >= s.maxDevices() {
+			if _, err := s.activationCollection.DeleteOne(ctx, bson.M{"_id": activation.ID}); err != nil {
+				log.Printf("LICENSE ERROR: Failed to remove activation %s over the device limit: %v", activation.ID.Hex(), err)
+			}
+			return nil, ErrDeviceLimit
+		}
+	}
+
+	return activation, nil
+}
+
+// touch refreshes the device details of an existing seat
+func (s *LicenseService) touch(ctx context.Context, activation *models.LicenseActivation, req *models.ActivateLicenseRequest, ip string) (*models.LicenseActivation, bool, error) {
+	// Activating again issues a new secret, e.g. after a reinstall
+	secret, err := newActivationSecret()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to generate activation secret: %w", err)
+	}
+
+	now := time.Now()
+	set := bson.M{
+		"platform":     req.Platform,
+		"ip_address":   ip,
+		"last_seen_at": now,
+		"secret_hash":  hashActivationSecret(secret),
+	}
+	if req.DeviceName != "" {
+		set["device_name"] = req.DeviceName
+		activation.DeviceName = req.DeviceName
+	}
+	if req.AppVersion != "" {
+		set["app_version"] = req.AppVersion
+		activation.AppVersion = req.AppVersion
+	}
+
+	if _, err := s.activationCollection.UpdateOne(ctx, bson.M{"_id": activation.ID}, bson.M{"$set": set}); err != nil {
+		return nil, false, fmt.Errorf("failed to update activation: %w", err)
+	}
+
+	activation.Platform = req.Platform
+	activation.IPAddress = ip
+	activation.LastSeenAt = now
+	activation.Secret = secret
+	return activation, false, nil
+}
+
+// release deactivates a seat, counting it against the transfer limit
+func (s *LicenseService) release(ctx context.Context, user *models.User, activation *models.LicenseActivation, reason string) error {
+	used, err := s.countTransfers(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if used >= int64(s.maxTransfers()) {
+		return ErrLicenseTransferLimit
+	}
+
+	if err := s.deactivate(ctx, activation.ID, reason); err != nil {
+		return err
+	}
+
+	log.Printf("LICENSE: User %s deactivated device %s (%s)", user.Email, activation.ID.Hex(), reason)
+	return nil
+}
+
+// deactivate ends an active seat and drops the cached revocation list so the
+// device's license files are revoked promptly
+func (s *LicenseService) deactivate(ctx context.Context, activationID primitive.ObjectID, reason string) error {
+	now := time.Now()
+	result, err := s.activationCollection.UpdateOne(ctx,
+		bson.M{"_id": activationID, "status": models.LicenseActivationActive},
+		bson.M{"$set": bson.M{
+			"status":              models.LicenseActivationDeactivated,
+			"deactivated_at":      now,
+			"deactivation_reason": reason,
+		}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to deactivate: %w", err)
+	}
+	if result.ModifiedCount == 0 {
+		// Released concurrently by another request
+		return ErrActivationNotFound
+	}
+
+	invalidateRevocationList()
+	return nil
+}
+
+func (s *LicenseService) findActive(ctx context.Context, userID primitive.ObjectID, fingerprintHash string) (*models.LicenseActivation, error) {
+	var activation models.LicenseActivation
+	err := s.activationCollection.FindOne(ctx, bson.M{
+		"user_id":          userID,
+		"fingerprint_hash": fingerprintHash,
+		"status":           models.LicenseActivationActive,
+	}).Decode(&activation)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get activation: %w", err)
+	}
+	return &activation, nil
+}
+
+func (s *LicenseService) getActive(ctx context.Context, userID, activationID primitive.ObjectID) (*models.LicenseActivation, error) {
+	var activation models.LicenseActivation
+	err := s.activationCollection.FindOne(ctx, bson.M{
+		"_id":     activationID,
+		"user_id": userID,
+		"status":  models.LicenseActivationActive,
+	}).Decode(&activation)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrActivationNotFound
+		}
+		return nil, fmt.Errorf("failed to get activation: %w", err)
+	}
+	return &activation, nil
+}
+
+func (s *LicenseService) countActive(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	count, err := s.activationCollection.CountDocuments(ctx, bson.M{
+		"user_id": userID,
+		"status":  models.LicenseActivationActive,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count activations: %w", err)
+	}
+	return count, nil
+}
+
+// countTransfers counts seats the user released within the transfer window
+func (s *LicenseService) countTransfers(ctx context.Context, userID primitive.ObjectID) (int64, error) {
+	count, err := s.activationCollection.CountDocuments(ctx, bson.M{
+		"user_id": userID,
+		"deactivation_reason": bson.M{"$in": []string{
+			models.DeactivationReasonDevice,
+			models.DeactivationReasonUser,
+			models.DeactivationReasonTransfer,
+		}},
+		"deactivated_at": bson.M{"$gte": time.Now().Add(-s.transferWindow())},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count transfers: %w", err)
+	}
+	return count, nil
+}
+
+func (s *LicenseService) maxDevices() int {
+	if s.cfg.License.MaxDevices > 0 {
+		return s.cfg.License.MaxDevices
+	}
+	return defaultLicenseMaxDevices
+}
+
+func (s *LicenseService) maxTransfers() int {
+	if s.cfg.License.MaxTransfers > 0 {
+		return s.cfg.License.MaxTransfers
+	}
+	return defaultLicenseMaxTransfers
+}
+
+func (s *LicenseService) transferWindow() time.Duration {
+	if s.cfg.License.TransferWindow > 0 {
+		return s.cfg.License.TransferWindow
+	}
+	return defaultLicenseTransferWindow
+}
+
+// checkLicense verifies that the user holds a usable license with this serial
+func checkLicense(user *models.User, serial string) error {
+	if user.IsBanned {
+		return ErrUserBanned
+	}
+	if !user.Owned {
+		return ErrNotOwned
+	}
+	if user.SerialNumber != serial {
+		return ErrSerialMismatch
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"jinzmedia-atmt/config"
+	"jinzmedia-atmt/licensefile"
+	"jinzmedia-atmt/models"
+)
+
+var ErrLicenseSigningDisabled = errors.New("license signing keys are not configured")
+
+// Defaults for license files when not configured
+const (
+	defaultLicenseFileValidity      = 30 * 24 * time.Hour
+	defaultLicenseRevocationRefresh = time.Hour
+)
+
+// licenseKeySet holds the key that signs license files and every public key
+// published for verification, so keys can be rotated without breaking files
+// issued earlier
+type licenseKeySet struct {
+	activeID string
+	active   ed25519.PrivateKey
+	public   map[string]ed25519.PublicKey
+}
+
+var (
+	licenseKeys     *licenseKeySet
+	licenseKeysErr  error
+	licenseKeysOnce sync.Once
+)
+
+// revocationCache holds the signed revocation list, shared by all service
+// instances and rebuilt every license.revocation_refresh or after a device
+// is deactivated
+var revocationCache struct {
+	sync.Mutex
+	data    []byte
+	expires time.Time
+}
+
+func invalidateRevocationList() {
+	revocationCache.Lock()
+	revocationCache.data = nil
+	revocationCache.Unlock()
+}
+
+// LoadLicenseKeys loads the license signing keys from config. Safe to call
+// more than once. Without configured keys, license files are unavailable.
+func LoadLicenseKeys() error {
+	licenseKeysOnce.Do(func() {
+		licenseKeys, licenseKeysErr = newLicenseKeySet(&config.Get().License)
+	})
+	return licenseKeysErr
+}
+
+func newLicenseKeySet(cfg *config.LicenseConfig) (*licenseKeySet, error) {
+	if len(cfg.SigningKeys) == 0 {
+		return nil, nil
+	}
+
+	ks := &licenseKeySet{public: make(map[string]ed25519.PublicKey)}
+	for _, keyCfg := range cfg.SigningKeys {
+		if keyCfg.ID == "" {
+			return nil, errors.New("license signing key id is required")
+		}
+		if _, exists := ks.public[keyCfg.ID]; exists {
+			return nil, fmt.Errorf("duplicate license signing key id: %s", keyCfg.ID)
+		}
+
+		switch {
+		case keyCfg.PrivateKeyFile != "":
+			data, err := os.ReadFile(keyCfg.PrivateKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load license key %q: %w", keyCfg.ID, err)
+			}
+			priv, err := licensefile.ParsePrivateKey(data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load license key %q: %w", keyCfg.ID, err)
+			}
+			ks.public[keyCfg.ID] = priv.Public().(ed25519.PublicKey)
+			if keyCfg.ID == cfg.ActiveKeyID {
+				ks.activeID, ks.active = keyCfg.ID, priv
+			}
+		case keyCfg.PublicKeyFile != "":
+			data, err := os.ReadFile(keyCfg.PublicKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load license key %q: %w", keyCfg.ID, err)
+			}
+			pub, err := licensefile.ParsePublicKey(data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load license key %q: %w", keyCfg.ID, err)
+			}
+			ks.public[keyCfg.ID] = pub
+		default:
+			return nil, fmt.Errorf("license key %q needs private_key_file or public_key_file", keyCfg.ID)
+		}
+	}
+
+	if ks.active == nil {
+		return nil, fmt.Errorf("active license key %q not found in license.signing_keys or has no private key", cfg.ActiveKeyID)
+	}
+	return ks, nil
+}
+
+func (s *LicenseService) keys() (*licenseKeySet, error) {
+	if err := LoadLicenseKeys(); err != nil {
+		return nil, err
+	}
+	if licenseKeys == nil {
+		return nil, ErrLicenseSigningDisabled
+	}
+	return licenseKeys, nil
+}
+
+// IssueLicenseFile signs a license file for an activated device. The device
+// must already hold a seat; each file is valid for license.file_validity.
+func (s *LicenseService) IssueLicenseFile(ctx context.Context, user *models.User, req *models.LicenseFileRequest) ([]byte, error) {
+	keys, err := s.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkLicense(user, req.SerialNumber); err != nil {
+		return nil, err
+	}
+
+	activation, err := s.findActive(ctx, user.ID, licensefile.HashFingerprint(req.Fingerprint))
+	if err != nil {
+		return nil, err
+	}
+	if activation == nil {
+		return nil, ErrActivationNotFound
+	}
+
+	now := time.Now()
+	if _, err := s.activationCollection.UpdateOne(ctx,
+		bson.M{"_id": activation.ID},
+		bson.M{"$set": bson.M{"last_seen_at": now}},
+	); err != nil {
+		return nil, fmt.Errorf("failed to update activation: %w", err)
+	}
+
+	products := make([]string, 0, len(models.Products))
+	for _, product := range models.Products {
+		products = append(products, product.Name)
+	}
+
+	return licensefile.Sign(licensefile.FormatLicense, keys.activeID, keys.active, &licensefile.License{
+		ID:              activation.ID.Hex(),
+		UserID:          user.ID.Hex(),
+		Email:           user.Email,
+		SerialNumber:    user.SerialNumber,
+		Products:        products,
+		FingerprintHash: activation.FingerprintHash,
+		IssuedAt:        now.UTC().Truncate(time.Second),
+		ExpiresAt:       now.Add(s.fileValidity()).UTC().Truncate(time.Second),
+	})
+}
+
+// RevocationList returns the signed list of license files that must no
+// longer be accepted: devices deactivated while their files may still be
+// valid, and devices of banned or refunded users
+func (s *LicenseService) RevocationList(ctx context.Context) ([]byte, time.Time, error) {
+	keys, err := s.keys()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	revocationCache.Lock()
+	defer revocationCache.Unlock()
+
+	now := time.Now()
+	if revocationCache.data != nil && now.Before(revocationCache.expires) {
+		return revocationCache.data, revocationCache.expires, nil
+	}
+
+	revoked, err := s.findRevocations(ctx, now.Add(-s.fileValidity()))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	nextUpdate := now.Add(s.revocationRefresh()).UTC().Truncate(time.Second)
+	data, err := licensefile.Sign(licensefile.FormatRevocationList, keys.activeID, keys.active, &licensefile.RevocationList{
+		IssuedAt:   now.UTC().Truncate(time.Second),
+		NextUpdate: nextUpdate,
+		Revoked:    revoked,
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	revocationCache.data, revocationCache.expires = data, nextUpdate
+	return data, nextUpdate, nil
+}
+
+// PublicKeys returns the keys that verify license files and revocation lists
+func (s *LicenseService) PublicKeys() (*models.LicenseKeysResponse, error) {
+	keys, err := s.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.LicenseKeysResponse{
+		ActiveKeyID: keys.activeID,
+		Keys:        make([]models.LicensePublicKey, 0, len(keys.public)),
+	}
+	for id, pub := range keys.public {
+		response.Keys = append(response.Keys, models.LicensePublicKey{
+			KeyID:     id,
+			Algorithm: "Ed25519",
+			PublicKey: base64.StdEncoding.EncodeToString(pub),
+		})
+	}
+	sort.Slice(response.Keys, func(i, j int) bool { return response.Keys[i].KeyID < response.Keys[j].KeyID })
+
+	return response, nil
+}
+
+// findRevocations lists activations whose license files must be rejected.
+// Files of devices deactivated before since have expired on their own.
+func (s *LicenseService) findRevocations(ctx context.Context, since time.Time) ([]licensefile.Revocation, error) {
+	projection := options.Find().SetProjection(bson.M{"_id": 1, "user_id": 1, "deactivated_at": 1})
+
+	var deactivated []models.LicenseActivation
+	cursor, err := s.activationCollection.Find(ctx, bson.M{
+		"status":         models.LicenseActivationDeactivated,
+		"deactivated_at": bson.M{"$gte": since},
+	}, projection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deactivated devices: %w", err)
+	}
+	if err := cursor.All(ctx, &deactivated); err != nil {
+		return nil, fmt.Errorf("failed to decode deactivated devices: %w", err)
+	}
+
+	revoked := make([]licensefile.Revocation, 0, len(deactivated))
+	for _, activation := range deactivated {
+		revoked = append(revoked, licensefile.Revocation{
+			LicenseID: activation.ID.Hex(),
+			RevokedAt: activation.DeactivatedAt.UTC(),
+		})
+	}
+
+	// Devices of banned or refunded users keep their seat but lose their licenses
+	holders, err := s.activationCollection.Distinct(ctx, "user_id", bson.M{"status": models.LicenseActivationActive})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list license holders: %w", err)
+	}
+
+	var blocked []models.User
+	cursor, err = s.userCollection.Find(ctx,
+		bson.M{
+			"_id": bson.M{"$in": holders},
+			"$or": []bson.M{{"is_banned": true}, {"owned": false}},
+		},
+		options.Find().SetProjection(bson.M{"_id": 1, "updated_at": 1}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blocked users: %w", err)
+	}
+	if err := cursor.All(ctx, &blocked); err != nil {
+		return nil, fmt.Errorf("failed to decode blocked users: %w", err)
+	}
+
+	if len(blocked) > 0 {
+		blockedAt := make(map[primitive.ObjectID]time.Time, len(blocked))
+		userIDs := make([]primitive.ObjectID, 0, len(blocked))
+		for _, user := range blocked {
+			blockedAt[user.ID] = user.UpdatedAt
+			userIDs = append(userIDs, user.ID)
+		}
+
+		var active []models.LicenseActivation
+		cursor, err = s.activationCollection.Find(ctx, bson.M{
+			"user_id": bson.M{"$in": userIDs},
+			"status":  models.LicenseActivationActive,
+		}, projection)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list devices of blocked users: %w", err)
+		}
+		if err := cursor.All(ctx, &active); err != nil {
+			return nil, fmt.Errorf("failed to decode devices of blocked users: %w", err)
+		}
+
+		for _, activation := range active {
+			revoked = append(revoked, licensefile.Revocation{
+				LicenseID: activation.ID.Hex(),
+				RevokedAt: blockedAt[activation.UserID].UTC(),
+			})
+		}
+	}
+
+	sort.Slice(revoked, func(i, j int) bool { return revoked[i].LicenseID < revoked[j].LicenseID })
+	return revoked, nil
+}
+
+func (s *LicenseService) fileValidity() time.Duration {
+	if s.cfg.License.FileValidity > 0 {
+		return s.cfg.License.FileValidity
+	}
+	return defaultLicenseFileValidity
+}
+
+func (s *LicenseService) revocationRefresh() time.Duration {
+	if s.cfg.License.RevocationRefresh > 0 {
+		return s.cfg.License.RevocationRefresh
+	}
+	return defaultLicenseRevocationRefresh
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"jinzmedia-atmt/licensefile"
+	"jinzmedia-atmt/models"
+)
+
+// Defaults for heartbeats and anomaly detection when not configured
+const (
+	defaultLicenseHeartbeatInterval = 6 * time.Hour
+	defaultLicenseHeartbeatLimit    = 60 // per hour, per client IP and per activation
+	defaultLicenseAnomalyIPLimit    = 5
+	defaultLicenseAnomalyWindow     = 24 * time.Hour
+
+	// maxLicenseAnomalies caps the anomalies kept per activation
+	maxLicenseAnomalies = 20
+)
+
+// Heartbeat records that a device is running and tells it whether its
+// license is still valid. The device proves itself with the secret it was
+// given on activation; a wrong secret is answered as an unknown activation
+// so it cannot be used to flag someone else's device. Heartbeats with the
+// secret but from another device, or from many addresses, flag the
+// activation for review. Heartbeats are rate limited per client IP and per
+// activation and return a *ratelimit.LimitedError when over the limit.
+func (s *LicenseService) Heartbeat(ctx context.Context, activationID primitive.ObjectID, req *models.LicenseHeartbeatRequest, ip string) (*models.LicenseHeartbeatResponse, error) {
+	if err := s.heartbeatLimiter.Allow(ctx, "ip:"+ip); err != nil {
+		return nil, err
+	}
+	if err := s.heartbeatLimiter.Allow(ctx, "activation:"+activationID.Hex()); err != nil {
+		return nil, err
+	}
+
+	var activation models.LicenseActivation
+	err := s.activationCollection.FindOne(ctx, bson.M{"_id": activationID}).Decode(&activation)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrActivationNotFound
+		}
+		return nil, fmt.Errorf("failed to get activation: %w", err)
+	}
+
+	// Activations made before secrets were issued must activate again
+	secretHash := hashActivationSecret(req.ActivationSecret)
+	if activation.SecretHash == "" || subtle.ConstantTimeCompare([]byte(activation.SecretHash), []byte(secretHash)) != 1 {
+		return nil, ErrActivationNotFound
+	}
+
+	now := time.Now()
+
+	if activation.FingerprintHash != licensefile.HashFingerprint(req.Fingerprint) {
+		s.flag(ctx, &activation, models.AnomalyFingerprintMismatch, "heartbeat from another device at "+ip, now)
+		return s.heartbeatResponse(models.LicenseStateWrongDevice), nil
+	}
+
+	if activation.Status != models.LicenseActivationActive {
+		return s.heartbeatResponse(models.LicenseStateRevoked), nil
+	}
+
+	var user models.User
+	err = s.userCollection.FindOne(ctx, bson.M{"_id": activation.UserID}).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	switch {
+	case err == mongo.ErrNoDocuments || user.DeletedAt != nil:
+		return s.heartbeatResponse(models.LicenseStateRevoked), nil
+	case user.IsBanned:
+		return s.heartbeatResponse(models.LicenseStateBanned), nil
+	case !user.Owned:
+		return s.heartbeatResponse(models.LicenseStateNotOwned), nil
+	}
+
+	// Keep the addresses seen within the anomaly window
+	since := now.Add(-s.anomalyWindow())
+	recentIPs := []models.DeviceIP{{IP: ip, LastSeenAt: now}}
+	for _, seen := range activation.RecentIPs {
+		if seen.IP != ip && seen.LastSeenAt.After(since) {
+			recentIPs = append(recentIPs, seen)
+		}
+	}
+
+	set := bson.M{
+		"last_seen_at": now,
+		"ip_address":   ip,
+		"recent_ips":   recentIPs,
+	}
+	if req.AppVersion != "" {
+		set["app_version"] = req.AppVersion
+	}
+	if _, err := s.activationCollection.UpdateOne(ctx, bson.M{"_id": activation.ID}, bson.M{"$set": set}); err != nil {
+		return nil, fmt.Errorf("failed to record heartbeat: %w", err)
+	}
+
+	if len(recentIPs) > s.anomalyIPLimit() {
+		s.flag(ctx, &activation, models.AnomalyManyIPs,
+			fmt.Sprintf("%d IP addresses within %s", len(recentIPs), s.anomalyWindow()), now)
+	}
+
+	return s.heartbeatResponse(models.LicenseStateValid), nil
+}
+
+// flag records an anomaly on the activation, at most once per kind within
+// the anomaly window. Failures are logged; they never fail the heartbeat.
+func (s *LicenseService) flag(ctx context.Context, activation *models.LicenseActivation, kind, detail string, now time.Time) {
+	since := now.Add(-s.anomalyWindow())
+	for _, anomaly := range activation.Anomalies {
+		if anomaly.Kind == kind && anomaly.DetectedAt.After(since) {
+			return
+		}
+	}
+
+	_, err := s.activationCollection.UpdateOne(ctx,
+		bson.M{"_id": activation.ID},
+		bson.M{
+			"$push": bson.M{"anomalies": bson.M{
+				"$each":  []models.LicenseAnomaly{{Kind: kind, Detail: detail, DetectedAt: now}},
+				"$slice": -maxLicenseAnomalies,
+			}},
+			"$set": bson.M{"flagged_at": now},
+		},
+	)
+	if err != nil {
+		log.Printf("LICENSE ERROR: Failed to flag activation %s: %v", activation.ID.Hex(), err)
+		return
+	}
+
+	log.Printf("LICENSE ALERT: Activation %s of user %s flagged as %s: %s", activation.ID.Hex(), activation.UserID.Hex(), kind, detail)
+}
+
+func (s *LicenseService) heartbeatResponse(state models.LicenseState) *models.LicenseHeartbeatResponse {
+	return &models.LicenseHeartbeatResponse{
+		Valid:            state == models.LicenseStateValid,
+		State:            state,
+		NextHeartbeatSec: int(s.heartbeatInterval().Seconds()),
+	}
+}
+
+// ListDevices returns all of a user's activations, most recently seen first
+func (s *LicenseService) ListDevices(ctx context.Context, userID primitive.ObjectID) ([]*models.LicenseActivation, error) {
+	return s.findActivations(ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "status", Value: 1}, {Key: "last_seen_at", Value: -1}}),
+	)
+}
+
+// ListFlaggedDevices returns active devices with anomalies detected since the
+// given time, most recently flagged first
+func (s *LicenseService) ListFlaggedDevices(ctx context.Context, since time.Time, limit int64) ([]*models.LicenseActivation, error) {
+	return s.findActivations(ctx,
+		bson.M{"status": models.LicenseActivationActive, "flagged_at": bson.M{"$gte": since}},
+		options.Find().SetSort(bson.D{{Key: "flagged_at", Value: -1}}).SetLimit(limit),
+	)
+}
+
+// RevokeDevice deactivates one of a user's devices on behalf of support. It
+// does not count against the user's transfer limit.
+func (s *LicenseService) RevokeDevice(ctx context.Context, userID, activationID primitive.ObjectID) error {
+	if _, err := s.getActive(ctx, userID, activationID); err != nil {
+		return err
+	}
+	return s.deactivate(ctx, activationID, models.DeactivationReasonAdmin)
+}
+
+func (s *LicenseService) findActivations(ctx context.Context, filter bson.M, opts *options.FindOptions) ([]*models.LicenseActivation, error) {
+	cursor, err := s.activationCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list activations: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	activations := make([]*models.LicenseActivation, 0)
+	if err := cursor.All(ctx, &activations); err != nil {
+		return nil, fmt.Errorf("failed to decode activations: %w", err)
+	}
+	return activations, nil
+}
+
+// newActivationSecret returns a random secret for a device to prove its
+// activation with
+func newActivationSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashActivationSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *LicenseService) heartbeatInterval() time.Duration {
+	if s.cfg.License.HeartbeatInterval > 0 {
+		return s.cfg.License.HeartbeatInterval
+	}
+	return defaultLicenseHeartbeatInterval
+}
+
+// heartbeatLimit returns the heartbeats allowed per hour, or 0 for no limit
+func (s *LicenseService) heartbeatLimit() int {
+	switch {
+	case s.cfg.License.HeartbeatLimit > 0:
+		return s.cfg.License.HeartbeatLimit
+	case s.cfg.License.HeartbeatLimit < 0:
+		return 0
+	}
+	return defaultLicenseHeartbeatLimit
+}
+
+func (s *LicenseService) anomalyIPLimit() int {
+	if s.cfg.License.AnomalyIPLimit > 0 {
+		return s.cfg.License.AnomalyIPLimit
+	}
+	return defaultLicenseAnomalyIPLimit
+}
+
+func (s *LicenseService) anomalyWindow() time.Duration {
+	if s.cfg.License.AnomalyWindow > 0 {
+		return s.cfg.License.AnomalyWindow
+	}
+	return defaultLicenseAnomalyWindow
+}
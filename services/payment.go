@@ -2,9 +2,11 @@ package services
 
 import (
 	"jinzmedia-atmt/database"
+	"jinzmedia-atmt/mailer"
 	"jinzmedia-atmt/models"
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
@@ -16,10 +18,22 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+var (
+	ErrUserNotFound           = errors.New("user not found")
+	ErrUserBanned             = errors.New("user is banned")
+	ErrAlreadyOwned           = errors.New("user already owns the product")
+	ErrPaymentSessionNotFound = errors.New("payment session not found")
+	ErrPaymentSessionExpired  = errors.New("payment session has expired")
+	ErrPaymentAmountMismatch  = errors.New("incorrect payment amount")
+	ErrPaymentCodeMissing     = errors.New("payment code not found in content")
+	ErrPaymentCodeUnknown     = errors.New("no user found with payment code")
+)
+
 type PaymentService struct {
 	paymentCollection        *mongo.Collection
 	paymentSessionCollection *mongo.Collection
 	userCollection          *mongo.Collection
+	outbox                  *mailer.Outbox
 }
 
 func NewPaymentService() *PaymentService {
@@ -27,6 +41,7 @@ func NewPaymentService() *PaymentService {
 		paymentCollection:        database.GetCollection("payments"),
 		paymentSessionCollection: database.GetCollection("payment_sessions"),
 		userCollection:          database.GetCollection("users"),
+		outbox:                  mailer.NewOutbox(),
 	}
 }
 
@@ -50,17 +65,17 @@ func (ps *PaymentService) InitiatePayment(userID primitive.ObjectID) (*models.Pa
 	err := ps.userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to find user: %w", err)
 	}
 
 	if user.IsBanned {
-		return nil, fmt.Errorf("user is banned and cannot make payments")
+		return nil, fmt.Errorf("%w and cannot make payments", ErrUserBanned)
 	}
 
 	if user.Owned {
-		return nil, fmt.Errorf("user already owns the product")
+		return nil, ErrAlreadyOwned
 	}
 
 	// Generate unique payment code
@@ -137,7 +152,7 @@ func (ps *PaymentService) ProcessWebhookPayment(webhook *models.SepayWebhookRequ
 		}
 		
 		log.Printf("Payment ignored - incorrect amount: expected 5000000, got %d", payment.TransferAmount)
-		return payment, fmt.Errorf("incorrect payment amount: expected 5000000, got %d", payment.TransferAmount)
+		return payment, fmt.Errorf("%w: expected 5000000, got %d", ErrPaymentAmountMismatch, payment.TransferAmount)
 	}
 
 	// Extract payment code from content (should contain ATMT<8chars>)
@@ -155,7 +170,7 @@ func (ps *PaymentService) ProcessWebhookPayment(webhook *models.SepayWebhookRequ
 		}
 		
 		log.Printf("Payment ignored - no ATMT code found in content: %s", payment.Content)
-		return payment, fmt.Errorf("payment code not found in content: %s", payment.Content)
+		return payment, fmt.Errorf("%w: %s", ErrPaymentCodeMissing, payment.Content)
 	}
 
 	// Extract 8-character code after ATMT
@@ -171,7 +186,7 @@ func (ps *PaymentService) ProcessWebhookPayment(webhook *models.SepayWebhookRequ
 		}
 		
 		log.Printf("Payment ignored - invalid code format in content: %s", payment.Content)
-		return payment, fmt.Errorf("invalid payment code format in content: %s", payment.Content)
+		return payment, fmt.Errorf("%w: invalid format in %s", ErrPaymentCodeMissing, payment.Content)
 	}
 
 	paymentCode := content[atMTIndex+4 : atMTIndex+12] // Extract 8 chars after ATMT
@@ -192,7 +207,7 @@ func (ps *PaymentService) ProcessWebhookPayment(webhook *models.SepayWebhookRequ
 			}
 			
 			log.Printf("Payment failed - no user found with payment code: %s", paymentCode)
-			return payment, fmt.Errorf("no user found with payment code: %s", paymentCode)
+			return payment, fmt.Errorf("%w: %s", ErrPaymentCodeUnknown, paymentCode)
 		}
 		return nil, fmt.Errorf("failed to find user: %w", err)
 	}
@@ -210,7 +225,7 @@ func (ps *PaymentService) ProcessWebhookPayment(webhook *models.SepayWebhookRequ
 		}
 		
 		log.Printf("Payment failed - user is banned: %s", user.Email)
-		return payment, fmt.Errorf("user is banned: %s", user.Email)
+		return payment, fmt.Errorf("%w: %s", ErrUserBanned, user.Email)
 	}
 
 	// Find and update payment session
@@ -243,7 +258,7 @@ func (ps *PaymentService) ProcessWebhookPayment(webhook *models.SepayWebhookRequ
 		}
 		
 		log.Printf("Payment failed - no pending payment session found for code: %s", paymentCode)
-		return payment, fmt.Errorf("no pending payment session found for code: %s", paymentCode)
+		return payment, fmt.Errorf("%w: no pending session for code %s", ErrPaymentSessionNotFound, paymentCode)
 	}
 
 	// Activate user ownership
@@ -276,6 +291,18 @@ func (ps *PaymentService) ProcessWebhookPayment(webhook *models.SepayWebhookRequ
 	}
 
 	log.Printf("Payment processed successfully for user %s with code %s", user.Email, paymentCode)
+
+	// Queue confirmation email (delivered asynchronously by the outbox worker)
+	err = ps.outbox.Enqueue(ctx, &user.ID, user.Email, mailer.TemplatePaymentConfirmation, user.Language, map[string]interface{}{
+		"FullName":    user.FullName,
+		"PaymentCode": paymentCode,
+		"Amount":      payment.TransferAmount,
+		"PaidAt":      now.Format("02/01/2006 15:04"),
+	})
+	if err != nil {
+		log.Printf("Failed to queue payment confirmation email for %s: %v", user.Email, err)
+	}
+
 	return payment, nil
 }
 
@@ -287,7 +314,7 @@ func (ps *PaymentService) GetPaymentSession(sessionID primitive.ObjectID) (*mode
 	err := ps.paymentSessionCollection.FindOne(ctx, bson.M{"_id": sessionID}).Decode(&session)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("payment session not found")
+			return nil, ErrPaymentSessionNotFound
 		}
 		return nil, fmt.Errorf("failed to get payment session: %w", err)
 	}
@@ -325,7 +352,7 @@ func (ps *PaymentService) GetUserById(userID primitive.ObjectID) (*models.User,
 	err := ps.userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, fmt.Errorf("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
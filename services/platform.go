@@ -0,0 +1,100 @@
+package services
+
+import (
+	"net/http"
+	"strings"
+
+	"jinzmedia-atmt/models"
+)
+
+// ClientHintsHeader lists the User-Agent Client Hints DetectPlatform reads.
+// Browsers send the architecture hints only to sites that ask for them in
+// an Accept-CH response header.
+const ClientHintsHeader = "Sec-CH-UA-Platform, Sec-CH-UA-Arch, Sec-CH-UA-Bitness"
+
+// DetectPlatform guesses the platform and architecture of the client from
+// User-Agent Client Hints, falling back to the User-Agent header. The
+// platform is empty for mobile devices and unknown systems, and the
+// architecture when it cannot be told: Safari reports Intel on Apple
+// silicon, so the User-Agent is not trusted for macOS.
+func DetectPlatform(r *http.Request) (models.Platform, models.Arch) {
+	userAgent := r.Header.Get("User-Agent")
+
+	platform := hintPlatform(clientHint(r, "Sec-CH-UA-Platform"))
+	if platform == "" {
+		platform = userAgentPlatform(userAgent)
+	}
+	if platform == "" {
+		return "", ""
+	}
+
+	if arch := hintArch(clientHint(r, "Sec-CH-UA-Arch"), clientHint(r, "Sec-CH-UA-Bitness")); arch != "" {
+		return platform, arch
+	}
+	if platform == models.PlatformMacOS {
+		return platform, ""
+	}
+	return platform, userAgentArch(userAgent)
+}
+
+// clientHint returns the value of a structured header string hint
+func clientHint(r *http.Request, name string) string {
+	return strings.Trim(strings.TrimSpace(r.Header.Get(name)), `"`)
+}
+
+func hintPlatform(hint string) models.Platform {
+	switch strings.ToLower(hint) {
+	case "windows":
+		return models.PlatformWindows
+	case "macos":
+		return models.PlatformMacOS
+	case "linux":
+		return models.PlatformLinux
+	}
+	return ""
+}
+
+func hintArch(arch, bitness string) models.Arch {
+	if bitness != "" && bitness != "64" {
+		return ""
+	}
+	switch strings.ToLower(arch) {
+	case "x86":
+		if bitness == "64" {
+			return models.ArchAMD64
+		}
+	case "arm":
+		return models.ArchARM64
+	}
+	return ""
+}
+
+func userAgentPlatform(userAgent string) models.Platform {
+	switch {
+	// Mobile systems mention the desktop ones they derive from
+	case strings.Contains(userAgent, "Android"),
+		strings.Contains(userAgent, "iPhone"),
+		strings.Contains(userAgent, "iPad"),
+		strings.Contains(userAgent, "CrOS"):
+		return ""
+	case strings.Contains(userAgent, "Windows"):
+		return models.PlatformWindows
+	case strings.Contains(userAgent, "Macintosh"), strings.Contains(userAgent, "Mac OS X"):
+		return models.PlatformMacOS
+	case strings.Contains(userAgent, "Linux"), strings.Contains(userAgent, "X11"):
+		return models.PlatformLinux
+	}
+	return ""
+}
+
+func userAgentArch(userAgent string) models.Arch {
+	lower := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(lower, "aarch64"), strings.Contains(lower, "arm64"):
+		return models.ArchARM64
+	case strings.Contains(lower, "x86_64"), strings.Contains(lower, "x64"),
+		strings.Contains(lower, "amd64"), strings.Contains(lower, "wow64"):
+		return models.ArchAMD64
+	}
+	return ""
+}
@@ -0,0 +1,354 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"jinzmedia-atmt/config"
+	"jinzmedia-atmt/database"
+	"jinzmedia-atmt/models"
+	"jinzmedia-atmt/storage"
+)
+
+var (
+	ErrReleaseNotFound = errors.New("release not found")
+	ErrReleaseExists   = errors.New("release already exists")
+	ErrInvalidRelease  = errors.New("invalid release")
+)
+
+// ReleaseService manages the versioned builds offered for download. Their
+// files are kept in storage below <product>/<platform>/. A version may have
+// one build per architecture; downloads pick the one that best matches the
+// client.
+type ReleaseService struct {
+	releaseCollection *mongo.Collection
+	storage           storage.Storage
+}
+
+func NewReleaseService() *ReleaseService {
+	return &ReleaseService{
+		releaseCollection: database.GetCollection("releases"),
+		storage:           storage.Default(),
+	}
+}
+
+// CreateRelease registers a build that has been copied to storage below
+// <product>/<platform>/, recording its size and SHA-256
+func (s *ReleaseService) CreateRelease(ctx context.Context, req *models.CreateReleaseRequest) (*models.Release, error) {
+	if err := s.checkNewRelease(ctx, req.ProductName, req.Platform, req.Arch, req.Version); err != nil {
+		return nil, err
+	}
+
+	file, err := storage.CleanKey(req.File)
+	if err != nil {
+		return nil, fmt.Errorf("%w: file must be relative to %s/%s", ErrInvalidRelease, req.ProductName, req.Platform)
+	}
+	key := path.Join(req.ProductName, req.Platform, file)
+
+	size, checksum, err := hashObject(ctx, s.storage, key)
+	if err != nil {
+		if err == storage.ErrNotExist {
+			return nil, fmt.Errorf("%w: file %s not found in %s/%s", ErrInvalidRelease, req.File, req.ProductName, req.Platform)
+		}
+		return nil, fmt.Errorf("failed to read release file: %w", err)
+	}
+
+	if err := checkSparkleSize(config.Get(), req.Platform, size); err != nil {
+		return nil, err
+	}
+
+	var signature string
+	if req.Platform == string(models.PlatformMacOS) {
+		if signature, err = signSparkleObject(ctx, s.storage, key, sparkleMaxSize(config.Get())); err != nil {
+			return nil, err
+		}
+	}
+
+	filename := platformFilename(req.Platform, path.Base(file))
+
+	release := &models.Release{
+		ProductName:  req.ProductName,
+		Platform:     req.Platform,
+		Arch:         req.Arch,
+		Version:      req.Version,
+		Channel:      req.Channel,
+		FilePath:     key,
+		Filename:     filename,
+		Size:         size,
+		SHA256:       checksum,
+		ReleaseNotes: req.ReleaseNotes,
+		MinOSVersion: req.MinOSVersion,
+		Published:    req.Published,
+
+		SparkleSignature: signature,
+	}
+	if err := s.insertRelease(ctx, release); err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+// checkNewRelease validates the product, platform, architecture and
+// version of a new release and that the version is not taken yet for the
+// architecture
+func (s *ReleaseService) checkNewRelease(ctx context.Context, productName, platform string, arch models.Arch, version string) error {
+	if !models.IsValidPlatform(productName, platform) {
+		return fmt.Errorf("%w: unknown product or platform", ErrInvalidRelease)
+	}
+	if info, _ := models.GetPlatform(platform); arch != "" && !info.HasArch(arch) {
+		return fmt.Errorf("%w: %s builds are not made for %s", ErrInvalidRelease, platform, arch)
+	}
+	if !models.IsValidVersion(version) {
+		return fmt.Errorf("%w: version must look like 1.2.3 or 1.2.3-beta.1", ErrInvalidRelease)
+	}
+
+	// Releases without an architecture have no arch field
+	var archFilter interface{} = arch
+	if arch == "" {
+		archFilter = nil
+	}
+	count, err := s.releaseCollection.CountDocuments(ctx, bson.M{
+		"product_name": productName,
+		"platform":     platform,
+		"arch":         archFilter,
+		"version":      version,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check release: %w", err)
+	}
+	if count > 0 {
+		return ErrReleaseExists
+	}
+	return nil
+}
+
+func (s *ReleaseService) insertRelease(ctx context.Context, release *models.Release) error {
+	now := time.Now()
+	release.ID = primitive.NewObjectID()
+	release.CreatedAt, release.UpdatedAt = now, now
+	if release.Published {
+		release.PublishedAt = &now
+	}
+
+	if _, err := s.releaseCollection.InsertOne(ctx, release); err != nil {
+		return fmt.Errorf("failed to create release: %w", err)
+	}
+
+	log.Printf("RELEASE: Created %s %s/%s (%s, published: %t)", release.Version, release.ProductName, release.Platform, release.Channel, release.Published)
+	return nil
+}
+
+// UpdateRelease changes a release's channel, notes or minimum OS, or
+// publishes or withdraws it
+func (s *ReleaseService) UpdateRelease(ctx context.Context, releaseID primitive.ObjectID, req *models.UpdateReleaseRequest) (*models.Release, error) {
+	now := time.Now()
+	set := bson.M{"updated_at": now}
+	if req.Channel != nil {
+		set["channel"] = *req.Channel
+	}
+	if req.ReleaseNotes != nil {
+		set["release_notes"] = *req.ReleaseNotes
+	}
+	if req.MinOSVersion != nil {
+		set["min_os_version"] = *req.MinOSVersion
+	}
+	if req.Published != nil {
+		set["published"] = *req.Published
+	}
+
+	filter := bson.M{"_id": releaseID}
+	result, err := s.releaseCollection.UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update release: %w", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrReleaseNotFound
+	}
+
+	if req.Published != nil && *req.Published {
+		// Keep the original publication date when republishing
+		if _, err := s.releaseCollection.UpdateOne(ctx,
+			bson.M{"_id": releaseID, "published_at": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"published_at": now}},
+		); err != nil {
+			return nil, fmt.Errorf("failed to publish release: %w", err)
+		}
+	}
+
+	var release models.Release
+	if err := s.releaseCollection.FindOne(ctx, filter).Decode(&release); err != nil {
+		return nil, fmt.Errorf("failed to get release: %w", err)
+	}
+
+	log.Printf("RELEASE: Updated %s %s/%s (%s, published: %t)", release.Version, release.ProductName, release.Platform, release.Channel, release.Published)
+	return &release, nil
+}
+
+// ListReleases returns all releases, including unpublished ones, newest
+// version first. Empty product or platform match any.
+func (s *ReleaseService) ListReleases(ctx context.Context, productName, platform string) ([]*models.Release, error) {
+	filter := bson.M{}
+	if productName != "" {
+		filter["product_name"] = productName
+	}
+	if platform != "" {
+		filter["platform"] = platform
+	}
+	return findReleases(ctx, s.releaseCollection, filter)
+}
+
+// ListPublished returns the releases of a product offered in a channel,
+// newest version first
+func (s *ReleaseService) ListPublished(ctx context.Context, productName, platform string, channel models.ReleaseChannel) ([]*models.Release, error) {
+	return findReleases(ctx, s.releaseCollection, publishedFilter(productName, platform, channel))
+}
+
+// findPublishedRelease returns the build of the requested version, or of
+// the newest release in the channel when version is empty, whose
+// architecture comes first in archs. It returns nil without error when the
+// channel has no releases yet.
+func findPublishedRelease(ctx context.Context, releaseCollection *mongo.Collection, productName, platform string, archs []models.Arch, version string, channel models.ReleaseChannel) (*models.Release, error) {
+	filter := publishedFilter(productName, platform, channel)
+	if version != "" {
+		filter = bson.M{
+			"product_name": productName,
+			"platform":     platform,
+			"version":      version,
+			"published":    true,
+		}
+	}
+
+	releases, err := findReleases(ctx, releaseCollection, filter)
+	if err != nil {
+		return nil, err
+	}
+	if builds := bestBuilds(releases, archs); len(builds) > 0 {
+		return builds[0], nil
+	}
+	if version != "" || len(releases) > 0 {
+		// No build the client can run
+		return nil, ErrReleaseNotFound
+	}
+	return nil, nil
+}
+
+// bestBuilds keeps, of each version in releases, the build whose
+// architecture comes first in archs, skipping builds the client cannot run.
+// The version order of releases is kept.
+func bestBuilds(releases []*models.Release, archs []models.Arch) []*models.Release {
+	builds := make([]*models.Release, 0, len(releases))
+	for _, release := range releases {
+		rank := slices.Index(archs, release.Arch)
+		if rank < 0 {
+			continue
+		}
+		if n := len(builds); n > 0 && builds[n-1].Version == release.Version {
+			if rank < slices.Index(archs, builds[n-1].Arch) {
+				builds[n-1] = release
+			}
+			continue
+		}
+		builds = append(builds, release)
+	}
+	return builds
+}
+
+// archPreference returns the build architectures a client of arch runs on
+// the platform, best first
+func archPreference(platform string, arch models.Arch) []models.Arch {
+	info, _ := models.GetPlatform(platform)
+	return info.ArchPreference(arch)
+}
+
+// platformFilename adds the platform's executable extension to a filename
+// without one
+func platformFilename(platform, filename string) string {
+	if info, ok := models.GetPlatform(platform); ok && info.Extension != "" && path.Ext(filename) == "" {
+		filename += info.Extension
+	}
+	return filename
+}
+
+// publishedFilter matches the releases offered in a channel. The beta
+// channel also receives stable releases.
+func publishedFilter(productName, platform string, channel models.ReleaseChannel) bson.M {
+	channels := []models.ReleaseChannel{models.ReleaseChannelStable}
+	if channel == models.ReleaseChannelBeta {
+		channels = append(channels, models.ReleaseChannelBeta)
+	}
+	return bson.M{
+		"product_name": productName,
+		"platform":     platform,
+		"channel":      bson.M{"$in": channels},
+		"published":    true,
+	}
+}
+
+// findReleases returns matching releases sorted by version, newest first.
+// Versions are compared in Go since they do not sort as strings.
+func findReleases(ctx context.Context, releaseCollection *mongo.Collection, filter bson.M) ([]*models.Release, error) {
+	cursor, err := releaseCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list releases: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	releases := make([]*models.Release, 0)
+	if err := cursor.All(ctx, &releases); err != nil {
+		return nil, fmt.Errorf("failed to decode releases: %w", err)
+	}
+
+	sort.SliceStable(releases, func(i, j int) bool {
+		if releases[i].ProductName != releases[j].ProductName {
+			return releases[i].ProductName < releases[j].ProductName
+		}
+		if releases[i].Platform != releases[j].Platform {
+			return releases[i].Platform < releases[j].Platform
+		}
+		return models.CompareVersions(releases[i].Version, releases[j].Version) > 0
+	})
+	return releases, nil
+}
+
+// hashFile returns the size and hex SHA-256 of a local file
+func hashFile(path string) (int64, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	return hashReader(file)
+}
+
+// hashObject returns the size and hex SHA-256 of a stored object
+func hashObject(ctx context.Context, store storage.Storage, key string) (int64, string, error) {
+	body, err := store.Open(ctx, key, 0, -1)
+	if err != nil {
+		return 0, "", err
+	}
+	defer body.Close()
+	return hashReader(body)
+}
+
+func hashReader(r io.Reader) (int64, string, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, r)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
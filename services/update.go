@@ -0,0 +1,436 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"jinzmedia-atmt/config"
+	"jinzmedia-atmt/licensefile"
+	"jinzmedia-atmt/models"
+	"jinzmedia-atmt/storage"
+)
+
+// Defaults used when the updates section is not configured
+const (
+	defaultAppcastItems     = 10
+	defaultAppcastNewBuilds = 2
+	defaultSparkleMaxSize   = 256 << 20
+)
+
+// ErrSparkleTooLarge is returned when a file is too large to be signed for
+// Sparkle
+var ErrSparkleTooLarge = errors.New("file is too large to sign for Sparkle")
+
+var (
+	sparkleKey     ed25519.PrivateKey
+	sparkleKeyErr  error
+	sparkleKeyOnce sync.Once
+)
+
+// LoadSparkleKey loads the key that signs macOS updates for Sparkle. Safe
+// to call more than once. Without a configured key, appcasts are unsigned.
+func LoadSparkleKey() error {
+	sparkleKeyOnce.Do(func() {
+		keyFile := config.Get().Updates.SparkleKeyFile
+		if keyFile == "" {
+			return
+		}
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			sparkleKeyErr = fmt.Errorf("failed to load Sparkle key: %w", err)
+			return
+		}
+		sparkleKey, sparkleKeyErr = licensefile.ParsePrivateKey(data)
+	})
+	return sparkleKeyErr
+}
+
+// sparkleSigning reports whether macOS builds are signed for Sparkle
+func sparkleSigning() (bool, error) {
+	if err := LoadSparkleKey(); err != nil {
+		return false, err
+	}
+	return sparkleKey != nil, nil
+}
+
+// sparkleMaxSize returns the largest macOS build that is signed for
+// Sparkle. Sparkle signs the whole file with plain Ed25519, so the file
+// must be held in memory while it is signed.
+func sparkleMaxSize(cfg *config.Config) int64 {
+	if cfg.Updates.SparkleMaxSize > 0 {
+		return cfg.Updates.SparkleMaxSize
+	}
+	return defaultSparkleMaxSize
+}
+
+// checkSparkleSize refuses a macOS build too large to be signed for
+// Sparkle, when a Sparkle key is configured
+func checkSparkleSize(cfg *config.Config, platform string, size int64) error {
+	if platform != string(models.PlatformMacOS) {
+		return nil
+	}
+	if signing, err := sparkleSigning(); err != nil || !signing {
+		return err
+	}
+	if size > sparkleMaxSize(cfg) {
+		return fmt.Errorf("%w: macOS builds signed for Sparkle must not exceed %d bytes", ErrInvalidRelease, sparkleMaxSize(cfg))
+	}
+	return nil
+}
+
+// signSparkle returns the EdDSA signature Sparkle verifies before
+// installing an update. Each file is signed once, when it is created, and
+// the signature stored with it; appcast requests never sign.
+func signSparkle(data []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(sparkleKey, data))
+}
+
+// signSparkleFile signs a local file of at most maxSize bytes for Sparkle,
+// or returns "" when no Sparkle key is configured
+func signSparkleFile(filePath string, maxSize int64) (string, error) {
+	if signing, err := sparkleSigning(); err != nil || !signing {
+		return "", err
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read build: %w", err)
+	}
+	defer file.Close()
+	return signSparkleReader(file, maxSize)
+}
+
+// signSparkleObject signs a stored file of at most maxSize bytes for
+// Sparkle, or returns "" when no Sparkle key is configured
+func signSparkleObject(ctx context.Context, store storage.Storage, key string, maxSize int64) (string, error) {
+	if signing, err := sparkleSigning(); err != nil || !signing {
+		return "", err
+	}
+	body, err := store.Open(ctx, key, 0, -1)
+	if err != nil {
+		return "", fmt.Errorf("failed to read release file: %w", err)
+	}
+	defer body.Close()
+	return signSparkleReader(body, maxSize)
+}
+
+func signSparkleReader(r io.Reader, maxSize int64) (string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read build: %w", err)
+	}
+	if int64(len(data)) > maxSize {
+		return "", fmt.Errorf("%w: file exceeds %d bytes", ErrSparkleTooLarge, maxSize)
+	}
+	return signSparkle(data), nil
+}
+
+// CheckForUpdate returns the newest release in the channel that is newer
+// than current and supports the app's OS version, in the build that best
+// matches the app's architecture. Only users who still own the product are
+// offered updates.
+func (ds *DownloadService) CheckForUpdate(ctx context.Context, userID primitive.ObjectID, productName, platform string, arch models.Arch, current, osVersion string, channel models.ReleaseChannel) (*models.UpdateResponse, error) {
+	secret, err := ds.linkSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := ds.checkDownloadAccess(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	releases, err := findReleases(ctx, ds.releaseCollection, publishedFilter(productName, platform, channel))
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.UpdateResponse{CurrentVersion: current}
+	for _, release := range bestBuilds(releases, archPreference(platform, arch)) {
+		if current != "" && models.CompareVersions(release.Version, current) <= 0 {
+			break
+		}
+		if osVersion != "" && release.MinOSVersion != "" && models.CompareVersions(osVersion, release.MinOSVersion) < 0 {
+			continue
+		}
+
+		downloadInfo, err := ds.getReleaseDownloadInfo(ctx, release)
+		if err != nil {
+			return nil, err
+		}
+		link := ds.signedLink(secret, user.ID, productName, platform, downloadInfo)
+		// The link opens the user's watermarked copy; report its size and checksum
+		if _, err := ds.watermarkDownload(ctx, user, productName, platform, downloadInfo); err != nil {
+			return nil, err
+		}
+
+		response.UpdateAvailable = true
+		response.Update = &models.UpdateInfo{
+			Version:      release.Version,
+			Channel:      release.Channel,
+			Arch:         release.Arch,
+			ReleaseNotes: release.ReleaseNotes,
+			MinOSVersion: release.MinOSVersion,
+			PublishedAt:  release.PublishedAt,
+			Filename:     release.Filename,
+			Size:         downloadInfo.Size,
+			SHA256:       downloadInfo.SHA256,
+			URL:          link.URL,
+			ExpiresAt:    link.ExpiresAt,
+		}
+		break
+	}
+
+	return response, nil
+}
+
+// appcast is a Sparkle RSS feed
+type appcast struct {
+	XMLName   xml.Name      `xml:"rss"`
+	Version   string        `xml:"version,attr"`
+	SparkleNS string        `xml:"xmlns:sparkle,attr"`
+	Title     string        `xml:"channel>title"`
+	Items     []appcastItem `xml:"channel>item"`
+}
+
+type appcastItem struct {
+	Title                string           `xml:"title"`
+	PubDate              string           `xml:"pubDate,omitempty"`
+	Version              string           `xml:"sparkle:version"`
+	ShortVersionString   string           `xml:"sparkle:shortVersionString"`
+	MinimumSystemVersion string           `xml:"sparkle:minimumSystemVersion,omitempty"`
+	Description          *appcastCDATA    `xml:"description,omitempty"`
+	Enclosure            appcastEnclosure `xml:"enclosure"`
+}
+
+type appcastCDATA struct {
+	Text string `xml:",cdata"`
+}
+
+type appcastEnclosure struct {
+	URL         string `xml:"url,attr"`
+	Length      int64  `xml:"length,attr"`
+	Type        string `xml:"type,attr"`
+	EdSignature string `xml:"sparkle:edSignature,attr,omitempty"`
+}
+
+// Appcast returns a Sparkle appcast of the newest macOS releases in the
+// channel, in the builds that best match arch (empty if unknown), with
+// enclosures pointing at signed download links. Making a user's
+// watermarked copy is costly, so a request does it for at most
+// updates.appcast_new_builds releases, newest first; older releases are
+// left out until their copy exists. With a Sparkle key, files that have no
+// stored signature yet are left out as well.
+func (ds *DownloadService) Appcast(ctx context.Context, userID primitive.ObjectID, productName string, arch models.Arch, channel models.ReleaseChannel) ([]byte, error) {
+	if err := LoadSparkleKey(); err != nil {
+		return nil, err
+	}
+	secret, err := ds.linkSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := ds.checkDownloadAccess(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	platform := string(models.PlatformMacOS)
+	releases, err := findReleases(ctx, ds.releaseCollection, publishedFilter(productName, platform, channel))
+	if err != nil {
+		return nil, err
+	}
+	releases = bestBuilds(releases, archPreference(platform, arch))
+	if len(releases) > ds.appcastItems() {
+		releases = releases[:ds.appcastItems()]
+	}
+
+	title := productName
+	if product, ok := models.GetProduct(productName); ok {
+		title = product.DisplayName
+	}
+
+	feed := appcast{
+		Version:   "2.0",
+		SparkleNS: "http://www.andymatuschak.org/xml-namespaces/sparkle",
+		Title:     title,
+		Items:     make([]appcastItem, 0, len(releases)),
+	}
+	newBuilds := 0
+	for _, release := range releases {
+		downloadInfo, err := ds.getReleaseDownloadInfo(ctx, release)
+		if err != nil {
+			// A missing file must not hide the remaining releases
+			log.Printf("DOWNLOAD ERROR: Release %s of %s/%s is not available: %v", release.Version, productName, platform, err)
+			continue
+		}
+		ready, err := ds.appcastItemReady(ctx, user, productName, downloadInfo)
+		if err != nil {
+			return nil, err
+		}
+		if !ready {
+			if newBuilds >= ds.appcastNewBuilds() {
+				continue
+			}
+			newBuilds++
+		}
+		link := ds.signedLink(secret, user.ID, productName, platform, downloadInfo)
+		// Sparkle checks the length and signature of the user's own copy
+		build, err := ds.watermarkDownload(ctx, user, productName, platform, downloadInfo)
+		if err != nil {
+			return nil, err
+		}
+
+		item := appcastItem{
+			Title:                "Version " + release.Version,
+			Version:              release.Version,
+			ShortVersionString:   release.Version,
+			MinimumSystemVersion: release.MinOSVersion,
+			Enclosure: appcastEnclosure{
+				URL:    link.URL,
+				Length: downloadInfo.Size,
+				Type:   "application/octet-stream",
+			},
+		}
+		if release.PublishedAt != nil {
+			item.PubDate = release.PublishedAt.UTC().Format(time.RFC1123Z)
+		}
+		if release.ReleaseNotes != "" {
+			item.Description = &appcastCDATA{Text: release.ReleaseNotes}
+		}
+		if sparkleKey != nil {
+			signature := release.SparkleSignature
+			if build != nil {
+				signature = build.SparkleSignature
+			}
+			if signature == "" {
+				// Sparkle refuses unsigned updates; list it once it is signed
+				log.Printf("DOWNLOAD ERROR: %s is not signed for Sparkle yet; leaving it out of the appcast", downloadInfo.Key)
+				continue
+			}
+			item.Enclosure.EdSignature = signature
+		}
+		feed.Items = append(feed.Items, item)
+	}
+
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode appcast: %w", err)
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// appcastItemReady reports whether a release can be listed without making
+// the user's watermarked copy
+func (ds *DownloadService) appcastItemReady(ctx context.Context, user *models.User, productName string, downloadInfo *models.DownloadInfo) (bool, error) {
+	if !ds.watermarked(productName) {
+		return true, nil
+	}
+	if _, ok := unmarkableBuilds.Load(downloadInfo.SHA256); ok {
+		return true, nil
+	}
+
+	var build models.WatermarkedBuild
+	err := ds.watermarkCollection.FindOne(ctx, bson.M{"_id": watermarkedBuildID(user, downloadInfo)}).Decode(&build)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to find watermarked build: %w", err)
+	}
+	return true, nil
+}
+
+// SignSparkleBuilds signs, one at a time, the macOS releases and watermarked
+// copies stored before Sparkle signing was configured, so appcasts can list
+// them. It does nothing without a Sparkle key and is meant to run in the
+// background at startup. Files over updates.sparkle_max_size are skipped.
+func (ds *DownloadService) SignSparkleBuilds(ctx context.Context) {
+	if signing, err := sparkleSigning(); err != nil || !signing {
+		return
+	}
+
+	unsigned := bson.M{
+		"platform":          string(models.PlatformMacOS),
+		"sparkle_signature": bson.M{"$exists": false},
+	}
+	ds.signSparkleCollection(ctx, ds.releaseCollection, unsigned)
+	ds.signSparkleCollection(ctx, ds.watermarkCollection, unsigned)
+}
+
+// signSparkleCollection signs the files of the releases or watermarked
+// builds matching filter
+func (ds *DownloadService) signSparkleCollection(ctx context.Context, collection *mongo.Collection, filter bson.M) {
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		log.Printf("DOWNLOAD ERROR: Failed to find builds to sign for Sparkle: %v", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	maxSize := sparkleMaxSize(ds.cfg)
+	signed := 0
+	for cursor.Next(ctx) {
+		var doc struct {
+			ID   interface{} `bson:"_id"`
+			Key  string      `bson:"key"`       // watermarked builds
+			Path string      `bson:"file_path"` // releases
+			Size int64       `bson:"size"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			log.Printf("DOWNLOAD ERROR: Failed to decode build to sign for Sparkle: %v", err)
+			continue
+		}
+		key := doc.Key
+		if key == "" {
+			key = doc.Path
+		}
+		if doc.Size > maxSize {
+			log.Printf("DOWNLOAD ERROR: %s is larger than updates.sparkle_max_size and is not signed for Sparkle", key)
+			continue
+		}
+
+		signature, err := signSparkleObject(ctx, ds.storage, key, maxSize)
+		if err != nil {
+			log.Printf("DOWNLOAD ERROR: Failed to sign %s for Sparkle: %v", key, err)
+			continue
+		}
+		if _, err := collection.UpdateOne(ctx,
+			bson.M{"_id": doc.ID, "sparkle_signature": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"sparkle_signature": signature}},
+		); err != nil {
+			log.Printf("DOWNLOAD ERROR: Failed to store Sparkle signature of %s: %v", key, err)
+			continue
+		}
+		signed++
+	}
+	if signed > 0 {
+		log.Printf("DOWNLOAD: Signed %d stored builds for Sparkle", signed)
+	}
+}
+
+func (ds *DownloadService) appcastNewBuilds() int {
+	if ds.cfg.Updates.AppcastNewBuilds > 0 {
+		return ds.cfg.Updates.AppcastNewBuilds
+	}
+	return defaultAppcastNewBuilds
+}
+
+func (ds *DownloadService) appcastItems() int {
+	if ds.cfg.Updates.AppcastItems > 0 {
+		return ds.cfg.Updates.AppcastItems
+	}
+	return defaultAppcastItems
+}
@@ -0,0 +1,368 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"jinzmedia-atmt/config"
+	"jinzmedia-atmt/models"
+	"jinzmedia-atmt/storage"
+	"jinzmedia-atmt/watermark"
+)
+
+// ErrWatermarkDisabled is returned when watermarked products are
+// configured without a secret, or a file is traced without one
+var ErrWatermarkDisabled = errors.New("watermark secret is not configured")
+
+// watermarkKeyPrefix is the storage prefix of per-user copies
+const watermarkKeyPrefix = "watermarked"
+
+// unmarkableBuilds holds the checksums of builds that cannot carry a mark,
+// so they are not copied again on every download
+var unmarkableBuilds sync.Map
+
+// watermarked reports whether builds of the product carry per-user marks
+func (ds *DownloadService) watermarked(productName string) bool {
+	return watermarkedProduct(ds.cfg, productName)
+}
+
+func watermarkedProduct(cfg *config.Config, productName string) bool {
+	for _, name := range cfg.Downloads.Watermark.Products {
+		if name == productName {
+			return true
+		}
+	}
+	return false
+}
+
+// unmarkable reports whether err means a build cannot carry a mark
+func unmarkable(err error) bool {
+	return errors.Is(err, watermark.ErrSigned) || errors.Is(err, watermark.ErrUnsupported)
+}
+
+// checkWatermarkable returns a note for admins when the file cannot carry
+// a mark, or "" when it can
+func checkWatermarkable(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open build: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to open build: %w", err)
+	}
+	if err := watermark.Check(file, info.Size()); err != nil {
+		return watermarkNote(err), nil
+	}
+	return "", nil
+}
+
+// watermarkNote explains to admins why a build is served without a mark
+func watermarkNote(err error) string {
+	if errors.Is(err, watermark.ErrSigned) {
+		return "Code-signed build: marking it would break the signature, so it is served without a per-user mark"
+	}
+	return "The file format does not allow a mark, so the build is served without a per-user mark"
+}
+
+// watermarkDownload switches downloadInfo to the user's watermarked copy of
+// the file when the product is watermarked, creating the copy on first
+// use. Builds that cannot carry a mark are served as they are and noted on
+// their release for admins. downloadInfo must carry the checksum of the
+// original file; ErrFilePreparing is returned while a legacy file's
+// checksum is not known yet.
+func (ds *DownloadService) watermarkDownload(ctx context.Context, user *models.User, productName, platform string, downloadInfo *models.DownloadInfo) (*models.WatermarkedBuild, error) {
+	if !ds.watermarked(productName) {
+		return nil, nil
+	}
+	if downloadInfo.SHA256 == "" {
+		// Copies are keyed by the checksum of the original
+		return nil, ErrFilePreparing
+	}
+	if _, ok := unmarkableBuilds.Load(downloadInfo.SHA256); ok {
+		return nil, nil
+	}
+
+	build, fileInfo, err := ds.watermarkedBuild(ctx, user, productName, platform, downloadInfo)
+	if unmarkable(err) {
+		log.Printf("DOWNLOAD ERROR: Serving %s without a mark: %v", downloadInfo.Key, err)
+		unmarkableBuilds.Store(downloadInfo.SHA256, struct{}{})
+		if _, err := ds.releaseCollection.UpdateMany(ctx,
+			bson.M{"file_path": downloadInfo.Key, "watermark_note": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"watermark_note": watermarkNote(err)}},
+		); err != nil {
+			log.Printf("DOWNLOAD ERROR: Failed to note unmarked release %s: %v", downloadInfo.Key, err)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	downloadInfo.Key = build.Key
+	downloadInfo.Size = build.Size
+	downloadInfo.SHA256 = build.SHA256
+	downloadInfo.ModTime = fileInfo.ModTime
+	return build, nil
+}
+
+// watermarkedBuild returns the user's copy of a file, making one when it
+// does not exist yet or was removed from storage
+func (ds *DownloadService) watermarkedBuild(ctx context.Context, user *models.User, productName, platform string, downloadInfo *models.DownloadInfo) (*models.WatermarkedBuild, *storage.ObjectInfo, error) {
+	id := watermarkedBuildID(user, downloadInfo)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		var build models.WatermarkedBuild
+		err := ds.watermarkCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&build)
+		if err == nil {
+			fileInfo, err := ds.storage.Stat(ctx, build.Key)
+			if err == nil {
+				return &build, fileInfo, nil
+			}
+			if err != storage.ErrNotExist {
+				return nil, nil, fmt.Errorf("failed to access watermarked build: %w", err)
+			}
+			// The copy was removed from storage; make a new one
+			if _, err := ds.watermarkCollection.DeleteOne(ctx, bson.M{"_id": id, "key": build.Key}); err != nil {
+				return nil, nil, fmt.Errorf("failed to delete watermarked build: %w", err)
+			}
+		} else if err != mongo.ErrNoDocuments {
+			return nil, nil, fmt.Errorf("failed to find watermarked build: %w", err)
+		}
+
+		created, err := ds.createWatermarkedBuild(ctx, id, user, productName, platform, downloadInfo)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := ds.watermarkCollection.InsertOne(ctx, created); err != nil {
+			if delErr := ds.storage.Delete(ctx, created.Key); delErr != nil {
+				log.Printf("DOWNLOAD ERROR: Failed to delete %s: %v", created.Key, delErr)
+			}
+			if mongo.IsDuplicateKeyError(err) {
+				// A concurrent request made a copy first; serve that one
+				continue
+			}
+			return nil, nil, fmt.Errorf("failed to save watermarked build: %w", err)
+		}
+
+		fileInfo, err := ds.statFile(ctx, created.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		return created, fileInfo, nil
+	}
+	return nil, nil, fmt.Errorf("failed to create watermarked build %s", id)
+}
+
+// watermarkedBuildID identifies a user's copy of a file
+func watermarkedBuildID(user *models.User, downloadInfo *models.DownloadInfo) string {
+	return user.ID.Hex() + ":" + downloadInfo.SHA256
+}
+
+// createWatermarkedBuild stores a copy of a file with the user's mark
+func (ds *DownloadService) createWatermarkedBuild(ctx context.Context, id string, user *models.User, productName, platform string, downloadInfo *models.DownloadInfo) (*models.WatermarkedBuild, error) {
+	secret := ds.cfg.Downloads.Watermark.Secret
+	if secret == "" {
+		return nil, ErrWatermarkDisabled
+	}
+
+	issuedAt := time.Now().UTC()
+	block, err := watermark.Seal(&watermark.Mark{
+		UserID:       user.ID.Hex(),
+		SerialNumber: user.SerialNumber,
+		Product:      productName,
+		Platform:     platform,
+		Version:      downloadInfo.Version,
+		IssuedAt:     issuedAt,
+	}, []byte(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	// Marks are placed by offset, so work on a local copy of the original
+	source, err := os.CreateTemp("", "atmt-watermark-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(source.Name())
+	defer source.Close()
+
+	body, err := ds.storage.Open(ctx, downloadInfo.Key, 0, -1)
+	if err != nil {
+		if err == storage.ErrNotExist {
+			return nil, ErrFileNotFound
+		}
+		return nil, fmt.Errorf("failed to read build: %w", err)
+	}
+	size, err := io.Copy(source, body)
+	body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read build: %w", err)
+	}
+
+	marked, markedSize, method, err := watermark.Embed(source, size, block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watermark %s: %w", downloadInfo.Key, err)
+	}
+
+	// macOS copies are signed for Sparkle from a local file once stored.
+	// The original was held to updates.sparkle_max_size when it was
+	// created; older, larger builds are served unsigned.
+	signing := false
+	if platform == string(models.PlatformMacOS) {
+		if signing, err = sparkleSigning(); err != nil {
+			return nil, err
+		}
+		if signing && size > sparkleMaxSize(ds.cfg) {
+			log.Printf("DOWNLOAD ERROR: %s is larger than updates.sparkle_max_size; its copies are not signed for Sparkle", downloadInfo.Key)
+			signing = false
+		}
+	}
+	var markedFile *os.File
+	if signing {
+		if markedFile, err = os.CreateTemp("", "atmt-watermark-*"); err != nil {
+			return nil, fmt.Errorf("failed to create temporary file: %w", err)
+		}
+		defer os.Remove(markedFile.Name())
+		defer markedFile.Close()
+		if _, err := io.Copy(markedFile, marked); err != nil {
+			return nil, fmt.Errorf("failed to watermark %s: %w", downloadInfo.Key, err)
+		}
+		if _, err := markedFile.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to watermark %s: %w", downloadInfo.Key, err)
+		}
+		marked = markedFile
+	}
+
+	key := path.Join(watermarkKeyPrefix, productName, platform, user.ID.Hex(), primitive.NewObjectID().Hex(), downloadInfo.Filename)
+	hash := sha256.New()
+	if err := ds.storage.Put(ctx, key, io.TeeReader(marked, hash), markedSize); err != nil {
+		return nil, fmt.Errorf("failed to store watermarked build: %w", err)
+	}
+
+	var signature string
+	if signing {
+		if signature, err = signSparkleFile(markedFile.Name(), markedSize); err != nil {
+			if delErr := ds.storage.Delete(ctx, key); delErr != nil {
+				log.Printf("DOWNLOAD ERROR: Failed to delete %s: %v", key, delErr)
+			}
+			return nil, err
+		}
+	}
+
+	return &models.WatermarkedBuild{
+		ID:           id,
+		UserID:       user.ID,
+		ProductName:  productName,
+		Platform:     platform,
+		Version:      downloadInfo.Version,
+		SourceSHA256: downloadInfo.SHA256,
+		Key:          key,
+		Size:         markedSize,
+		SHA256:       hex.EncodeToString(hash.Sum(nil)),
+		Method:       method,
+		IssuedAt:     issuedAt,
+
+		SparkleSignature: signature,
+	}, nil
+}
+
+// TraceFile identifies the account a suspicious file was issued to, from
+// its mark or from the checksum of a served copy
+func (ds *DownloadService) TraceFile(ctx context.Context, body io.Reader) (*models.WatermarkReport, error) {
+	secret := ds.cfg.Downloads.Watermark.Secret
+	if secret == "" {
+		return nil, ErrWatermarkDisabled
+	}
+
+	file, err := os.CreateTemp("", "atmt-trace-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(file, io.TeeReader(io.LimitReader(body, ds.traceMaxSize()+1), hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if size > ds.traceMaxSize() {
+		return nil, ErrUploadTooLarge
+	}
+
+	report := &models.WatermarkReport{SHA256: hex.EncodeToString(hash.Sum(nil)), Size: size}
+
+	var userID primitive.ObjectID
+	mark, err := watermark.Extract(file, size, []byte(secret))
+	switch err {
+	case nil:
+		report.Found = true
+		report.Mark = &models.WatermarkMark{
+			UserID:       mark.UserID,
+			SerialNumber: mark.SerialNumber,
+			ProductName:  mark.Product,
+			Platform:     mark.Platform,
+			Version:      mark.Version,
+			IssuedAt:     mark.IssuedAt,
+		}
+		userID, _ = primitive.ObjectIDFromHex(mark.UserID)
+	case watermark.ErrInvalid:
+		report.Unreadable = true
+	case watermark.ErrNotFound:
+	default:
+		return nil, err
+	}
+
+	var build models.WatermarkedBuild
+	err = ds.watermarkCollection.FindOne(ctx, bson.M{"sha256": report.SHA256}).Decode(&build)
+	if err == nil {
+		report.Build = &build
+		if userID.IsZero() {
+			userID = build.UserID
+		}
+	} else if err != mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("failed to find watermarked build: %w", err)
+	}
+
+	if !userID.IsZero() {
+		var user models.User
+		err := ds.userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+		if err == nil {
+			report.User = &models.WatermarkUser{
+				ID:           user.ID,
+				Email:        user.Email,
+				FullName:     user.FullName,
+				SerialNumber: user.SerialNumber,
+				IsBanned:     user.IsBanned,
+				DeletedAt:    user.DeletedAt,
+			}
+		} else if err != mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("failed to get user: %w", err)
+		}
+	}
+
+	return report, nil
+}
+
+func (ds *DownloadService) traceMaxSize() int64 {
+	if ds.cfg.FileUpload.BuildMaxSize > 0 {
+		return ds.cfg.FileUpload.BuildMaxSize
+	}
+	return defaultBuildMaxSize
+}
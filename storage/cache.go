@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// CachedStorage remembers Stat results, including missing objects, so
+// listing products does not touch the disk or bucket on every request.
+// Writes through the same instance invalidate the cached entry.
+type CachedStorage struct {
+	Storage
+	ttl time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]statEntry
+	lastSweep time.Time
+}
+
+type statEntry struct {
+	info    *ObjectInfo
+	err     error
+	expires time.Time
+}
+
+// NewCachedStorage wraps s, caching Stat results for ttl
+func NewCachedStorage(s Storage, ttl time.Duration) *CachedStorage {
+	return &CachedStorage{
+		Storage:   s,
+		ttl:       ttl,
+		entries:   make(map[string]statEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// Stat returns the cached result for key, or asks the backend
+func (c *CachedStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.info, entry.err
+	}
+
+	info, err := c.Storage.Stat(ctx, key)
+	if err != nil && err != ErrNotExist {
+		// Do not cache transient failures
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.sweepLocked(now)
+	c.entries[key] = statEntry{info: info, err: err, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return info, err
+}
+
+// sweepLocked drops expired entries, at most once per ttl, so keys that are
+// not asked for again (such as per-user copies) do not accumulate
+func (c *CachedStorage) sweepLocked(now time.Time) {
+	if now.Sub(c.lastSweep) < c.ttl {
+		return
+	}
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.lastSweep = now
+}
+
+// Put stores the object and forgets its cached Stat result
+func (c *CachedStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	defer c.forget(key)
+	return c.Storage.Put(ctx, key, r, size)
+}
+
+// Delete removes the object and forgets its cached Stat result
+func (c *CachedStorage) Delete(ctx context.Context, key string) error {
+	defer c.forget(key)
+	return c.Storage.Delete(ctx, key)
+}
+
+func (c *CachedStorage) forget(key string) {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
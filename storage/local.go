@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultLocalPath is used when storage.local_path is not set
+const defaultLocalPath = "dist"
+
+// LocalStorage keeps objects as files below a root directory
+type LocalStorage struct {
+	root string
+}
+
+// NewLocalStorage creates a local storage rooted at dir
+func NewLocalStorage(dir string) *LocalStorage {
+	if dir == "" {
+		dir = defaultLocalPath
+	}
+	return &LocalStorage{root: dir}
+}
+
+// Stat returns the size and modification time of a file
+func (s *LocalStorage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	key, filePath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotExist
+		}
+		return nil, fmt.Errorf("failed to access file: %w", err)
+	}
+	if fileInfo.IsDir() {
+		return nil, ErrNotExist
+	}
+
+	return &ObjectInfo{Key: key, Size: fileInfo.Size(), ModTime: fileInfo.ModTime()}, nil
+}
+
+// Open reads part of a file
+func (s *LocalStorage) Open(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	_, filePath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotExist
+		}
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	if length < 0 {
+		return file, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+// Put writes a file next to its destination and renames it into place, so
+// readers never see a partial file
+func (s *LocalStorage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+
+	written, err := io.Copy(tmp, io.LimitReader(r, size))
+	if err == nil && written != size {
+		err = fmt.Errorf("short write: %d of %d bytes", written, size)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filePath)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a file
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	_, filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	return nil
+}
+
+// Presign is not supported; files on local disk are served by the API
+func (s *LocalStorage) Presign(ctx context.Context, key, filename string, expires time.Duration) (string, error) {
+	return "", ErrPresignUnsupported
+}
+
+func (s *LocalStorage) path(key string) (string, string, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	return key, filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,368 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"jinzmedia-atmt/config"
+)
+
+const (
+	// defaultS3Region is used when storage.s3.region is not set
+	defaultS3Region = "us-east-1"
+
+	// maxPresignExpiration is the longest lifetime S3 accepts for a
+	// presigned URL
+	maxPresignExpiration = 7 * 24 * time.Hour
+
+	s3Algorithm      = "AWS4-HMAC-SHA256"
+	s3TimeFormat     = "20060102T150405Z"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Storage keeps objects in a bucket of Amazon S3 or a compatible service
+// such as MinIO. Requests are signed with AWS Signature Version 4.
+type S3Storage struct {
+	endpoint        *url.URL
+	region          string
+	bucket          string
+	prefix          string
+	accessKeyID     string
+	secretAccessKey string
+	pathStyle       bool
+	client          *http.Client
+}
+
+// NewS3Storage creates an S3 storage from config
+func NewS3Storage(cfg *config.S3StorageConfig) (*S3Storage, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint: %q", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("S3 bucket is required")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("S3 access key ID and secret access key are required")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = defaultS3Region
+	}
+
+	return &S3Storage{
+		endpoint:        endpoint,
+		region:          region,
+		bucket:          cfg.Bucket,
+		prefix:          strings.TrimPrefix(cfg.Prefix, "/"),
+		accessKeyID:     cfg.AccessKeyID,
+		secretAccessKey: cfg.SecretAccessKey,
+		pathStyle:       cfg.UsePathStyle,
+		// No overall timeout: large builds stream for as long as the
+		// request context allows
+		client: &http.Client{},
+	}, nil
+}
+
+// Stat returns the size and modification time of an object
+func (s *S3Storage) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrNotExist
+	default:
+		return nil, s3Error("stat", resp)
+	}
+
+	info := &ObjectInfo{Key: key, Size: resp.ContentLength}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return info, nil
+}
+
+// Open reads part of an object with a ranged GET
+func (s *S3Storage) Open(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	header := http.Header{}
+	switch {
+	case length > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0, header)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrNotExist
+	default:
+		defer resp.Body.Close()
+		return nil, s3Error("read", resp)
+	}
+}
+
+// Put uploads an object in a single request
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := s.do(ctx, http.MethodPut, key, io.LimitReader(r, size), size, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("write", resp)
+	}
+	return nil
+}
+
+// Delete removes an object
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return s3Error("delete", resp)
+}
+
+// Presign returns a GET URL signed in its query string. S3 sends the
+// object as an attachment named filename.
+func (s *S3Storage) Presign(ctx context.Context, key, filename string, expires time.Duration) (string, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return "", err
+	}
+	return s.presign(u, filename, expires, time.Now()), nil
+}
+
+// presign signs u for a GET at the given time
+func (s *S3Storage) presign(u *url.URL, filename string, expires time.Duration, now time.Time) string {
+	if expires > maxPresignExpiration {
+		expires = maxPresignExpiration
+	}
+	if expires < time.Second {
+		expires = time.Second
+	}
+
+	amzDate := now.UTC().Format(s3TimeFormat)
+	scope := s.scope(amzDate)
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", s3Algorithm)
+	query.Set("X-Amz-Credential", s.accessKeyID+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.FormatInt(int64(expires/time.Second), 10))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if filename != "" {
+		query.Set("response-content-disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	}
+
+	canonicalQuery := canonicalQueryString(query)
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		u.RawPath,
+		canonicalQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		unsignedPayload,
+	}, "\n")
+
+	u.RawQuery = canonicalQuery + "&X-Amz-Signature=" + s.signature(amzDate, scope, canonicalRequest)
+	return u.String()
+}
+
+// do sends a signed request for an object
+func (s *S3Storage) do(ctx context.Context, method, key string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	u, err := s.objectURL(key)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 request: %w", err)
+	}
+	req.URL = u
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		payloadHash = unsignedPayload
+	}
+	s.sign(req, payloadHash, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// sign adds the Authorization header of Signature Version 4 for a request
+// made at the given time
+func (s *S3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(s3TimeFormat)
+	scope := s.scope(amzDate)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.RawPath,
+		canonicalQueryString(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.accessKeyID, scope, signedHeaders, s.signature(amzDate, scope, canonicalRequest)))
+}
+
+func (s *S3Storage) signature(amzDate, scope, canonicalRequest string) string {
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := s3Algorithm + "\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), amzDate[:8])
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func (s *S3Storage) scope(amzDate string) string {
+	return amzDate[:8] + "/" + s.region + "/s3/aws4_request"
+}
+
+// objectURL returns the URL of a key, with RawPath set to the URI encoding
+// S3 expects in the canonical request
+func (s *S3Storage) objectURL(key string) (*url.URL, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	u := *s.endpoint
+	u.RawQuery = ""
+	objectPath := strings.TrimSuffix(u.Path, "/") + "/"
+	if s.pathStyle {
+		objectPath += s.bucket + "/"
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	objectPath += s.prefix + key
+
+	u.Path = objectPath
+	u.RawPath = uriEncode(objectPath, false)
+	return &u, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQueryString sorts and encodes query parameters for signing
+func canonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything except RFC 3986 unreserved
+// characters and, unless encodeSlash is set, "/"
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Error describes a failed S3 response, including the error code from
+// its XML body when there is one
+func s3Error(op string, resp *http.Response) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if xml.Unmarshal(data, &body) == nil && body.Code != "" {
+		return fmt.Errorf("S3 %s failed: %s: %s %s", op, resp.Status, body.Code, body.Message)
+	}
+	return fmt.Errorf("S3 %s failed: %s", op, resp.Status)
+}
@@ -0,0 +1,173 @@
+// Package storage keeps product files on local disk or in an S3-compatible
+// bucket behind one interface, so several API instances can serve the same
+// builds.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"jinzmedia-atmt/config"
+)
+
+var (
+	// ErrNotExist is returned for keys that have no object
+	ErrNotExist = errors.New("storage: object does not exist")
+	// ErrInvalidKey is returned for keys that are empty, absolute or leave
+	// the storage root
+	ErrInvalidKey = errors.New("storage: invalid key")
+	// ErrPresignUnsupported is returned by backends that cannot hand out
+	// direct URLs; the caller serves the object itself
+	ErrPresignUnsupported = errors.New("storage: presigned URLs are not supported")
+)
+
+// defaultStatCacheTTL is used when storage.stat_cache_ttl is not set
+const defaultStatCacheTTL = time.Minute
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage stores objects under slash-separated keys such as
+// "chatgpt/windows/1.4.0/ChatGPT-Setup.exe"
+type Storage interface {
+	// Stat returns the size and modification time of an object
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	// Open reads length bytes of an object starting at offset, or up to the
+	// end when length is negative
+	Open(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Put stores size bytes read from r, replacing any existing object
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Delete removes an object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+	// Presign returns a URL that downloads the object without credentials
+	// until it expires, saved as filename
+	Presign(ctx context.Context, key, filename string, expires time.Duration) (string, error)
+}
+
+var defaultStorage Storage
+
+// Init creates the storage selected by the storage driver in config. It
+// must be called before Default.
+func Init() error {
+	s, err := New()
+	if err != nil {
+		return err
+	}
+	defaultStorage = s
+	return nil
+}
+
+// Default returns the storage created by Init
+func Default() Storage {
+	if defaultStorage == nil {
+		panic("storage not initialized. Call storage.Init() first")
+	}
+	return defaultStorage
+}
+
+// New creates the storage selected by the storage driver in config, with
+// Stat results cached for storage.stat_cache_ttl
+func New() (Storage, error) {
+	cfg := config.Get().Storage
+
+	var backend Storage
+	switch cfg.Driver {
+	case "local", "":
+		backend = NewLocalStorage(cfg.LocalPath)
+	case "s3":
+		s3, err := NewS3Storage(&cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+		backend = s3
+	default:
+		return nil, fmt.Errorf("unknown storage driver: %s", cfg.Driver)
+	}
+
+	ttl := cfg.StatCacheTTL
+	if ttl <= 0 {
+		ttl = defaultStatCacheTTL
+	}
+	return NewCachedStorage(backend, ttl), nil
+}
+
+// CleanKey normalizes a key and rejects keys that would escape the root
+func CleanKey(key string) (string, error) {
+	key = strings.ReplaceAll(key, "\\", "/")
+	if key == "" || strings.HasPrefix(key, "/") {
+		return "", ErrInvalidKey
+	}
+	key = path.Clean(key)
+	if key == "." || key == ".." || strings.HasPrefix(key, "../") {
+		return "", ErrInvalidKey
+	}
+	return key, nil
+}
+
+// Reader reads an object as an io.ReadSeeker, so it can be passed to
+// http.ServeContent. Each seek followed by a read opens one ranged read.
+type Reader struct {
+	ctx    context.Context
+	s      Storage
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+// NewReader returns a reader over an object of the given size
+func NewReader(ctx context.Context, s Storage, key string, size int64) *Reader {
+	return &Reader{ctx: ctx, s: s, key: key, size: size}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.s.Open(r.ctx, r.key, r.offset, r.size-r.offset)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("storage: negative seek position")
+	}
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+// Close releases the current ranged read, if any
+func (r *Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,238 @@
+// Package validation checks request structs against their `validate` tags.
+//
+// Supported rules: required, omitempty, email, min, max, len, oneof and past.
+// min, max and len count characters for strings and elements for slices and
+// maps, and compare the value for numbers. past requires a time before now.
+// Rules are separated by commas; oneof takes space-separated values.
+// Messages are written in English; Errors.Localize translates them.
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"jinzmedia-atmt/i18n"
+	"jinzmedia-atmt/models"
+)
+
+// Errors lists every field that failed validation
+type Errors []models.FieldError
+
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, fe := range e {
+		parts[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Localize returns a copy of e with messages translated into lang
+func (e Errors) Localize(lang string) Errors {
+	localized := make(Errors, len(e))
+	for i, fe := range e {
+		localized[i] = fe
+		arg := displayParam(fe.Rule, fe.Param)
+		if arg == "" {
+			localized[i].Message = i18n.T(lang, fe.Message)
+			continue
+		}
+		format := strings.Replace(fe.Message, arg, "%s", 1)
+		localized[i].Message = i18n.Sprintf(lang, format, arg)
+	}
+	return localized
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Struct normalizes and validates v, which must be a pointer to a struct.
+// String fields with the email rule are trimmed and lowercased in place
+// before they are checked. It returns nil when all rules pass.
+func Struct(v interface{}) Errors {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		panic("validation: Struct requires a pointer to a struct")
+	}
+
+	var errs Errors
+	validateStruct(rv.Elem(), "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// NormalizeEmail trims and lowercases an email address
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func validateStruct(rv reflect.Value, prefix string, errs *Errors) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := prefix + jsonName(field)
+		value := rv.Field(i)
+		tag := field.Tag.Get("validate")
+
+		if tag != "" && tag != "-" {
+			validateField(value, name, strings.Split(tag, ","), errs)
+		}
+
+		// Descend into nested request structs
+		inner := value
+		if inner.Kind() == reflect.Ptr && !inner.IsNil() {
+			inner = inner.Elem()
+		}
+		if inner.Kind() == reflect.Struct && inner.Type() != timeType {
+			validateStruct(inner, name+".", errs)
+		}
+	}
+}
+
+func validateField(value reflect.Value, name string, rules []string, errs *Errors) {
+	// Optional pointers are only checked when present
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			if hasRule(rules, "required") {
+				*errs = append(*errs, fieldError(name, "required", "", "is required"))
+			}
+			return
+		}
+		value = value.Elem()
+	}
+
+	if hasRule(rules, "email") && value.Kind() == reflect.String && value.CanSet() {
+		value.SetString(NormalizeEmail(value.String()))
+	}
+
+	if isZero(value) {
+		if hasRule(rules, "required") {
+			*errs = append(*errs, fieldError(name, "required", "", "is required"))
+		}
+		return
+	}
+
+	for _, rule := range rules {
+		key, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if msg, ok := check(value, key, param); !ok {
+			*errs = append(*errs, fieldError(name, key, param, msg))
+			return
+		}
+	}
+}
+
+// check applies one rule to a non-zero value and returns a message on failure
+func check(value reflect.Value, rule, param string) (string, bool) {
+	switch rule {
+	case "", "required", "omitempty":
+		return "", true
+
+	case "email":
+		addr, err := mail.ParseAddress(value.String())
+		if err != nil || addr.Address != value.String() {
+			return "must be a valid email address", false
+		}
+
+	case "min", "max", "len":
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validation: invalid %s parameter %q", rule, param))
+		}
+		size, unit := measure(value)
+		switch {
+		case rule == "min" && size < limit:
+			return fmt.Sprintf("must be at least %s%s", param, unit), false
+		case rule == "max" && size > limit:
+			return fmt.Sprintf("must be at most %s%s", param, unit), false
+		case rule == "len" && size != limit:
+			return fmt.Sprintf("must be exactly %s%s", param, unit), false
+		}
+
+	case "oneof":
+		allowed := strings.Fields(param)
+		current := fmt.Sprint(value.Interface())
+		for _, a := range allowed {
+			if current == a {
+				return "", true
+			}
+		}
+		return "must be one of: " + displayParam(rule, param), false
+
+	case "past":
+		t, ok := value.Interface().(time.Time)
+		if !ok || !t.Before(time.Now()) {
+			return "must be in the past", false
+		}
+
+	default:
+		panic(fmt.Sprintf("validation: unknown rule %q", rule))
+	}
+
+	return "", true
+}
+
+// measure returns the size compared by min, max and len with its unit
+func measure(value reflect.Value) (float64, string) {
+	switch value.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(value.String())), " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(value.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), ""
+	}
+	panic(fmt.Sprintf("validation: cannot measure %s", value.Kind()))
+}
+
+func isZero(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+func hasRule(rules []string, name string) bool {
+	for _, rule := range rules {
+		if strings.TrimSpace(rule) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonName returns the name a field has in request bodies
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func fieldError(field, rule, param, message string) models.FieldError {
+	return models.FieldError{Field: field, Rule: rule, Param: param, Message: message}
+}
+
+// displayParam returns a rule parameter as it appears in messages
+func displayParam(rule, param string) string {
+	if rule == "oneof" {
+		return strings.Join(strings.Fields(param), ", ")
+	}
+	return param
+}
@@ -0,0 +1,122 @@
+package watermark
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// PE layout offsets, see the PE format specification
+const (
+	peHeaderPointerOffset = 0x3c
+	coffHeaderSize        = 20
+	optionalMagicPE32     = 0x10b
+	optionalMagicPE32Plus = 0x20b
+	securityDirectory     = 4
+	certificateAlignment  = 8
+)
+
+// certificateTable is the Authenticode signature of a PE file. Its
+// location is recorded in the security data directory and it is excluded
+// from the signed hash.
+type certificateTable struct {
+	directoryOffset int64 // file offset of the security directory entry
+	offset          int64
+	size            int64
+	entryLength     int64 // dwLength of the single WIN_CERTIFICATE
+}
+
+// peCertificateTable returns the certificate table of a signed PE file, or
+// nil for unsigned PE files and other formats. A signed file whose table
+// is not a single certificate at the end of the file cannot carry a mark
+// without breaking the signature.
+func peCertificateTable(src io.ReaderAt, size int64) (*certificateTable, error) {
+	var dos [64]byte
+	if size < int64(len(dos)) {
+		return nil, nil
+	}
+	if _, err := src.ReadAt(dos[:], 0); err != nil || dos[0] != 'M' || dos[1] != 'Z' {
+		return nil, nil
+	}
+	peOffset := int64(binary.LittleEndian.Uint32(dos[peHeaderPointerOffset:]))
+
+	var header [4 + coffHeaderSize + 2]byte
+	if peOffset+int64(len(header)) > size {
+		return nil, nil
+	}
+	if _, err := src.ReadAt(header[:], peOffset); err != nil || string(header[:4]) != "PE\x00\x00" {
+		return nil, nil
+	}
+
+	optionalOffset := peOffset + 4 + coffHeaderSize
+	var countOffset, directoriesOffset int64
+	switch binary.LittleEndian.Uint16(header[4+coffHeaderSize:]) {
+	case optionalMagicPE32:
+		countOffset, directoriesOffset = 92, 96
+	case optionalMagicPE32Plus:
+		countOffset, directoriesOffset = 108, 112
+	default:
+		return nil, nil
+	}
+
+	var count [4]byte
+	if _, err := src.ReadAt(count[:], optionalOffset+countOffset); err != nil {
+		return nil, nil
+	}
+	if binary.LittleEndian.Uint32(count[:]) <= securityDirectory {
+		return nil, nil
+	}
+
+	directoryOffset := optionalOffset + directoriesOffset + securityDirectory*8
+	var directory [8]byte
+	if _, err := src.ReadAt(directory[:], directoryOffset); err != nil {
+		return nil, nil
+	}
+	table := &certificateTable{
+		directoryOffset: directoryOffset,
+		offset:          int64(binary.LittleEndian.Uint32(directory[0:])),
+		size:            int64(binary.LittleEndian.Uint32(directory[4:])),
+	}
+	if table.offset == 0 || table.size == 0 {
+		return nil, nil
+	}
+	if table.offset+table.size != size {
+		return nil, ErrUnsupported
+	}
+
+	var entry [4]byte
+	if _, err := src.ReadAt(entry[:], table.offset); err != nil {
+		return nil, ErrUnsupported
+	}
+	table.entryLength = int64(binary.LittleEndian.Uint32(entry[:]))
+	if align(table.entryLength, certificateAlignment) != table.size {
+		return nil, ErrUnsupported
+	}
+	return table, nil
+}
+
+// embed appends block to the certificate table and grows the table and
+// its single certificate entry to cover it
+func (t *certificateTable) embed(src io.ReaderAt, size int64, block []byte) (io.Reader, int64, string, error) {
+	newSize := align(t.size+int64(len(block)), certificateAlignment)
+	if newSize > int64(^uint32(0)) {
+		return nil, 0, "", ErrUnsupported
+	}
+
+	tail := make([]byte, newSize-t.size)
+	copy(tail, block)
+
+	directorySize := make([]byte, 4)
+	binary.LittleEndian.PutUint32(directorySize, uint32(newSize))
+	entryLength := make([]byte, 4)
+	binary.LittleEndian.PutUint32(entryLength, uint32(newSize))
+
+	edits := []edit{
+		{t.directoryOffset + 4, directorySize},
+		{t.offset, entryLength},
+	}
+	return splice(src, size, edits, tail), size + int64(len(tail)), MethodCertificate, nil
+}
+
+func align(n, to int64) int64 {
+	return (n + to - 1) / to * to
+}
@@ -0,0 +1,73 @@
+package watermark
+
+import (
+	"debug/macho"
+	"io"
+)
+
+const (
+	loadCmdCodeSignature = 0x1d // LC_CODE_SIGNATURE
+	udifTrailerSize      = 512
+)
+
+// Check reports whether a mark can be placed in src without breaking it.
+// It returns ErrSigned for code-signed Mach-O binaries, whose signature
+// covers the whole file so neither a slot nor appended data leaves it
+// valid, and ErrUnsupported for disk images, which end with a trailer that
+// must stay last. Authenticode-signed PE files are fine.
+func Check(src io.ReaderAt, size int64) error {
+	if machoSigned(src, size) {
+		return ErrSigned
+	}
+	if udifImage(src, size) {
+		return ErrUnsupported
+	}
+	return nil
+}
+
+// machoSigned reports whether src is a thin or universal Mach-O binary with
+// a code signature in any of its architectures
+func machoSigned(src io.ReaderAt, size int64) bool {
+	r := io.NewSectionReader(src, 0, size)
+
+	if fat, err := macho.NewFatFile(r); err == nil {
+		defer fat.Close()
+		for _, arch := range fat.Arches {
+			if hasCodeSignature(arch.File) {
+				return true
+			}
+		}
+		return false
+	}
+
+	f, err := macho.NewFile(r)
+	if err != nil {
+		// Not a Mach-O binary
+		return false
+	}
+	defer f.Close()
+	return hasCodeSignature(f)
+}
+
+func hasCodeSignature(f *macho.File) bool {
+	for _, load := range f.Loads {
+		raw := load.Raw()
+		if len(raw) >= 4 && f.ByteOrder.Uint32(raw) == loadCmdCodeSignature {
+			return true
+		}
+	}
+	return false
+}
+
+// udifImage reports whether src is a macOS disk image (UDIF), identified by
+// the "koly" trailer in its last 512 bytes
+func udifImage(src io.ReaderAt, size int64) bool {
+	if size < udifTrailerSize {
+		return false
+	}
+	var magic [4]byte
+	if _, err := src.ReadAt(magic[:], size-udifTrailerSize); err != nil {
+		return false
+	}
+	return string(magic[:]) == "koly"
+}
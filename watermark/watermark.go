@@ -0,0 +1,254 @@
+// Package watermark embeds a sealed per-user mark into product builds and
+// recovers it from copies found in the wild.
+//
+// A mark is sealed with AES-256-GCM under a key derived from a server
+// secret, so it cannot be read or forged without the secret, and stored in
+// a block:
+//
+//	magic (8 bytes) | length (uint16, big endian) | nonce (12 bytes) | ciphertext
+//
+// The block is placed, in order of preference:
+//   - at the end of the certificate table of an Authenticode-signed PE
+//     file, which the signature does not cover, so it stays valid
+//   - in a reserved slot compiled into the build: SlotMarker followed by
+//     zero bytes up to SlotSize, typically in a resource or data section;
+//     the file size does not change. Code signatures covering the slot
+//     are invalidated, so slots suit builds that are not code signed.
+//   - appended to the end of the file
+//
+// Code-signed Mach-O binaries and disk images cannot carry a mark; see Check.
+//
+// The package only depends on the standard library.
+package watermark
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SlotMarker starts a reserved slot in a build. Builds reserve SlotSize
+// bytes beginning with the marker and padded with zeros.
+const SlotMarker = "ATMT-WATERMARK-SLOT-V1\x00"
+
+// SlotSize is the number of bytes of a reserved slot, marker included
+const SlotSize = 1024
+
+// Methods of placing a mark
+const (
+	MethodSlot        = "slot"
+	MethodCertificate = "pe_certificate"
+	MethodAppended    = "appended"
+)
+
+// blockMagic starts a sealed block
+var blockMagic = []byte("\x89ATMTWM1")
+
+const (
+	blockHeaderSize = 8 + 2
+	nonceSize       = 12
+	maxSealedSize   = SlotSize - blockHeaderSize
+	scanChunkSize   = 1 << 20
+)
+
+var (
+	ErrNotFound    = errors.New("watermark: no mark found")
+	ErrInvalid     = errors.New("watermark: mark is corrupt or sealed with another secret")
+	ErrUnsupported = errors.New("watermark: file layout does not allow a mark")
+	ErrSigned      = errors.New("watermark: code signature does not allow a mark")
+)
+
+// Mark identifies the account a build was issued to
+type Mark struct {
+	UserID       string    `json:"user_id"`
+	SerialNumber string    `json:"serial_number"`
+	Product      string    `json:"product"`
+	Platform     string    `json:"platform"`
+	Version      string    `json:"version,omitempty"`
+	IssuedAt     time.Time `json:"issued_at"`
+}
+
+// Seal encrypts and authenticates a mark, returning the block to embed
+func Seal(mark *Mark, secret []byte) ([]byte, error) {
+	plaintext, err := json.Marshal(mark)
+	if err != nil {
+		return nil, fmt.Errorf("watermark: failed to encode mark: %w", err)
+	}
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("watermark: failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, blockMagic)
+	if len(sealed) > maxSealedSize {
+		return nil, fmt.Errorf("watermark: mark is too large (%d bytes)", len(sealed))
+	}
+
+	block := make([]byte, 0, blockHeaderSize+len(sealed))
+	block = append(block, blockMagic...)
+	block = binary.BigEndian.AppendUint16(block, uint16(len(sealed)))
+	return append(block, sealed...), nil
+}
+
+// Embed returns the contents of src with block placed in it, and the new
+// size. src is read lazily while the result is consumed.
+func Embed(src io.ReaderAt, size int64, block []byte) (io.Reader, int64, string, error) {
+	if len(block) > SlotSize {
+		return nil, 0, "", fmt.Errorf("watermark: block is too large (%d bytes)", len(block))
+	}
+
+	cert, err := peCertificateTable(src, size)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if cert != nil {
+		return cert.embed(src, size, block)
+	}
+	if err := Check(src, size); err != nil {
+		return nil, 0, "", err
+	}
+
+	offsets, err := scan(src, size, []byte(SlotMarker))
+	if err != nil {
+		return nil, 0, "", err
+	}
+	for _, offset := range offsets {
+		if offset+SlotSize <= size {
+			slot := make([]byte, SlotSize)
+			copy(slot, block)
+			return splice(src, size, []edit{{offset, slot}}, nil), size, MethodSlot, nil
+		}
+	}
+
+	return splice(src, size, nil, block), size + int64(len(block)), MethodAppended, nil
+}
+
+// Extract finds and opens the last mark in src
+func Extract(src io.ReaderAt, size int64, secret []byte) (*Mark, error) {
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	offsets, err := scan(src, size, blockMagic)
+	if err != nil {
+		return nil, err
+	}
+	if len(offsets) == 0 {
+		return nil, ErrNotFound
+	}
+
+	for i := len(offsets) - 1; i >= 0; i-- {
+		if mark, err := openBlock(src, size, offsets[i], aead); err == nil {
+			return mark, nil
+		}
+	}
+	return nil, ErrInvalid
+}
+
+func openBlock(src io.ReaderAt, size, offset int64, aead cipher.AEAD) (*Mark, error) {
+	header := make([]byte, blockHeaderSize)
+	if _, err := src.ReadAt(header, offset); err != nil {
+		return nil, ErrInvalid
+	}
+	length := int64(binary.BigEndian.Uint16(header[len(blockMagic):]))
+	if length < nonceSize+int64(aead.Overhead()) || offset+blockHeaderSize+length > size {
+		return nil, ErrInvalid
+	}
+
+	sealed := make([]byte, length)
+	if _, err := src.ReadAt(sealed, offset+blockHeaderSize); err != nil {
+		return nil, ErrInvalid
+	}
+	plaintext, err := aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], blockMagic)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	var mark Mark
+	if err := json.Unmarshal(plaintext, &mark); err != nil {
+		return nil, ErrInvalid
+	}
+	return &mark, nil
+}
+
+func newAEAD(secret []byte) (cipher.AEAD, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("watermark: secret is required")
+	}
+	key := sha256.Sum256(append([]byte("atmt-watermark-v1\n"), secret...))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// scan returns the offsets of every occurrence of pattern in src
+func scan(src io.ReaderAt, size int64, pattern []byte) ([]int64, error) {
+	var offsets []int64
+	buf := make([]byte, scanChunkSize+len(pattern)-1)
+	for start := int64(0); start < size; start += scanChunkSize {
+		n := int64(len(buf))
+		if start+n > size {
+			n = size - start
+		}
+		chunk := buf[:n]
+		if _, err := src.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("watermark: failed to read file: %w", err)
+		}
+		for i := 0; ; {
+			j := bytes.Index(chunk[i:], pattern)
+			if j < 0 {
+				break
+			}
+			offsets = append(offsets, start+int64(i+j))
+			i += j + 1
+		}
+	}
+	return dedupe(offsets), nil
+}
+
+// dedupe drops matches seen twice in the overlap between chunks
+func dedupe(offsets []int64) []int64 {
+	var out []int64
+	for _, offset := range offsets {
+		if len(out) == 0 || offset != out[len(out)-1] {
+			out = append(out, offset)
+		}
+	}
+	return out
+}
+
+// edit replaces bytes of the source at an offset
+type edit struct {
+	offset int64
+	data   []byte
+}
+
+// splice streams src with edits, which must be sorted and not overlap,
+// followed by tail
+func splice(src io.ReaderAt, size int64, edits []edit, tail []byte) io.Reader {
+	readers := make([]io.Reader, 0, 2*len(edits)+2)
+	pos := int64(0)
+	for _, e := range edits {
+		readers = append(readers, io.NewSectionReader(src, pos, e.offset-pos), bytes.NewReader(e.data))
+		pos = e.offset + int64(len(e.data))
+	}
+	readers = append(readers, io.NewSectionReader(src, pos, size-pos))
+	if len(tail) > 0 {
+		readers = append(readers, bytes.NewReader(tail))
+	}
+	return io.MultiReader(readers...)
+}